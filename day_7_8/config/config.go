@@ -0,0 +1,433 @@
+// Package config loads the settings of the books service. Each setting is
+// taken from the first of these that sets it:
+//
+//  1. a command-line flag, e.g. -listen
+//  2. an environment variable, e.g. BOOKS_LISTEN
+//  3. the config file named by -config or BOOKS_CONFIG, read as YAML or
+//     TOML depending on its extension (.yaml, .yml or .toml)
+//  4. the defaults in Default
+//
+// Load validates the result, so a bad setting stops the service at startup
+// rather than on the first request that needs it.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// the middlewares that can be enabled; they always run in this order
+const (
+	MiddlewareLogger        = "logger"
+	MiddlewareMetrics       = "metrics"
+	MiddlewareRecovery      = "recovery"
+	MiddlewareRequestFilter = "request_filter"
+	MiddlewareAuth          = "auth"
+	MiddlewareRateLimit     = "rate_limit"
+	MiddlewareActor         = "actor"
+	MiddlewareAuthorize     = "authorize"
+)
+
+var middlewareOrder = []string{
+	MiddlewareLogger, MiddlewareMetrics, MiddlewareRecovery, MiddlewareRequestFilter,
+	MiddlewareAuth, MiddlewareRateLimit, MiddlewareActor, MiddlewareAuthorize,
+}
+
+type Config struct {
+	// Mode is the gin mode: release, debug or test
+	Mode   string `yaml:"mode" toml:"mode"`
+	Listen string `yaml:"listen" toml:"listen"`
+	// TrustedProxies are the addresses or CIDRs whose X-Forwarded-For is
+	// believed; everyone else is known by the address they connect from
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	Timeouts       Timeouts `yaml:"timeouts" toml:"timeouts"`
+	Store          Store    `yaml:"store" toml:"store"`
+	// SeedPath is a JSON or CSV file of books loaded into an empty store
+	SeedPath string `yaml:"seed_path" toml:"seed_path"`
+	Log      Log    `yaml:"log" toml:"log"`
+	// Middlewares lists the middlewares to use. Auth and authorize are off
+	// by default, as they need keys and a policy.
+	Middlewares []string `yaml:"middlewares" toml:"middlewares"`
+	Auth        Auth     `yaml:"auth" toml:"auth"`
+	// PolicyFile is the role policy the authorize middleware enforces
+	PolicyFile string    `yaml:"policy_file" toml:"policy_file"`
+	RateLimit  RateLimit `yaml:"rate_limit" toml:"rate_limit"`
+	// Idempotency controls the replay of POST /books retries sent with an
+	// Idempotency-Key
+	Idempotency Idempotency `yaml:"idempotency" toml:"idempotency"`
+}
+
+type Timeouts struct {
+	ReadHeader Duration `yaml:"read_header" toml:"read_header"`
+	Read       Duration `yaml:"read" toml:"read"`
+	Write      Duration `yaml:"write" toml:"write"`
+	Idle       Duration `yaml:"idle" toml:"idle"`
+	// Shutdown is how long requests in flight get to finish on SIGTERM
+	Shutdown Duration `yaml:"shutdown" toml:"shutdown"`
+}
+
+type Store struct {
+	// Backend is memory, file or sqlite
+	Backend string `yaml:"backend" toml:"backend"`
+	// Path is the file store's directory or the SQLite database
+	Path string `yaml:"path" toml:"path"`
+	// TrashRetention is how long a deleted book can still be restored
+	TrashRetention Duration `yaml:"trash_retention" toml:"trash_retention"`
+}
+
+type Log struct {
+	// Level is debug, info, warn or error
+	Level string `yaml:"level" toml:"level"`
+	// SampleSuccess logs one in every SampleSuccess successful requests
+	SampleSuccess int `yaml:"sample_success" toml:"sample_success"`
+}
+
+type Auth struct {
+	APIKeysFile      string `yaml:"api_keys_file" toml:"api_keys_file"`
+	HMACKeyFile      string `yaml:"hmac_key_file" toml:"hmac_key_file"`
+	RSAPublicKeyFile string `yaml:"rsa_public_key_file" toml:"rsa_public_key_file"`
+	Issuer           string `yaml:"issuer" toml:"issuer"`
+	Audience         string `yaml:"audience" toml:"audience"`
+}
+
+// Quota is a token bucket, Burst requests at once and Refill more a second.
+type Quota struct {
+	Burst  int     `yaml:"burst" toml:"burst"`
+	Refill float64 `yaml:"refill" toml:"refill"`
+}
+
+type RateLimit struct {
+	IP  Quota `yaml:"ip" toml:"ip"`
+	Key Quota `yaml:"key" toml:"key"`
+	// Clients gives the named callers their own quota
+	Clients     map[string]Quota `yaml:"clients" toml:"clients"`
+	IdleTimeout Duration         `yaml:"idle_timeout" toml:"idle_timeout"`
+}
+
+type Idempotency struct {
+	// TTL is how long the first response for a key is replayed
+	TTL Duration `yaml:"ttl" toml:"ttl"`
+	// MaxEntries and MaxBytes bound the kept responses; past either the
+	// least recently used are dropped before their TTL is up
+	MaxEntries int `yaml:"max_entries" toml:"max_entries"`
+	MaxBytes   int `yaml:"max_bytes" toml:"max_bytes"`
+}
+
+// Duration reads as a Go duration string such as "15s" or "720h".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// Default is the configuration used where nothing else is set.
+func Default() Config {
+	return Config{
+		Mode:           "release",
+		Listen:         "localhost:8080",
+		TrustedProxies: []string{},
+		Timeouts: Timeouts{
+			ReadHeader: Duration{5 * time.Second},
+			Read:       Duration{15 * time.Second},
+			Write:      Duration{30 * time.Second},
+			Idle:       Duration{60 * time.Second},
+			Shutdown:   Duration{15 * time.Second},
+		},
+		Store: Store{Backend: "memory", TrashRetention: Duration{30 * 24 * time.Hour}},
+		Log:   Log{Level: "info", SampleSuccess: 1},
+		Middlewares: []string{
+			MiddlewareLogger, MiddlewareMetrics, MiddlewareRecovery, MiddlewareRequestFilter,
+			MiddlewareRateLimit, MiddlewareActor,
+		},
+		RateLimit: RateLimit{
+			IP:          Quota{Burst: 20, Refill: 10},
+			Key:         Quota{Burst: 100, Refill: 50},
+			IdleTimeout: Duration{10 * time.Minute},
+		},
+		Idempotency: Idempotency{TTL: Duration{24 * time.Hour}, MaxEntries: 10000, MaxBytes: 64 << 20},
+	}
+}
+
+// Load builds the configuration from the command-line arguments, the
+// environment and the config file, in that order of precedence.
+func Load(args []string, getenv func(string) string) (Config, error) {
+	config := Default()
+
+	// flags are parsed first to find -config, but applied last
+	var flagged []func()
+	set := func(apply func(string)) func(string) error {
+		return func(value string) error {
+			flagged = append(flagged, func() { apply(value) })
+			return nil
+		}
+	}
+	setDuration := func(field *Duration) func(string) error {
+		return func(value string) error {
+			var d Duration
+			if err := d.UnmarshalText([]byte(value)); err != nil {
+				return err
+			}
+			flagged = append(flagged, func() { *field = d })
+			return nil
+		}
+	}
+
+	flags := flag.NewFlagSet("books", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	configPath := flags.String("config", getenv("BOOKS_CONFIG"), "YAML or TOML config file")
+	flags.Func("mode", "gin mode: release, debug or test", set(func(v string) { config.Mode = v }))
+	flags.Func("listen", "address to listen on, e.g. :8080", set(func(v string) { config.Listen = v }))
+	flags.Func("store", "store backend: memory, file or sqlite", set(func(v string) { config.Store.Backend = v }))
+	flags.Func("store-path", "directory or database the store keeps its data in", set(func(v string) { config.Store.Path = v }))
+	flags.Func("seed", "JSON or CSV file of books to start an empty store with", set(func(v string) { config.SeedPath = v }))
+	flags.Func("log-level", "debug, info, warn or error", set(func(v string) { config.Log.Level = v }))
+	flags.Func("middlewares", "comma separated middlewares to use", set(func(v string) { config.Middlewares = splitList(v) }))
+	flags.Func("policy", "role policy file for the authorize middleware", set(func(v string) { config.PolicyFile = v }))
+	flags.Func("shutdown-timeout", "how long requests get to finish on shutdown", setDuration(&config.Timeouts.Shutdown))
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			flags.SetOutput(os.Stderr)
+			flags.PrintDefaults()
+		}
+		return Config{}, fmt.Errorf("parsing flags: %w", err)
+	}
+	if flags.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+
+	if *configPath != "" {
+		if err := config.readFile(*configPath); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := config.readEnv(getenv); err != nil {
+		return Config{}, err
+	}
+	for _, apply := range flagged {
+		apply()
+	}
+
+	if err := config.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
+	return config, nil
+}
+
+// readFile decodes the file over the defaults, rejecting unknown keys so a
+// misspelt setting does not go unnoticed.
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalWithOptions(data, c, yaml.Strict())
+	case ".toml":
+		err = toml.NewDecoder(bytes.NewReader(data)).DisallowUnknownFields().Decode(c)
+	default:
+		return fmt.Errorf("config %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) readEnv(getenv func(string) string) error {
+	var errs []error
+	str := func(name string, field *string) {
+		if value := getenv(name); value != "" {
+			*field = value
+		}
+	}
+	parse := func(name string, apply func(string) error) {
+		if value := getenv(name); value != "" {
+			if err := apply(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+	duration := func(name string, field *Duration) {
+		parse(name, func(value string) error { return field.UnmarshalText([]byte(value)) })
+	}
+	integer := func(name string, field *int) {
+		parse(name, func(value string) (err error) {
+			*field, err = strconv.Atoi(value)
+			return err
+		})
+	}
+
+	str("BOOKS_MODE", &c.Mode)
+	str("BOOKS_LISTEN", &c.Listen)
+	parse("BOOKS_TRUSTED_PROXIES", func(value string) error {
+		c.TrustedProxies = splitList(value)
+		return nil
+	})
+	duration("BOOKS_READ_HEADER_TIMEOUT", &c.Timeouts.ReadHeader)
+	duration("BOOKS_READ_TIMEOUT", &c.Timeouts.Read)
+	duration("BOOKS_WRITE_TIMEOUT", &c.Timeouts.Write)
+	duration("BOOKS_IDLE_TIMEOUT", &c.Timeouts.Idle)
+	duration("BOOKS_SHUTDOWN_TIMEOUT", &c.Timeouts.Shutdown)
+	str("BOOKS_STORE", &c.Store.Backend)
+	str("BOOKS_STORE_PATH", &c.Store.Path)
+	duration("BOOKS_TRASH_RETENTION", &c.Store.TrashRetention)
+	str("BOOKS_SEED_PATH", &c.SeedPath)
+	str("BOOKS_LOG_LEVEL", &c.Log.Level)
+	integer("BOOKS_LOG_SAMPLE_SUCCESS", &c.Log.SampleSuccess)
+	parse("BOOKS_MIDDLEWARES", func(value string) error {
+		c.Middlewares = splitList(value)
+		return nil
+	})
+	str("BOOKS_API_KEYS_FILE", &c.Auth.APIKeysFile)
+	str("BOOKS_JWT_HMAC_KEY_FILE", &c.Auth.HMACKeyFile)
+	str("BOOKS_JWT_RSA_PUBLIC_KEY_FILE", &c.Auth.RSAPublicKeyFile)
+	str("BOOKS_JWT_ISSUER", &c.Auth.Issuer)
+	str("BOOKS_JWT_AUDIENCE", &c.Auth.Audience)
+	str("BOOKS_POLICY_FILE", &c.PolicyFile)
+	duration("BOOKS_IDEMPOTENCY_TTL", &c.Idempotency.TTL)
+	integer("BOOKS_IDEMPOTENCY_MAX_ENTRIES", &c.Idempotency.MaxEntries)
+	integer("BOOKS_IDEMPOTENCY_MAX_BYTES", &c.Idempotency.MaxBytes)
+
+	return errors.Join(errs...)
+}
+
+func splitList(value string) []string {
+	var items []string
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Validate reports every problem with the configuration at once.
+func (c Config) Validate() error {
+	var problems []error
+	problem := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if !slices.Contains([]string{"release", "debug", "test"}, c.Mode) {
+		problem("mode %q must be release, debug or test", c.Mode)
+	}
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		problem("listen %q must be host:port: %v", c.Listen, err)
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			problem("trusted proxy %q must be an IP address or CIDR", proxy)
+		}
+	}
+
+	for name, timeout := range map[string]Duration{
+		"read_header": c.Timeouts.ReadHeader, "read": c.Timeouts.Read, "write": c.Timeouts.Write, "idle": c.Timeouts.Idle,
+	} {
+		if timeout.Duration < 0 {
+			problem("%s timeout must not be negative", name)
+		}
+	}
+	if c.Timeouts.Shutdown.Duration <= 0 {
+		problem("shutdown timeout must be positive")
+	}
+
+	if !slices.Contains([]string{"memory", "file", "sqlite"}, c.Store.Backend) {
+		problem("store backend %q must be memory, file or sqlite", c.Store.Backend)
+	}
+	if c.Store.TrashRetention.Duration <= 0 {
+		problem("trash retention must be positive")
+	}
+	if c.SeedPath != "" {
+		if _, err := os.Stat(c.SeedPath); err != nil {
+			problem("seed file: %v", err)
+		}
+	}
+
+	if _, err := c.LogLevel(); err != nil {
+		problem("%v", err)
+	}
+	if c.Log.SampleSuccess < 1 {
+		problem("log sample_success must be at least 1")
+	}
+
+	for i, name := range c.Middlewares {
+		if !slices.Contains(middlewareOrder, name) {
+			problem("unknown middleware %q, use %s", name, strings.Join(middlewareOrder, ", "))
+		} else if slices.Contains(c.Middlewares[:i], name) {
+			problem("middleware %q is listed twice", name)
+		}
+	}
+	hasKeys := c.Auth.APIKeysFile != "" || c.Auth.HMACKeyFile != "" || c.Auth.RSAPublicKeyFile != ""
+	switch {
+	case c.Enabled(MiddlewareAuth) && !hasKeys:
+		problem("the auth middleware needs an API keys file or a JWT key")
+	case !c.Enabled(MiddlewareAuth) && hasKeys:
+		// keys without the middleware would leave the API open to a reader
+		// of the config who expects it to be protected
+		problem("auth keys are set but the auth middleware is not enabled")
+	}
+	if c.Enabled(MiddlewareAuthorize) && c.PolicyFile == "" {
+		problem("the authorize middleware needs a policy file")
+	}
+
+	quotas := map[string]Quota{"ip": c.RateLimit.IP, "key": c.RateLimit.Key}
+	for subject, quota := range c.RateLimit.Clients {
+		quotas["client "+subject] = quota
+	}
+	for name, quota := range quotas {
+		if quota.Burst < 1 || quota.Refill <= 0 {
+			problem("rate limit %s needs a burst of at least 1 and a positive refill", name)
+		}
+	}
+	if c.RateLimit.IdleTimeout.Duration <= 0 {
+		problem("rate limit idle_timeout must be positive")
+	}
+	if c.Idempotency.TTL.Duration <= 0 {
+		problem("idempotency ttl must be positive")
+	}
+	if c.Idempotency.MaxEntries < 1 || c.Idempotency.MaxBytes < 1 {
+		problem("idempotency max_entries and max_bytes must be positive")
+	}
+
+	return errors.Join(problems...)
+}
+
+// Enabled reports whether a middleware is switched on.
+func (c Config) Enabled(middleware string) bool {
+	return slices.Contains(c.Middlewares, middleware)
+}
+
+func (c Config) LogLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		return 0, fmt.Errorf("log level %q must be debug, info, warn or error", c.Log.Level)
+	}
+	return level, nil
+}
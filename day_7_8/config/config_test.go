@@ -0,0 +1,193 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("writing %s failed: %v", name, err)
+	}
+	return path
+}
+
+func env(values map[string]string) func(string) string {
+	return func(name string) string { return values[name] }
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load(nil, env(nil))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("expected the defaults, got %+v", cfg)
+	}
+}
+
+func TestLoadFiles(t *testing.T) {
+	yamlFile := writeConfig(t, "books.yaml", `
+listen: 0.0.0.0:9000
+timeouts:
+  shutdown: 3s
+store:
+  backend: sqlite
+  path: books.db
+log:
+  level: debug
+middlewares: [logger, recovery]
+rate_limit:
+  clients:
+    importer: {burst: 500, refill: 100}
+`)
+	tomlFile := writeConfig(t, "books.toml", `
+listen = "0.0.0.0:9000"
+middlewares = ["logger", "recovery"]
+
+[timeouts]
+shutdown = "3s"
+
+[store]
+backend = "sqlite"
+path = "books.db"
+
+[log]
+level = "debug"
+
+[rate_limit.clients.importer]
+burst = 500
+refill = 100
+`)
+
+	for _, path := range []string{yamlFile, tomlFile} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			cfg, err := Load([]string{"-config", path}, env(nil))
+			if err != nil {
+				t.Fatalf("Load() failed: %v", err)
+			}
+
+			expected := Default()
+			expected.Listen = "0.0.0.0:9000"
+			expected.Timeouts.Shutdown = Duration{3 * time.Second}
+			expected.Store.Backend = "sqlite"
+			expected.Store.Path = "books.db"
+			expected.Log.Level = "debug"
+			expected.Middlewares = []string{MiddlewareLogger, MiddlewareRecovery}
+			expected.RateLimit.Clients = map[string]Quota{"importer": {Burst: 500, Refill: 100}}
+			if !reflect.DeepEqual(cfg, expected) {
+				t.Errorf("expected %+v, got %+v", expected, cfg)
+			}
+		})
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeConfig(t, "books.yaml", "listen: file:1\nstore:\n  backend: file\nlog:\n  level: warn\n")
+
+	testCases := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		listen  string
+		backend string
+		level   string
+	}{
+		{name: "File Over Defaults", args: []string{"-config", file}, listen: "file:1", backend: "file", level: "warn"},
+		{name: "Env Names The File", env: map[string]string{"BOOKS_CONFIG": file}, listen: "file:1", backend: "file", level: "warn"},
+		{
+			name: "Env Over File", args: []string{"-config", file},
+			env:    map[string]string{"BOOKS_LISTEN": "env:2", "BOOKS_STORE": "sqlite"},
+			listen: "env:2", backend: "sqlite", level: "warn",
+		},
+		{
+			name: "Flags Over Env", args: []string{"-config", file, "-listen", "flag:3", "-log-level", "error"},
+			env:    map[string]string{"BOOKS_LISTEN": "env:2", "BOOKS_LOG_LEVEL": "debug"},
+			listen: "flag:3", backend: "file", level: "error",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := Load(tc.args, env(tc.env))
+			if err != nil {
+				t.Fatalf("Load() failed: %v", err)
+			}
+			if cfg.Listen != tc.listen || cfg.Store.Backend != tc.backend || cfg.Log.Level != tc.level {
+				t.Errorf("expected %s %s %s, got %s %s %s", tc.listen, tc.backend, tc.level, cfg.Listen, cfg.Store.Backend, cfg.Log.Level)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	keys := writeConfig(t, "keys", "k alice\n")
+
+	testCases := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		expected []string
+	}{
+		{name: "Unknown Flag", args: []string{"-port", "80"}, expected: []string{"parsing flags"}},
+		{name: "Bad Flag Duration", args: []string{"-shutdown-timeout", "soon"}, expected: []string{"parsing flags"}},
+		{name: "Stray Argument", args: []string{"serve"}, expected: []string{"unexpected arguments: serve"}},
+		{name: "Missing File", args: []string{"-config", "/no/such/books.yaml"}, expected: []string{"reading config"}},
+		{name: "Unknown Extension", args: []string{"-config", writeConfig(t, "books.ini", "")}, expected: []string{"must be .yaml, .yml or .toml"}},
+		{name: "Unknown YAML Key", args: []string{"-config", writeConfig(t, "books.yaml", "listne: :80\n")}, expected: []string{"parsing config"}},
+		{name: "Unknown TOML Key", args: []string{"-config", writeConfig(t, "books.toml", "listne = \":80\"\n")}, expected: []string{"parsing config"}},
+		{name: "Bad Env Duration", env: map[string]string{"BOOKS_READ_TIMEOUT": "forever"}, expected: []string{"BOOKS_READ_TIMEOUT"}},
+		{name: "Bad Env Number", env: map[string]string{"BOOKS_LOG_SAMPLE_SUCCESS": "half"}, expected: []string{"BOOKS_LOG_SAMPLE_SUCCESS"}},
+		{
+			name: "Every Problem At Once",
+			env: map[string]string{
+				"BOOKS_MODE": "prod", "BOOKS_LISTEN": "8080", "BOOKS_STORE": "postgres", "BOOKS_LOG_LEVEL": "loud",
+				"BOOKS_SHUTDOWN_TIMEOUT": "0s", "BOOKS_SEED_PATH": "/no/such/seed.json", "BOOKS_MIDDLEWARES": "logger,cache,logger",
+			},
+			expected: []string{
+				`mode "prod"`, `listen "8080"`, `store backend "postgres"`, `log level "loud"`, "shutdown timeout",
+				"seed file", `unknown middleware "cache"`, `"logger" is listed twice`,
+			},
+		},
+		{name: "Auth Without Keys", env: map[string]string{"BOOKS_MIDDLEWARES": "auth"}, expected: []string{"auth middleware needs"}},
+		{name: "Keys Without Auth", env: map[string]string{"BOOKS_API_KEYS_FILE": keys}, expected: []string{"auth middleware is not enabled"}},
+		{name: "Authorize Without Policy", env: map[string]string{"BOOKS_MIDDLEWARES": "authorize"}, expected: []string{"needs a policy file"}},
+		{
+			name:     "Bad Client Quota",
+			args:     []string{"-config", writeConfig(t, "books.yaml", "rate_limit:\n  clients:\n    bot: {burst: 0, refill: 1}\n")},
+			expected: []string{"rate limit client bot"},
+		},
+		{name: "Bad Trusted Proxy", env: map[string]string{"BOOKS_TRUSTED_PROXIES": "10.0.0.1, proxy.local"}, expected: []string{`trusted proxy "proxy.local"`}},
+		{name: "Zero Idempotency TTL", env: map[string]string{"BOOKS_IDEMPOTENCY_TTL": "0s"}, expected: []string{"idempotency ttl must be positive"}},
+		{name: "Zero Idempotency Entries", env: map[string]string{"BOOKS_IDEMPOTENCY_MAX_ENTRIES": "0"}, expected: []string{"idempotency max_entries"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Load(tc.args, env(tc.env))
+			if err == nil {
+				t.Fatalf("expected Load() to fail")
+			}
+			for _, expected := range tc.expected {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("expected the error to mention %q, got %v", expected, err)
+				}
+			}
+		})
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	cfg, err := Load([]string{"-config", filepath.Join("..", "books.example.yaml")}, env(nil))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	cfg.RateLimit.Clients = nil
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("expected the example to match the defaults, got %+v", cfg)
+	}
+}
@@ -1,26 +1,30 @@
 package controllers
 
 import (
+	"errors"
 	"golang-training/day_7_8/models"
+	"golang-training/day_7_8/problem"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
-func AddBookController(c *gin.Context, store *models.LibraryStore) {
-	var newBook models.Books
-
-	if err := c.ShouldBindJSON(&newBook); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+func AddBookController(c *gin.Context, store models.BookRepository) {
+	newBook, err := bindBook(c)
+	if err != nil {
+		invalidBook(c, err)
 		return
 	}
 
-	if newBook.Title == "" || newBook.Author == "" || newBook.Genre == "" || newBook.Rating == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Title, Author, Genre, and Rating are required fields"})
+	newBook, err = store.AddBook(c.Request.Context(), newBook)
+	if errors.Is(err, models.ErrDuplicateID) {
+		problem.Abort(c, problem.DuplicateID, err.Error())
+		return
+	}
+	if err != nil {
+		internalError(c, err)
 		return
 	}
-
-	store.AddBook(newBook)
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Book added successfully",
@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"golang-training/day_7_8/models"
+	"golang-training/day_7_8/problem"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// how many events a client may fall behind by before it is cut off; it
+	// then reconnects and catches up from the replay buffer
+	eventStreamBacklog = 64
+
+	// comments sent on an idle stream so proxies do not time it out
+	eventStreamKeepAlive = 15 * time.Second
+)
+
+// BookEventsController streams catalogue changes as Server-Sent Events. A
+// client reconnecting with Last-Event-ID first gets the events it missed,
+// preceded by a reset event if some of them are no longer buffered.
+func BookEventsController(c *gin.Context, feed *models.ChangeFeed) {
+	lastID, current := feed.LastID(), true
+	if header := c.GetHeader("Last-Event-ID"); header != "" {
+		id, sameRun, err := feed.ParseEventID(header)
+		if err != nil {
+			problem.Abort(c, problem.BadRequest, "Last-Event-ID must be an event ID")
+			return
+		}
+		// after a restart the client has to reload, and then follows from now
+		if current = sameRun; current {
+			lastID = id
+		}
+	}
+
+	replay, events, cancel, complete := feed.Subscribe(lastID, eventStreamBacklog)
+	defer cancel()
+	complete = complete && current
+
+	// a stream outlives the server's write timeout
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if !complete {
+		c.Render(-1, sse.Event{Event: "reset", Data: gin.H{"reason": "events were missed, reload the catalogue"}})
+	}
+	for _, event := range replay {
+		c.Render(-1, changeEvent(event))
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, open := <-events:
+			if !open {
+				return false
+			}
+			c.Render(-1, changeEvent(event))
+			return true
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+func changeEvent(event models.ChangeEvent) sse.Event {
+	return sse.Event{
+		Id:    event.EventID(),
+		Event: event.Type,
+		Data:  event,
+	}
+}
@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang-training/day_7_8/models"
+	"golang-training/day_7_8/problem"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// a client has this long to answer a ping before it counts as gone
+	socketPongWait  = 60 * time.Second
+	socketPingEvery = socketPongWait * 9 / 10
+	// a write that cannot finish in time means the client stopped reading
+	socketWriteWait = 10 * time.Second
+	// subscribe and unsubscribe messages are tiny
+	socketMaxMessage = 4096
+)
+
+const (
+	topicAll          = "all"
+	topicGenre        = "genre:"
+	topicBook         = "book:"
+	actionSubscribe   = "subscribe"
+	actionUnsubscribe = "unsubscribe"
+)
+
+var socketUpgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 4096}
+
+type socketRequest struct {
+	Action string `json:"action"`
+	Topic  string `json:"topic"`
+}
+
+type socketMessage struct {
+	Type   string              `json:"type"`
+	Topic  string              `json:"topic,omitempty"`
+	Topics []string            `json:"topics,omitempty"`
+	Error  string              `json:"error,omitempty"`
+	Event  *models.ChangeEvent `json:"event,omitempty"`
+}
+
+// socketTopics is written by the reader and read by the writer
+type socketTopics struct {
+	mu     sync.Mutex
+	topics map[string]bool
+}
+
+// matching lists the subscribed topics an event falls under. A book that
+// changed genre falls under the old genre as well, so its subscribers hear
+// that it left.
+func (s *socketTopics) matching(event models.ChangeEvent) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	candidates := []string{topicAll, topicGenre + event.Book.Genre, topicBook + strconv.Itoa(event.Book.ID)}
+	if event.PreviousGenre != "" {
+		candidates = append(candidates, topicGenre+event.PreviousGenre)
+	}
+	var matched []string
+	for _, topic := range candidates {
+		if s.topics[topic] {
+			matched = append(matched, topic)
+		}
+	}
+	return matched
+}
+
+func (s *socketTopics) set(topic string, subscribed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if subscribed {
+		s.topics[topic] = true
+	} else {
+		delete(s.topics, topic)
+	}
+}
+
+func validTopic(topic string) error {
+	switch {
+	case topic == topicAll:
+		return nil
+	case strings.HasPrefix(topic, topicGenre) && len(topic) > len(topicGenre):
+		return nil
+	case strings.HasPrefix(topic, topicBook):
+		if id, err := strconv.Atoi(strings.TrimPrefix(topic, topicBook)); err == nil && id > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown topic %q, use %q, %q<name> or %q<id>", topic, topicAll, topicGenre, topicBook)
+}
+
+// BookSocketController upgrades to a WebSocket on which the client
+// subscribes to topics, a genre or a single book, and is pushed every
+// matching change. A client that cannot keep up is disconnected.
+func BookSocketController(c *gin.Context, feed *models.ChangeFeed) {
+	upgrader := socketUpgrader
+	upgrader.Error = func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		kind := problem.BadRequest
+		switch status {
+		case http.StatusForbidden:
+			kind = problem.Forbidden
+		case http.StatusMethodNotAllowed:
+			kind = problem.MethodNotAllowed
+		}
+		problem.Abort(c, kind, strings.TrimPrefix(reason.Error(), "websocket: "))
+	}
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader has already answered with a problem
+		return
+	}
+	defer conn.Close()
+
+	_, events, cancel, _ := feed.Subscribe(feed.LastID(), eventStreamBacklog)
+	defer cancel()
+
+	var (
+		topics  = &socketTopics{topics: map[string]bool{}}
+		replies = make(chan socketMessage, 8)
+		done    = make(chan struct{})
+	)
+	go readSocket(conn, topics, replies, done)
+
+	ping := time.NewTicker(socketPingEvery)
+	defer ping.Stop()
+
+	for {
+		var message socketMessage
+		select {
+		case <-done:
+			return
+		case message = <-replies:
+		case event, open := <-events:
+			if !open && feed.Closed() {
+				closeSocket(conn, websocket.CloseGoingAway, "server shutting down")
+				return
+			}
+			if !open {
+				closeSocket(conn, websocket.CloseTryAgainLater, "too slow, reconnect")
+				return
+			}
+			matched := topics.matching(event)
+			if len(matched) == 0 {
+				continue
+			}
+			message = socketMessage{Type: "change", Topics: matched, Event: &event}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteWait)); err != nil {
+				return
+			}
+			continue
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+		if err := conn.WriteJSON(message); err != nil {
+			return
+		}
+	}
+}
+
+// readSocket handles subscription requests until the connection fails or
+// stops answering pings, then closes done.
+func readSocket(conn *websocket.Conn, topics *socketTopics, replies chan<- socketMessage, done chan<- struct{}) {
+	defer close(done)
+
+	conn.SetReadLimit(socketMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		reply := handleSocketRequest(data, topics)
+		select {
+		case replies <- reply:
+		default:
+			// a client flooding requests without reading replies is as slow as any other
+			closeSocket(conn, websocket.ClosePolicyViolation, "too many requests")
+			return
+		}
+	}
+}
+
+// handleSocketRequest applies one subscribe or unsubscribe request and
+// builds the acknowledgement.
+func handleSocketRequest(data []byte, topics *socketTopics) socketMessage {
+	var request socketRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return socketMessage{Type: "error", Error: "messages must be JSON objects"}
+	}
+	if request.Action != actionSubscribe && request.Action != actionUnsubscribe {
+		return socketMessage{Type: "error", Error: fmt.Sprintf("unknown action %q, use %q or %q", request.Action, actionSubscribe, actionUnsubscribe)}
+	}
+	if err := validTopic(request.Topic); err != nil {
+		return socketMessage{Type: "error", Topic: request.Topic, Error: err.Error()}
+	}
+
+	topics.set(request.Topic, request.Action == actionSubscribe)
+	return socketMessage{Type: request.Action + "d", Topic: request.Topic}
+}
+
+func closeSocket(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(socketWriteWait))
+}
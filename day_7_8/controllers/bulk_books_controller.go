@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-training/day_7_8/models"
+	"golang-training/day_7_8/problem"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ndjsonContentType = "application/x-ndjson"
+
+	// keeps a single import from holding an unbounded batch in memory
+	maxBulkItems = 10000
+	maxBulkLine  = 1024 * 1024
+	// maxBulkBody fits maxBulkItems books of a few kilobytes each
+	maxBulkBody = 32 * 1024 * 1024
+)
+
+const (
+	bulkStatusCreated = "created"
+	bulkStatusFailed  = "failed"
+	// in atomic mode, valid items that were not stored because another item failed
+	bulkStatusSkipped = "skipped"
+)
+
+var errTooManyBulkItems = fmt.Errorf("a bulk import is limited to %d books", maxBulkItems)
+
+type bulkItem struct {
+	book models.Books
+	err  error
+}
+
+type bulkItemResult struct {
+	Index  int           `json:"index"`
+	Status string        `json:"status"`
+	Book   *models.Books `json:"book,omitempty"`
+	Error  string        `json:"error,omitempty"`
+	// Details lists every broken rule when the item failed validation
+	Details []models.FieldError `json:"details,omitempty"`
+}
+
+// BulkAddBooksController imports a JSON array or an NDJSON stream of books and
+// reports the outcome of every item. With ?atomic=true either every book is
+// created or none is.
+func BulkAddBooksController(c *gin.Context, store models.BookRepository) {
+	atomic, err := strconv.ParseBool(c.DefaultQuery("atomic", "false"))
+	if err != nil {
+		problem.Abort(c, problem.BadRequest, "atomic must be true or false")
+		return
+	}
+
+	limitBody(c, maxBulkBody)
+	var items []bulkItem
+	switch c.ContentType() {
+	case "application/json":
+		items, err = decodeBulkArray(c.Request.Body)
+	case ndjsonContentType, "application/ndjson":
+		items, err = decodeBulkNDJSON(c.Request.Body)
+	default:
+		problem.Abort(c, problem.UnsupportedMediaType, "Content-Type must be application/json or "+ndjsonContentType)
+		return
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		badBody(c, err)
+		return
+	}
+	if errors.Is(err, errTooManyBulkItems) {
+		problem.Abort(c, problem.TooLarge, err.Error())
+		return
+	}
+	if err != nil {
+		problem.Abort(c, problem.BadRequest, err.Error())
+		return
+	}
+
+	results := make([]bulkItemResult, len(items))
+	failed := 0
+	for i, item := range items {
+		results[i].Index = i
+		if item.err != nil {
+			results[i].Status = bulkStatusFailed
+			results[i].Error = itemError(item.err)
+			results[i].Details = fieldErrors(item.err)
+			failed++
+		}
+	}
+
+	if atomic {
+		bulkAddAtomic(c, store, items, results, failed)
+		return
+	}
+
+	for i, item := range items {
+		if results[i].Status == bulkStatusFailed {
+			continue
+		}
+
+		added, err := store.AddBook(c.Request.Context(), item.book)
+		if err != nil {
+			results[i].Status = bulkStatusFailed
+			results[i].Error = itemError(err)
+			if !errors.Is(err, models.ErrDuplicateID) {
+				c.Error(err)
+			}
+			failed++
+			continue
+		}
+		results[i].Status = bulkStatusCreated
+		results[i].Book = &added
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Bulk import finished",
+		"created": len(items) - failed,
+		"failed":  failed,
+		"results": results,
+	})
+}
+
+func bulkAddAtomic(c *gin.Context, store models.BookRepository, items []bulkItem, results []bulkItemResult, failed int) {
+	if failed > 0 {
+		for i := range results {
+			if results[i].Status != bulkStatusFailed {
+				results[i].Status = bulkStatusSkipped
+			}
+		}
+		problem.AbortWith(c, problem.BulkRejected, "No books were created because some of them are invalid", map[string]any{
+			"created": 0,
+			"failed":  failed,
+			"results": results,
+		})
+		return
+	}
+
+	books := make([]models.Books, len(items))
+	for i, item := range items {
+		books[i] = item.book
+	}
+
+	added, err := store.AddBooks(c.Request.Context(), books)
+	if errors.Is(err, models.ErrDuplicateID) {
+		problem.Abort(c, problem.DuplicateID, "Bulk import rolled back, "+err.Error())
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	for i := range added {
+		results[i].Status = bulkStatusCreated
+		results[i].Book = &added[i]
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Bulk import finished",
+		"created": len(added),
+		"failed":  0,
+		"results": results,
+	})
+}
+
+// decodeBulkArray streams a JSON array item by item. An item of the wrong
+// shape only fails that item, but broken JSON syntax ends the whole import
+// since there is no way to find where the next item starts.
+func decodeBulkArray(body io.Reader) ([]bulkItem, error) {
+	decoder := json.NewDecoder(body)
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return nil, errors.New("request body must be a JSON array of books")
+	}
+
+	var items []bulkItem
+	for decoder.More() {
+		if len(items) == maxBulkItems {
+			return nil, errTooManyBulkItems
+		}
+
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			// the controller answers 413 for a body over the limit
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("item %d: %s", len(items), decodeError(err))
+		}
+		items = append(items, decodeBulkItem(raw))
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return nil, errors.New("request body must be a JSON array of books")
+	}
+
+	return items, nil
+}
+
+// decodeBulkNDJSON reads one book per line, blank lines are skipped. Every
+// line stands alone, so a malformed one only fails itself.
+func decodeBulkNDJSON(body io.Reader) ([]bulkItem, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBulkLine)
+
+	var items []bulkItem
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if len(items) == maxBulkItems {
+			return nil, errTooManyBulkItems
+		}
+		items = append(items, decodeBulkItem(line))
+	}
+
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		return nil, fmt.Errorf("line %d is longer than %d bytes", len(items)+1, maxBulkLine)
+	}
+	return items, scanner.Err()
+}
+
+// itemError is what a failed item reports: the broken rules of an invalid
+// book, or what went wrong without the internals of a decoder or store
+func itemError(err error) string {
+	switch {
+	case fieldErrors(err) != nil, errors.Is(err, models.ErrDuplicateID):
+		return err.Error()
+	case errors.As(err, new(*json.SyntaxError)), errors.As(err, new(*json.UnmarshalTypeError)):
+		return decodeError(err)
+	default:
+		return "Book could not be stored"
+	}
+}
+
+func decodeBulkItem(raw []byte) bulkItem {
+	book, provided, err := models.DecodeBook(raw, false)
+	if err == nil {
+		err = models.ValidateBook(book, provided)
+	}
+	return bulkItem{book: book, err: err}
+}
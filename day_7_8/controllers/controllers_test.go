@@ -1,33 +1,70 @@
 package controllers_test
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"golang-training/day_7_8/controllers"
+	"golang-training/day_7_8/middlewares"
 	"golang-training/day_7_8/models"
+	"golang-training/day_7_8/problem"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
 var (
-	router *gin.Engine
-	store  *models.LibraryStore
+	router  *gin.Engine
+	store   *models.LibraryStore
+	feed    *models.ChangeFeed
+	metrics *middlewares.Metrics
 )
 
 func TestMain(m *testing.M) {
 	gin.SetMode(gin.TestMode)
 	store = models.NewBookStore()
+	demo, err := models.LoadSeedFile(filepath.Join("..", "fixtures", "demo_books.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := models.SeedStore(context.Background(), store, demo); err != nil {
+		log.Fatal(err)
+	}
+	feed = models.NewChangeFeed(100)
+	store.OnChange(feed.Publish)
+
+	metrics = middlewares.NewMetrics()
 
 	router = gin.New()
+	router.Use(metrics.Middleware(), middlewares.Actor())
 	router.POST("/add", func(c *gin.Context) { controllers.AddBookController(c, store) })
 	router.DELETE("/delete", func(c *gin.Context) { controllers.DeleteBookController(c, store) })
 	router.GET("/books", func(c *gin.Context) { controllers.GetBooksController(c, store) })
+	router.GET("/books/search", func(c *gin.Context) { controllers.SearchBooksController(c, store) })
+	router.GET("/books/:id", func(c *gin.Context) { controllers.GetBookController(c, store) })
 	router.PUT("/update", func(c *gin.Context) { controllers.UpdateBookController(c, store) })
+	router.PATCH("/books/:id", func(c *gin.Context) { controllers.PatchBookController(c, store) })
+	router.POST("/books/bulk", func(c *gin.Context) { controllers.BulkAddBooksController(c, store) })
+	router.GET("/books/export", func(c *gin.Context) { controllers.ExportBooksController(c, store) })
+	router.GET("/books/trash", func(c *gin.Context) { controllers.GetTrashController(c, store) })
+	router.DELETE("/books/trash", func(c *gin.Context) { controllers.PurgeTrashController(c, store) })
+	router.POST("/books/:id/restore", func(c *gin.Context) { controllers.RestoreBookController(c, store) })
+	router.GET("/books/events", func(c *gin.Context) { controllers.BookEventsController(c, feed) })
+	router.GET("/books/ws", func(c *gin.Context) { controllers.BookSocketController(c, feed) })
+	router.GET("/books/:id/history", func(c *gin.Context) { controllers.GetBookHistoryController(c, store) })
+	router.POST("/books/:id/revert", func(c *gin.Context) { controllers.RevertBookController(c, store) })
+	router.GET("/metrics", func(c *gin.Context) { controllers.MetricsController(c, metrics, store) })
 
 	os.Exit(m.Run())
 }
@@ -53,6 +90,21 @@ func TestAddBookController(t *testing.T) {
 			body:       `{"title":123,"author":"John","genre":"Tech","rating":4.5}`,
 			statusCode: http.StatusBadRequest,
 		},
+		{
+			name:       "Zero Rating",
+			body:       `{"title":"Unloved","author":"John","genre":"Tech","rating":0}`,
+			statusCode: http.StatusCreated,
+		},
+		{
+			name:       "Missing Rating",
+			body:       `{"title":"Unrated","author":"John","genre":"Tech"}`,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Duplicate ID",
+			body:       `{"id":1,"title":"Impostor","author":"John","genre":"Tech","rating":1}`,
+			statusCode: http.StatusConflict,
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -69,6 +121,108 @@ func TestAddBookController(t *testing.T) {
 	}
 }
 
+// oversized is a JSON book whose title takes it past limit bytes
+func oversized(limit int) string {
+	return `{"title":"` + strings.Repeat("a", limit) + `","author":"A","genre":"Drama","rating":3}`
+}
+
+func TestProblemResponses(t *testing.T) {
+	testCases := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		kind   problem.Kind
+		detail string
+	}{
+		{name: "Broken JSON", method: "POST", target: "/add", body: `{"title":`, kind: problem.BadRequest, detail: "Request body is not valid JSON, the error is at byte 9"},
+		{name: "Not JSON", method: "POST", target: "/add", body: `title=Emma`, kind: problem.BadRequest, detail: "Request body is not valid JSON, the error is at byte 2"},
+		{name: "Wrong Type In Body", method: "DELETE", target: "/delete", body: `{"id":"one"}`, kind: problem.BadRequest, detail: "id must be an integer"},
+		{name: "Empty Body", method: "DELETE", target: "/delete", kind: problem.BadRequest, detail: "Request body is empty"},
+		{name: "Bad Book ID", method: "GET", target: "/books/abc", kind: problem.BadRequest, detail: "Book ID must be an integer"},
+		{name: "Missing Book", method: "GET", target: "/books/999", kind: problem.NotFound, detail: "Book not found"},
+		{name: "Bad Query", method: "GET", target: "/books?sort=isbn", kind: problem.BadRequest},
+		{name: "Unsupported Patch", method: "PATCH", target: "/books/1", body: `{}`, kind: problem.UnsupportedMediaType},
+		{name: "Book Too Large", method: "POST", target: "/add", body: oversized(64 * 1024), kind: problem.TooLarge, detail: "Request body is larger than 65536 bytes"},
+		{name: "Delete Too Large", method: "DELETE", target: "/delete", body: oversized(64 * 1024), kind: problem.TooLarge},
+		{name: "Patch Too Large", method: "PATCH", target: "/books/1", body: oversized(64 * 1024), kind: problem.TooLarge},
+		{name: "Revert Too Large", method: "POST", target: "/books/1/revert", body: oversized(64 * 1024), kind: problem.TooLarge},
+		{name: "Bulk Too Large", method: "POST", target: "/books/bulk", body: "[" + oversized(32*1024*1024) + "]", kind: problem.TooLarge},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.method == "PATCH" {
+				req.Header.Set("Content-Type", "text/plain")
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.kind.Status || w.Header().Get("Content-Type") != problem.ContentType {
+				t.Fatalf("expected %d as %s, got %d as %s", tc.kind.Status, problem.ContentType, w.Code, w.Header().Get("Content-Type"))
+			}
+			var res struct {
+				Type     string `json:"type"`
+				Title    string `json:"title"`
+				Status   int    `json:"status"`
+				Detail   string `json:"detail"`
+				Instance string `json:"instance"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("decoding response failed: %v", err)
+			}
+			if res.Type != tc.kind.Type || res.Title != tc.kind.Title || res.Status != tc.kind.Status {
+				t.Errorf("expected %+v, got %+v", tc.kind, res)
+			}
+			if res.Instance != strings.Split(tc.target, "?")[0] {
+				t.Errorf("expected the instance to be the request path, got %q", res.Instance)
+			}
+			if tc.detail != "" && res.Detail != tc.detail {
+				t.Errorf("expected detail %q, got %q", tc.detail, res.Detail)
+			}
+			if strings.Contains(res.Detail, "Go ") || strings.Contains(res.Detail, "json:") {
+				t.Errorf("expected no decoder internals in the detail, got %q", res.Detail)
+			}
+		})
+	}
+}
+
+func TestBookValidationErrors(t *testing.T) {
+	body := `{"title":"","author":"John","genre":"Cooking","rating":7}`
+	req := httptest.NewRequest("POST", "/add", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+
+	var res struct {
+		Type   string              `json:"type"`
+		Errors []models.FieldError `json:"errors"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("decoding response failed: %v", err)
+	}
+	if res.Type != problem.InvalidBook.Type {
+		t.Errorf("expected a %s problem, got %q", problem.InvalidBook.Type, res.Type)
+	}
+
+	want := map[string]string{"title": models.CodeRequired, "genre": models.CodeNotAllowed, "rating": models.CodeOutOfRange}
+	if len(res.Errors) != len(want) {
+		t.Fatalf("expected %d field errors, got %v", len(want), res.Errors)
+	}
+	for _, fieldErr := range res.Errors {
+		if want[fieldErr.Field] != fieldErr.Code {
+			t.Errorf("field %s: expected code %q, got %q", fieldErr.Field, want[fieldErr.Field], fieldErr.Code)
+		}
+	}
+}
+
 func TestGetBooksController(t *testing.T) {
 	req := httptest.NewRequest("GET", "/books", nil)
 	w := httptest.NewRecorder()
@@ -87,6 +241,181 @@ func TestGetBooksController(t *testing.T) {
 	}
 }
 
+func TestGetBooksControllerQuery(t *testing.T) {
+	testCases := []struct {
+		name       string
+		query      string
+		statusCode int
+		count      int
+	}{
+		{name: "Limit", query: "?limit=1&sort=title&order=desc", statusCode: http.StatusOK, count: 1},
+		{name: "Filter", query: "?genre=NoSuchGenre", statusCode: http.StatusOK, count: 0},
+		{name: "Invalid Limit", query: "?limit=ten", statusCode: http.StatusBadRequest},
+		{name: "Invalid Rating", query: "?min_rating=high", statusCode: http.StatusBadRequest},
+		{name: "Invalid Order", query: "?order=sideways", statusCode: http.StatusBadRequest},
+		{name: "Invalid Sort", query: "?sort=isbn", statusCode: http.StatusBadRequest},
+		{name: "Invalid Cursor", query: "?cursor=garbage", statusCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/books"+tc.query, nil)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.statusCode {
+				t.Fatalf("expected %d, got %d", tc.statusCode, w.Code)
+			}
+			if tc.statusCode != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Books      []models.Books `json:"books"`
+				Total      *int           `json:"total"`
+				NextCursor *string        `json:"next_cursor"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &res)
+
+			if len(res.Books) != tc.count {
+				t.Errorf("expected %d books, got %d", tc.count, len(res.Books))
+			}
+			if res.Total == nil || res.NextCursor == nil {
+				t.Errorf("expected total and next_cursor in response, got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestSearchBooksController(t *testing.T) {
+	testCases := []struct {
+		name       string
+		query      string
+		statusCode int
+		count      int
+	}{
+		{name: "Match", query: "?q=harp", statusCode: http.StatusOK, count: 1},
+		{name: "No Match", query: "?q=tolstoy", statusCode: http.StatusOK, count: 0},
+		{name: "Limit", query: "?q=fiction&limit=1", statusCode: http.StatusOK, count: 1},
+		{name: "Missing Query", query: "", statusCode: http.StatusBadRequest},
+		{name: "Invalid Limit", query: "?q=harp&limit=many", statusCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/books/search"+tc.query, nil)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.statusCode {
+				t.Fatalf("expected %d, got %d", tc.statusCode, w.Code)
+			}
+			if tc.statusCode != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Results []models.SearchResult `json:"results"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &res)
+
+			if len(res.Results) != tc.count {
+				t.Errorf("expected %d results, got %d", tc.count, len(res.Results))
+			}
+		})
+	}
+}
+
+func TestGetBookController(t *testing.T) {
+	testCases := []struct {
+		name       string
+		path       string
+		statusCode int
+	}{
+		{
+			name:       "Existing Book",
+			path:       "/books/3",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Non-Existing Book",
+			path:       "/books/99",
+			statusCode: http.StatusNotFound,
+		},
+		{
+			name:       "Invalid ID",
+			path:       "/books/three",
+			statusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.path, nil)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.statusCode {
+				t.Fatalf("expected %d, got %d", tc.statusCode, w.Code)
+			}
+		})
+	}
+}
+
+func TestGetBookControllerETag(t *testing.T) {
+	req := httptest.NewRequest("GET", "/books/3", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	etag := w.Header().Get("ETag")
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		t.Fatalf("expected a strong ETag, got %q", etag)
+	}
+
+	testCases := []struct {
+		name        string
+		ifNoneMatch string
+		statusCode  int
+	}{
+		{name: "Matching ETag", ifNoneMatch: etag, statusCode: http.StatusNotModified},
+		{name: "Weak Form Of ETag", ifNoneMatch: "W/" + etag, statusCode: http.StatusNotModified},
+		{name: "ETag In List", ifNoneMatch: `"stale", ` + etag, statusCode: http.StatusNotModified},
+		{name: "Wildcard", ifNoneMatch: "*", statusCode: http.StatusNotModified},
+		{name: "Stale ETag", ifNoneMatch: `"stale"`, statusCode: http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/books/3", nil)
+			req.Header.Set("If-None-Match", tc.ifNoneMatch)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.statusCode {
+				t.Fatalf("expected %d, got %d", tc.statusCode, w.Code)
+			}
+			if tc.statusCode == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("expected empty body on 304, got %q", w.Body.String())
+			}
+		})
+	}
+
+	// a write must change the ETag
+	body := `{"id":3,"title":"Nineteen Eighty-Four","author":"George Orwell","genre":"Dystopian","rating":4.4}`
+	req = httptest.NewRequest("PUT", "/update", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/books/3", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 after update, got %d", w.Code)
+	}
+}
+
 func TestDeleteBookController(t *testing.T) {
 	testCases := []struct {
 		name       string
@@ -160,3 +489,803 @@ func TestUpdateBookController(t *testing.T) {
 		})
 	}
 }
+
+func TestOptimisticConcurrency(t *testing.T) {
+	send := func(method, path, ifMatch, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/add", "", `{"title":"Dune","author":"Frank Herbert","genre":"Science Fiction","rating":4.6}`)
+	var created struct {
+		Book models.Books `json:"book"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	id := strconv.Itoa(created.Book.ID)
+
+	etag := send("GET", "/books/"+id, "", "").Header().Get("ETag")
+	body := `{"id":` + id + `,"title":"Dune","author":"Frank Herbert","genre":"Science Fiction","rating":4.7}`
+
+	testCases := []struct {
+		name       string
+		method     string
+		ifMatch    string
+		body       string
+		statusCode int
+	}{
+		{name: "Update With Current ETag", method: "PUT", ifMatch: etag, body: body, statusCode: http.StatusOK},
+		{name: "Update With Stale ETag", method: "PUT", ifMatch: etag, body: body, statusCode: http.StatusPreconditionFailed},
+		{name: "Update With Stale Body Version", method: "PUT", body: strings.Replace(body, `"rating"`, `"version":1,"rating"`, 1), statusCode: http.StatusConflict},
+		{name: "Update With Weak ETag", method: "PUT", ifMatch: "W/" + etag, body: body, statusCode: http.StatusPreconditionFailed},
+		{name: "Update With ETag Of Another Book", method: "PUT", ifMatch: `"1-2-abc"`, body: body, statusCode: http.StatusPreconditionFailed},
+		{name: "Update With Malformed If-Match", method: "PUT", ifMatch: "2", body: body, statusCode: http.StatusBadRequest},
+		{name: "Update With Current Version Number", method: "PUT", ifMatch: `"2"`, body: body, statusCode: http.StatusOK},
+		{name: "Update With Wildcard", method: "PUT", ifMatch: "*", body: body, statusCode: http.StatusOK},
+		{name: "Delete With Stale Version", method: "DELETE", ifMatch: `"3"`, body: `{"id":` + id + `}`, statusCode: http.StatusPreconditionFailed},
+		{name: "Delete With Stale Body Version", method: "DELETE", body: `{"id":` + id + `,"version":1}`, statusCode: http.StatusConflict},
+		{name: "Delete With Current Version", method: "DELETE", ifMatch: `"4"`, body: `{"id":` + id + `}`, statusCode: http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := "/update"
+			if tc.method == "DELETE" {
+				path = "/delete"
+			}
+
+			w := send(tc.method, path, tc.ifMatch, tc.body)
+
+			if w.Code != tc.statusCode {
+				t.Fatalf("expected %d, got %d: %s", tc.statusCode, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPatchBookController(t *testing.T) {
+	req := httptest.NewRequest("POST", "/add", strings.NewReader(`{"title":"Emma","author":"Jane Austen","genre":"Fiction","rating":3.9}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var created struct {
+		Book models.Books `json:"book"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	path := "/books/" + strconv.Itoa(created.Book.ID)
+
+	testCases := []struct {
+		name        string
+		path        string
+		contentType string
+		ifMatch     string
+		body        string
+		statusCode  int
+		rating      float64
+	}{
+		{
+			name:        "Merge Patch",
+			contentType: "application/merge-patch+json",
+			body:        `{"rating":4.1}`,
+			statusCode:  http.StatusOK,
+			rating:      4.1,
+		},
+		{
+			name:        "Plain JSON Is A Merge Patch",
+			contentType: "application/json",
+			body:        `{"rating":4.2,"created_at":"2000-01-01T00:00:00Z"}`,
+			statusCode:  http.StatusOK,
+			rating:      4.2,
+		},
+		{
+			name:        "JSON Patch",
+			contentType: "application/json-patch+json",
+			body:        `[{"op":"test","path":"/rating","value":4.2},{"op":"replace","path":"/rating","value":4.3}]`,
+			statusCode:  http.StatusOK,
+			rating:      4.3,
+		},
+		{
+			name:        "JSON Patch Failed Test",
+			contentType: "application/json-patch+json",
+			body:        `[{"op":"test","path":"/rating","value":1},{"op":"replace","path":"/rating","value":2}]`,
+			statusCode:  http.StatusConflict,
+		},
+		{
+			name:        "Removing A Required Field",
+			contentType: "application/merge-patch+json",
+			body:        `{"title":null}`,
+			statusCode:  http.StatusBadRequest,
+		},
+		{
+			name:        "Changing The ID",
+			contentType: "application/merge-patch+json",
+			body:        `{"id":1}`,
+			statusCode:  http.StatusBadRequest,
+		},
+		{
+			name:        "Unknown Field",
+			contentType: "application/merge-patch+json",
+			body:        `{"isbn":"123"}`,
+			statusCode:  http.StatusBadRequest,
+		},
+		{
+			name:        "Wrong Type",
+			contentType: "application/merge-patch+json",
+			body:        `{"rating":"high"}`,
+			statusCode:  http.StatusBadRequest,
+		},
+		{
+			name:        "Malformed JSON Patch",
+			contentType: "application/json-patch+json",
+			body:        `{"op":"replace"}`,
+			statusCode:  http.StatusBadRequest,
+		},
+		{
+			name:        "Unsupported Content Type",
+			contentType: "text/plain",
+			body:        `rating=5`,
+			statusCode:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "Stale If-Match",
+			contentType: "application/merge-patch+json",
+			ifMatch:     `"1"`,
+			body:        `{"rating":5}`,
+			statusCode:  http.StatusPreconditionFailed,
+		},
+		{
+			name:        "Non-Existing Book",
+			path:        "/books/999",
+			contentType: "application/merge-patch+json",
+			body:        `{"rating":5}`,
+			statusCode:  http.StatusNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			target := path
+			if tc.path != "" {
+				target = tc.path
+			}
+
+			req := httptest.NewRequest("PATCH", target, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.statusCode {
+				t.Fatalf("expected %d, got %d: %s", tc.statusCode, w.Code, w.Body.String())
+			}
+			if tc.statusCode != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Book models.Books `json:"book"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &res)
+
+			if res.Book.Rating != tc.rating {
+				t.Errorf("expected rating %v, got %v", tc.rating, res.Book.Rating)
+			}
+			if res.Book.Title != "Emma" || res.Book.Author != "Jane Austen" {
+				t.Errorf("expected untouched fields to survive, got %+v", res.Book)
+			}
+			if !res.Book.CreatedAt.Equal(created.Book.CreatedAt) {
+				t.Errorf("expected CreatedAt %v to be preserved, got %v", created.Book.CreatedAt, res.Book.CreatedAt)
+			}
+		})
+	}
+}
+
+func TestBulkAddBooksController(t *testing.T) {
+	valid := `{"title":"Dune","author":"Frank Herbert","genre":"Science Fiction","rating":4.6}`
+	missingTitle := `{"author":"Frank Herbert","genre":"Science Fiction","rating":4.6}`
+	wrongType := `{"title":7,"author":"Frank Herbert","genre":"Science Fiction","rating":4.6}`
+
+	testCases := []struct {
+		name        string
+		query       string
+		contentType string
+		body        string
+		statusCode  int
+		statuses    []string
+	}{
+		{
+			name:        "JSON Array",
+			contentType: "application/json",
+			body:        "[" + valid + "," + missingTitle + "," + wrongType + "]",
+			statusCode:  http.StatusOK,
+			statuses:    []string{"created", "failed", "failed"},
+		},
+		{
+			name:        "NDJSON With A Broken Line",
+			contentType: "application/x-ndjson",
+			body:        valid + "\n\n{\"title\":\n" + valid + "\n",
+			statusCode:  http.StatusOK,
+			statuses:    []string{"created", "failed", "created"},
+		},
+		{
+			name:        "Atomic Success",
+			query:       "?atomic=true",
+			contentType: "application/json",
+			body:        "[" + valid + "," + valid + "]",
+			statusCode:  http.StatusCreated,
+			statuses:    []string{"created", "created"},
+		},
+		{
+			name:        "Atomic Rollback",
+			query:       "?atomic=true",
+			contentType: "application/x-ndjson",
+			body:        valid + "\n" + missingTitle,
+			statusCode:  http.StatusUnprocessableEntity,
+			statuses:    []string{"skipped", "failed"},
+		},
+		{
+			name:        "Not An Array",
+			contentType: "application/json",
+			body:        valid,
+			statusCode:  http.StatusBadRequest,
+		},
+		{
+			name:        "Broken Array",
+			contentType: "application/json",
+			body:        "[" + valid + ",{",
+			statusCode:  http.StatusBadRequest,
+		},
+		{
+			name:        "Unsupported Content Type",
+			contentType: "text/csv",
+			body:        "title,author",
+			statusCode:  http.StatusUnsupportedMediaType,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			before := countAllBooks(t)
+
+			req := httptest.NewRequest("POST", "/books/bulk"+tc.query, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.statusCode {
+				t.Fatalf("expected %d, got %d: %s", tc.statusCode, w.Code, w.Body.String())
+			}
+
+			var res struct {
+				Results []struct {
+					Status string `json:"status"`
+				} `json:"results"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &res)
+
+			created := 0
+			if len(res.Results) != len(tc.statuses) {
+				t.Fatalf("expected %d results, got %s", len(tc.statuses), w.Body.String())
+			}
+			for i, result := range res.Results {
+				if result.Status != tc.statuses[i] {
+					t.Errorf("item %d: expected %s, got %s", i, tc.statuses[i], result.Status)
+				}
+				if result.Status == "created" {
+					created++
+				}
+			}
+
+			if after := countAllBooks(t); after != before+created {
+				t.Errorf("expected %d books to be stored, went from %d to %d", created, before, after)
+			}
+		})
+	}
+}
+
+func countAllBooks(t *testing.T) int {
+	books, err := store.GetAllBooks(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllBooks() failed: %v", err)
+	}
+	return len(books)
+}
+
+func TestExportBooksController(t *testing.T) {
+	total := countAllBooks(t)
+
+	testCases := []struct {
+		name        string
+		query       string
+		statusCode  int
+		contentType string
+		lines       int
+	}{
+		{name: "NDJSON By Default", statusCode: http.StatusOK, contentType: "application/x-ndjson", lines: total},
+		{name: "CSV", query: "?format=csv", statusCode: http.StatusOK, contentType: "text/csv; charset=utf-8", lines: total + 1},
+		{name: "Unknown Format", query: "?format=xml", statusCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/books/export"+tc.query, nil)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.statusCode {
+				t.Fatalf("expected %d, got %d", tc.statusCode, w.Code)
+			}
+			if tc.statusCode != http.StatusOK {
+				return
+			}
+
+			if got := w.Header().Get("Content-Type"); got != tc.contentType {
+				t.Errorf("expected Content-Type %q, got %q", tc.contentType, got)
+			}
+			lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+			if len(lines) != tc.lines {
+				t.Errorf("expected %d lines, got %d", tc.lines, len(lines))
+			}
+			if tc.query == "?format=csv" && lines[0] != "id,title,author,genre,rating,version,created_at,updated_at" {
+				t.Errorf("unexpected CSV header %q", lines[0])
+			}
+			if tc.query == "" {
+				var book models.Books
+				if err := json.Unmarshal([]byte(lines[0]), &book); err != nil || book.ID == 0 {
+					t.Errorf("expected a book per line, got %q (%v)", lines[0], err)
+				}
+			}
+		})
+	}
+}
+
+// pagedOnlyStore fails GetAllBooks, so an export that loads the whole
+// catalogue at once is caught
+type pagedOnlyStore struct {
+	models.BookRepository
+}
+
+func (pagedOnlyStore) GetAllBooks(ctx context.Context) ([]models.Books, error) {
+	return nil, errors.New("export must page through the store")
+}
+
+func TestExportBooksControllerPages(t *testing.T) {
+	large := models.NewBookStore()
+	batch := make([]models.Books, 1201)
+	for i := range batch {
+		batch[i] = models.Books{Title: "Book " + strconv.Itoa(i), Author: "Writer", Genre: "Drama", Rating: 3}
+	}
+	if _, err := large.AddBooks(context.Background(), batch); err != nil {
+		t.Fatalf("AddBooks() failed: %v", err)
+	}
+	exportRouter := gin.New()
+	exportRouter.GET("/books/export", func(c *gin.Context) { controllers.ExportBooksController(c, pagedOnlyStore{large}) })
+
+	for name, query := range map[string]string{"NDJSON": "", "CSV": "?format=csv"} {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			exportRouter.ServeHTTP(w, httptest.NewRequest("GET", "/books/export"+query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+			}
+
+			lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+			if query != "" {
+				lines = lines[1:]
+			}
+			if len(lines) != len(batch) {
+				t.Fatalf("expected %d books across the pages, got %d", len(batch), len(lines))
+			}
+			// every book once, in ID order, with no page repeated or skipped
+			for i, line := range lines {
+				id, _, _ := strings.Cut(line, ",")
+				if query == "" {
+					var book models.Books
+					json.Unmarshal([]byte(line), &book)
+					id = strconv.Itoa(book.ID)
+				}
+				if id != strconv.Itoa(i+1) {
+					t.Fatalf("expected book %d on line %d, got %q", i+1, i+1, line)
+				}
+			}
+		})
+	}
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	added, err := store.AddBook(context.Background(), models.Books{Title: "Misfiled", Author: "Clerk", Genre: "Drama", Rating: 2})
+	if err != nil {
+		t.Fatalf("AddBook() failed: %v", err)
+	}
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	restorePath := "/books/" + strconv.Itoa(added.ID) + "/restore"
+
+	if w := send("POST", restorePath, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 restoring a live book, got %d", w.Code)
+	}
+	if w := send("DELETE", "/delete", `{"id":`+strconv.Itoa(added.ID)+`}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 deleting, got %d", w.Code)
+	}
+	if w := send("GET", "/books/"+strconv.Itoa(added.ID), ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected a trashed book to be hidden, got %d", w.Code)
+	}
+
+	w := send("GET", "/books/trash", "")
+	var trash struct {
+		Books []models.Books `json:"books"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &trash)
+	if w.Code != http.StatusOK || len(trash.Books) == 0 || trash.Books[0].ID != added.ID {
+		t.Fatalf("expected the book at the top of the trash, got %d %s", w.Code, w.Body)
+	}
+
+	w = send("POST", restorePath, "")
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == "" {
+		t.Fatalf("expected 200 with an ETag restoring, got %d", w.Code)
+	}
+	if w := send("GET", "/books/"+strconv.Itoa(added.ID), ""); w.Code != http.StatusOK {
+		t.Errorf("expected the restored book to be back, got %d", w.Code)
+	}
+	if w := send("POST", "/books/abc/restore", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a non-integer ID, got %d", w.Code)
+	}
+
+	// purging keeps books deleted more recently than older_than
+	send("DELETE", "/delete", `{"id":`+strconv.Itoa(added.ID)+`}`)
+	if w := send("DELETE", "/books/trash?older_than=1h", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 purging, got %d", w.Code)
+	}
+	if w := send("POST", restorePath, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected a recent deletion to survive the purge, got %d", w.Code)
+	}
+	send("DELETE", "/delete", `{"id":`+strconv.Itoa(added.ID)+`}`)
+	if w := send("DELETE", "/books/trash", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"purged":`) {
+		t.Fatalf("expected 200 with a purge count, got %d %s", w.Code, w.Body)
+	}
+	if w := send("POST", restorePath, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected a purged book to be gone, got %d", w.Code)
+	}
+	if w := send("DELETE", "/books/trash?older_than=soon", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a malformed older_than, got %d", w.Code)
+	}
+}
+
+func TestHistoryAndRevert(t *testing.T) {
+	added, err := store.AddBook(context.Background(), models.Books{Title: "First Draft", Author: "Clerk", Genre: "Drama", Rating: 2})
+	if err != nil {
+		t.Fatalf("AddBook() failed: %v", err)
+	}
+	path := "/books/" + strconv.Itoa(added.ID)
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Actor", "editor@example.com")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send("PUT", "/update", `{"id":`+strconv.Itoa(added.ID)+`,"title":"Second Draft","author":"Clerk","genre":"Drama","rating":2}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 updating, got %d", w.Code)
+	}
+
+	w := send("GET", path+"/history", "")
+	var res struct {
+		History []models.HistoryEntry `json:"history"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if w.Code != http.StatusOK || len(res.History) != 2 {
+		t.Fatalf("expected 2 history entries, got %d %s", w.Code, w.Body)
+	}
+	if res.History[1].Actor != "editor@example.com" || res.History[1].Before.Title != "First Draft" {
+		t.Errorf("unexpected update entry %+v", res.History[1])
+	}
+
+	testCases := []struct {
+		name       string
+		path       string
+		body       string
+		statusCode int
+	}{
+		{name: "Revert", path: path + "/revert", body: `{"revision":1}`, statusCode: http.StatusOK},
+		{name: "Unknown Revision", path: path + "/revert", body: `{"revision":99}`, statusCode: http.StatusNotFound},
+		{name: "Missing Revision", path: path + "/revert", body: `{}`, statusCode: http.StatusBadRequest},
+		{name: "Unknown Book", path: "/books/9999/revert", body: `{"revision":1}`, statusCode: http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if w := send("POST", tc.path, tc.body); w.Code != tc.statusCode {
+				t.Fatalf("expected %d, got %d: %s", tc.statusCode, w.Code, w.Body)
+			}
+		})
+	}
+
+	if book, _ := store.GetBook(context.Background(), added.ID); book.Title != "First Draft" {
+		t.Errorf("expected the revert to restore the first title, got %q", book.Title)
+	}
+	if w := send("GET", "/books/9999/history", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for the history of an unknown book, got %d", w.Code)
+	}
+}
+
+// readEvent reads one SSE event from the stream, skipping keep-alive comments
+func readEvent(t *testing.T, reader *bufio.Reader) map[string]string {
+	t.Helper()
+
+	fields := map[string]string{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event stream failed: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+
+		if line == "" {
+			if len(fields) > 0 {
+				return fields
+			}
+			continue
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && name != "" {
+			fields[name] += value
+		}
+	}
+}
+
+func TestBookEventsController(t *testing.T) {
+	// cleanups run last in first out, so the streams are closed before the server
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	openStream := func(lastEventID string) (*http.Response, *bufio.Reader) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		t.Cleanup(cancel)
+
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/books/events", nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("opening event stream failed: %v", err)
+		}
+		t.Cleanup(func() { res.Body.Close() })
+		return res, bufio.NewReader(res.Body)
+	}
+
+	res, stream := openStream("")
+	if res.StatusCode != http.StatusOK || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("expected an event stream, got %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	added, err := store.AddBook(context.Background(), models.Books{Title: "Streamed", Author: "Clerk", Genre: "Drama", Rating: 3})
+	if err != nil {
+		t.Fatalf("AddBook() failed: %v", err)
+	}
+
+	event := readEvent(t, stream)
+	if event["event"] != models.EventCreated || !strings.Contains(event["data"], `"title":"Streamed"`) {
+		t.Fatalf("expected a created event for the new book, got %v", event)
+	}
+
+	// resuming just before that event replays it
+	epoch, number, _ := strings.Cut(event["id"], "-")
+	id, _ := strconv.ParseUint(number, 10, 64)
+	_, resumed := openStream(epoch + "-" + strconv.FormatUint(id-1, 10))
+	if replayed := readEvent(t, resumed); replayed["id"] != event["id"] {
+		t.Errorf("expected event %s to be replayed, got %v", event["id"], replayed)
+	}
+
+	// an ID from before a restart asks the client to reload, even once this
+	// run has handed out that number too
+	for _, stale := range []string{"999999", "earlier-1", "earlier-" + number} {
+		_, stream := openStream(stale)
+		if reset := readEvent(t, stream); reset["event"] != "reset" {
+			t.Errorf("%s: expected a reset event, got %v", stale, reset)
+		}
+	}
+
+	if res, _ := openStream("abc"); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for a malformed Last-Event-ID, got %d", res.StatusCode)
+	}
+
+	store.DeleteBook(context.Background(), added.ID, 0)
+	if event := readEvent(t, stream); event["event"] != models.EventDeleted {
+		t.Errorf("expected a deleted event, got %v", event)
+	}
+}
+
+func TestBookSocketController(t *testing.T) {
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/books/ws", nil)
+	if err != nil {
+		t.Fatalf("dialing the socket failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	type message struct {
+		Type   string             `json:"type"`
+		Topic  string             `json:"topic"`
+		Topics []string           `json:"topics"`
+		Error  string             `json:"error"`
+		Event  models.ChangeEvent `json:"event"`
+	}
+	exchange := func(request string) message {
+		t.Helper()
+		if request != "" {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(request)); err != nil {
+				t.Fatalf("writing %s failed: %v", request, err)
+			}
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var reply message
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatalf("reading from the socket failed: %v", err)
+		}
+		return reply
+	}
+
+	tests := []struct {
+		name     string
+		request  string
+		wantType string
+	}{
+		{"Subscribe Genre", `{"action":"subscribe","topic":"genre:Poetry"}`, "subscribed"},
+		{"Subscribe Book", `{"action":"subscribe","topic":"book:1"}`, "subscribed"},
+		{"Unsubscribe Book", `{"action":"unsubscribe","topic":"book:1"}`, "unsubscribed"},
+		{"Unknown Topic", `{"action":"subscribe","topic":"author:Orwell"}`, "error"},
+		{"Bad Book ID", `{"action":"subscribe","topic":"book:abc"}`, "error"},
+		{"Unknown Action", `{"action":"follow","topic":"all"}`, "error"},
+		{"Not JSON", `subscribe`, "error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if reply := exchange(tt.request); reply.Type != tt.wantType {
+				t.Errorf("expected %q, got %+v", tt.wantType, reply)
+			}
+		})
+	}
+
+	// only the poetry book matches a subscription
+	ctx := context.Background()
+	if _, err := store.AddBook(ctx, models.Books{Title: "Unwatched", Author: "Clerk", Genre: "Drama", Rating: 3}); err != nil {
+		t.Fatalf("AddBook() failed: %v", err)
+	}
+	poem, err := store.AddBook(ctx, models.Books{Title: "Watched", Author: "Clerk", Genre: "Poetry", Rating: 3})
+	if err != nil {
+		t.Fatalf("AddBook() failed: %v", err)
+	}
+
+	change := exchange("")
+	if change.Type != "change" || change.Event.Book.ID != poem.ID || change.Event.Type != models.EventCreated {
+		t.Fatalf("expected a created change for book %d, got %+v", poem.ID, change)
+	}
+	if len(change.Topics) != 1 || change.Topics[0] != "genre:Poetry" {
+		t.Errorf("expected the change to match genre:Poetry, got %v", change.Topics)
+	}
+
+	// a second matching topic is reported alongside the first
+	exchange(`{"action":"subscribe","topic":"book:` + strconv.Itoa(poem.ID) + `"}`)
+	store.DeleteBook(ctx, poem.ID, 0)
+	if change := exchange(""); change.Event.Type != models.EventDeleted || len(change.Topics) != 2 {
+		t.Errorf("expected a deleted change matching two topics, got %+v", change)
+	}
+
+	// a book moving out of the genre is still reported to its subscribers
+	moved, err := store.AddBook(ctx, models.Books{Title: "Moved", Author: "Clerk", Genre: "Poetry", Rating: 3})
+	if err != nil {
+		t.Fatalf("AddBook() failed: %v", err)
+	}
+	exchange("")
+	moved.Genre = "Drama"
+	if _, err := store.UpdateBook(ctx, moved); err != nil {
+		t.Fatalf("UpdateBook() failed: %v", err)
+	}
+	change = exchange("")
+	if change.Event.Type != models.EventUpdated || change.Event.Book.Genre != "Drama" || change.Event.PreviousGenre != "Poetry" {
+		t.Fatalf("expected an update moving book %d out of Poetry, got %+v", moved.ID, change)
+	}
+	if len(change.Topics) != 1 || change.Topics[0] != "genre:Poetry" {
+		t.Errorf("expected the move to match genre:Poetry, got %v", change.Topics)
+	}
+}
+
+func TestMetricsController(t *testing.T) {
+	scrape := func() map[string]float64 {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+			t.Fatalf("expected 200 in the text format, got %d %s", w.Code, w.Header().Get("Content-Type"))
+		}
+
+		samples := map[string]float64{}
+		for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+			// label values may hold spaces, the value never does
+			split := strings.LastIndex(line, " ")
+			series, value := line[:max(split, 0)], line[split+1:]
+			parsed, err := strconv.ParseFloat(value, 64)
+			if split < 0 || err != nil {
+				t.Fatalf("malformed sample %q", line)
+			}
+			samples[series] = parsed
+		}
+		return samples
+	}
+	get := func(path string) {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	before := scrape()
+	get("/books/999999")
+	get("/books/999999")
+	get("/books")
+	get("/no/such/route")
+	after := scrape()
+
+	deltas := []struct {
+		series   string
+		expected float64
+	}{
+		{`books_http_requests_total{route="/books/:id",method="GET",status="404"}`, 2},
+		{`books_http_requests_total{route="/books",method="GET",status="200"}`, 1},
+		{`books_http_requests_total{route="unmatched",method="GET",status="404"}`, 1},
+		{`books_http_request_duration_seconds_count{route="/books/:id",method="GET"}`, 2},
+		{`books_http_request_duration_seconds_bucket{route="/books/:id",method="GET",le="+Inf"}`, 2},
+		// the scrape before this one
+		{`books_http_requests_total{route="/metrics",method="GET",status="200"}`, 1},
+	}
+	for _, tt := range deltas {
+		if got := after[tt.series] - before[tt.series]; got != tt.expected {
+			t.Errorf("expected %s to go up by %v, went up by %v", tt.series, tt.expected, got)
+		}
+	}
+
+	// the scrape itself is in flight, a socket from another test may linger
+	if got := after["books_http_requests_in_flight"]; got < 1 {
+		t.Errorf("expected the scrape to be in flight, got %v", got)
+	}
+
+	books, _ := store.GetAllBooks(context.Background())
+	trash, _ := store.GetDeletedBooks(context.Background())
+	// every allowed genre is listed even when empty, and any other genre in
+	// the store is listed as well, so the series add up to the total
+	for _, genre := range models.AllowedGenres {
+		if _, listed := after[`books_store_books_by_genre{genre="`+genre+`"}`]; !listed {
+			t.Errorf("expected books_store_books_by_genre for %s", genre)
+		}
+	}
+	genres := 0.0
+	for series, value := range after {
+		if strings.HasPrefix(series, "books_store_books_by_genre{") {
+			genres += value
+		}
+	}
+	if after["books_store_up"] != 1 || after["books_store_books"] != float64(len(books)) || genres != float64(len(books)) {
+		t.Errorf("expected the store gauges to add up to %d books, got %v total and %v by genre", len(books), after["books_store_books"], genres)
+	}
+	if after["books_store_trashed_books"] != float64(len(trash)) {
+		t.Errorf("expected %d trashed books, got %v", len(trash), after["books_store_trashed_books"])
+	}
+}
@@ -1,27 +1,49 @@
 package controllers
 
 import (
+	"errors"
 	"golang-training/day_7_8/models"
+	"golang-training/day_7_8/problem"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
-func DeleteBookController(c *gin.Context, store *models.LibraryStore) {
+func DeleteBookController(c *gin.Context, store models.BookRepository) {
 	var bookToDelete struct {
-		ID int `json:"id"`
+		ID      int `json:"id"`
+		Version int `json:"version"`
 	}
 
+	limitBody(c, maxBodyBytes)
 	if err := c.ShouldBindJSON(&bookToDelete); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		badBody(c, err)
 		return
 	}
 
-	ok := store.DeleteBook(bookToDelete.ID)
-	if !ok {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+	// If-Match takes precedence over a version given in the body
+	version, viaIfMatch, err := ifMatchVersion(c.GetHeader("If-Match"), bookToDelete.ID)
+	if err != nil {
+		abortOnIfMatchError(c, err)
+		return
+	}
+	if viaIfMatch {
+		bookToDelete.Version = version
+	}
+
+	err = store.DeleteBook(c.Request.Context(), bookToDelete.ID, bookToDelete.Version)
+	if errors.Is(err, models.ErrBookNotFound) {
+		problem.Abort(c, problem.NotFound, "Book not found")
+		return
+	}
+	if errors.Is(err, models.ErrVersionConflict) {
+		versionConflict(c, viaIfMatch)
+		return
+	}
+	if err != nil {
+		internalError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Book deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Book moved to trash"})
 }
@@ -0,0 +1,18 @@
+package controllers
+
+import (
+	"golang-training/day_7_8/docs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAPIController serves the OpenAPI 3 document describing every route.
+func OpenAPIController(c *gin.Context) {
+	c.JSON(http.StatusOK, docs.Spec())
+}
+
+// DocsController serves a page that renders the OpenAPI document.
+func DocsController(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", docs.Page)
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-training/day_7_8/problem"
+	"io"
+	"net/http"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBodyBytes bounds the body of a request carrying one book or a small
+// command; even a book of escaped characters is a few kilobytes.
+const maxBodyBytes = 64 * 1024
+
+// limitBody caps how much of the request body a handler will read. Reading
+// past limit fails with *http.MaxBytesError, which badBody answers with 413.
+func limitBody(c *gin.Context, limit int64) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+}
+
+// badBody answers a body that could not be read or decoded.
+func badBody(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		problem.Abort(c, problem.TooLarge, decodeError(err))
+		return
+	}
+	problem.Abort(c, problem.BadRequest, decodeError(err))
+}
+
+// internalError answers 500 and attaches err to the context for the logger.
+// The client only gets the request ID to quote, never err itself.
+func internalError(c *gin.Context, err error) {
+	c.Error(err)
+	problem.Abort(c, problem.Internal, "The request could not be completed")
+}
+
+// decodeError describes a request body that could not be decoded, without
+// the Go type and field names encoding/json puts in its errors.
+func decodeError(err error) string {
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+		tooLarge  *http.MaxBytesError
+	)
+	switch {
+	case errors.Is(err, io.EOF):
+		return "Request body is empty"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "Request body ends in the middle of a JSON value"
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("Request body is not valid JSON, the error is at byte %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr) && typeErr.Field == "":
+		return "Request body must be " + jsonType(typeErr.Type)
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("%s must be %s", typeErr.Field, jsonType(typeErr.Type))
+	case errors.As(err, &tooLarge):
+		return fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit)
+	default:
+		return "Request body could not be read"
+	}
+}
+
+// jsonType names the JSON value a Go type is decoded from
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"golang-training/day_7_8/models"
+	"golang-training/day_7_8/problem"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the catalogue is read this many books at a time and each page is flushed
+// once written, so an export never holds more than one page in memory
+const exportPageSize = 500
+
+var exportCSVHeader = []string{"id", "title", "author", "genre", "rating", "version", "created_at", "updated_at"}
+
+// ExportBooksController streams the whole catalogue ordered by ID, as NDJSON
+// by default or as CSV with ?format=csv.
+func ExportBooksController(c *gin.Context, store models.BookRepository) {
+	format := c.DefaultQuery("format", "ndjson")
+	if format != "ndjson" && format != "csv" {
+		problem.Abort(c, problem.BadRequest, "format must be ndjson or csv")
+		return
+	}
+
+	// the first page is read before anything is sent, so a store that cannot
+	// be read still gets a proper error response
+	ctx := c.Request.Context()
+	page, err := store.QueryBooks(ctx, models.BookQuery{Limit: exportPageSize})
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="books.`+format+`"`)
+	var (
+		write func(models.Books) error
+		flush func() error
+	)
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		writer := csv.NewWriter(c.Writer)
+		writer.Write(exportCSVHeader)
+		write = func(book models.Books) error { return writer.Write(exportCSVRecord(book)) }
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	} else {
+		c.Header("Content-Type", ndjsonContentType)
+		encoder := json.NewEncoder(c.Writer)
+		write = func(book models.Books) error { return encoder.Encode(book) }
+		flush = func() error { return nil }
+	}
+	c.Status(http.StatusOK)
+
+	for {
+		for _, book := range page.Books {
+			if err := write(book); err != nil {
+				// the client went away, there is nobody left to tell
+				return
+			}
+		}
+		if err := flush(); err != nil {
+			return
+		}
+		c.Writer.Flush()
+
+		if page.NextCursor == "" {
+			return
+		}
+		page, err = store.QueryBooks(ctx, models.BookQuery{Limit: exportPageSize, Cursor: page.NextCursor})
+		if err != nil {
+			// the status has gone out already, so the stream can only stop short
+			c.Error(err)
+			return
+		}
+	}
+}
+
+func exportCSVRecord(book models.Books) []string {
+	return []string{
+		strconv.Itoa(book.ID),
+		book.Title,
+		book.Author,
+		book.Genre,
+		strconv.FormatFloat(book.Rating, 'f', -1, 64),
+		strconv.Itoa(book.Version),
+		book.CreatedAt.Format(time.RFC3339Nano),
+		book.UpdatedAt.Format(time.RFC3339Nano),
+	}
+}
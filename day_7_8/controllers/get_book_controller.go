@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"errors"
+	"golang-training/day_7_8/models"
+	"golang-training/day_7_8/problem"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetBookController(c *gin.Context, store models.BookRepository) {
+	bookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, problem.BadRequest, "Book ID must be an integer")
+		return
+	}
+
+	book, err := store.GetBook(c.Request.Context(), bookID)
+	if errors.Is(err, models.ErrBookNotFound) {
+		problem.Abort(c, problem.NotFound, "Book not found")
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	etag := bookETag(book)
+	c.Header("ETag", etag)
+
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Book retrieved successfully",
+		"book":    book,
+	})
+}
@@ -1,15 +1,96 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"golang-training/day_7_8/models"
+	"golang-training/day_7_8/problem"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
-func GetBooksController(c *gin.Context, store *models.LibraryStore) {
-	booksList := store.GetAllBooks()
+func GetBooksController(c *gin.Context, store models.BookRepository) {
+	query, err := parseBookQuery(c)
+	if err != nil {
+		problem.Abort(c, problem.BadRequest, err.Error())
+		return
+	}
+
+	page, err := store.QueryBooks(c.Request.Context(), query)
+	if errors.Is(err, models.ErrInvalidQuery) {
+		problem.Abort(c, problem.BadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
 	c.JSON(200, gin.H{
-		"message": "Books retrieved successfully",
-		"books":   booksList,
+		"message":     "Books retrieved successfully",
+		"books":       page.Books,
+		"total":       page.Total,
+		"next_cursor": page.NextCursor,
 	})
 }
+
+// parseBookQuery reads ?limit=&offset=&cursor=&sort=&order=&genre=&author=&min_rating=&max_rating=
+func parseBookQuery(c *gin.Context) (models.BookQuery, error) {
+	query := models.BookQuery{
+		Cursor: c.Query("cursor"),
+		SortBy: c.Query("sort"),
+		Genre:  c.Query("genre"),
+		Author: c.Query("author"),
+	}
+
+	var err error
+	if query.Limit, err = intQuery(c, "limit"); err != nil {
+		return query, err
+	}
+	if query.Offset, err = intQuery(c, "offset"); err != nil {
+		return query, err
+	}
+	if query.MinRating, err = floatQuery(c, "min_rating"); err != nil {
+		return query, err
+	}
+	if query.MaxRating, err = floatQuery(c, "max_rating"); err != nil {
+		return query, err
+	}
+
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, fmt.Errorf("order must be asc or desc")
+	}
+
+	return query, nil
+}
+
+func intQuery(c *gin.Context, name string) (int, error) {
+	value, ok := c.GetQuery(name)
+	if !ok {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer", name)
+	}
+	return n, nil
+}
+
+func floatQuery(c *gin.Context, name string) (*float64, error) {
+	value, ok := c.GetQuery(name)
+	if !ok {
+		return nil, nil
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a number", name)
+	}
+	return &n, nil
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"errors"
+	"golang-training/day_7_8/models"
+	"golang-training/day_7_8/problem"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetBookHistoryController(c *gin.Context, store models.BookRepository) {
+	bookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, problem.BadRequest, "Book ID must be an integer")
+		return
+	}
+
+	history, err := store.GetBookHistory(c.Request.Context(), bookID)
+	if errors.Is(err, models.ErrBookNotFound) {
+		problem.Abort(c, problem.NotFound, "Book not found")
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "History retrieved successfully",
+		"history": history,
+	})
+}
+
+// RevertBookController rolls a book back to the state a revision left it in,
+// given as {"revision": n}.
+func RevertBookController(c *gin.Context, store models.BookRepository) {
+	bookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, problem.BadRequest, "Book ID must be an integer")
+		return
+	}
+
+	var request struct {
+		Revision int `json:"revision" binding:"required,min=1"`
+	}
+	limitBody(c, maxBodyBytes)
+	if err := c.ShouldBindJSON(&request); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			badBody(c, err)
+			return
+		}
+		problem.Abort(c, problem.BadRequest, "revision must be a positive integer")
+		return
+	}
+
+	book, err := store.RevertBook(c.Request.Context(), bookID, request.Revision)
+	if errors.Is(err, models.ErrBookNotFound) {
+		problem.Abort(c, problem.NotFound, "Book not found")
+		return
+	}
+	if errors.Is(err, models.ErrRevisionNotFound) {
+		problem.Abort(c, problem.NotFound, "Revision not found")
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	c.Header("ETag", bookETag(book))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Book reverted successfully",
+		"book":    book,
+	})
+}
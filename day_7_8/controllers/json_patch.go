@@ -0,0 +1,304 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	// errMalformedPatch means the patch document itself is broken
+	errMalformedPatch = errors.New("malformed patch")
+	// errPatchConflict means the patch is well formed but does not fit the
+	// current state of the book, e.g. a failed test or a missing path
+	errPatchConflict = errors.New("patch cannot be applied")
+)
+
+// mergePatch applies an RFC 7386 JSON Merge Patch: objects merge
+// recursively, null removes a member and anything else replaces the target.
+func mergePatch(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+
+	return targetObject
+}
+
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// jsonPatch applies an RFC 6902 JSON Patch. Operations run in order and the
+// first failure aborts the whole patch.
+func jsonPatch(doc any, patch []byte) (any, error) {
+	var operations []patchOperation
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, fmt.Errorf("%w: a JSON Patch must be an array of operations", errMalformedPatch)
+	}
+
+	for i, op := range operations {
+		var err error
+		if doc, err = applyOperation(doc, op); err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+		}
+	}
+
+	return doc, nil
+}
+
+func applyOperation(doc any, op patchOperation) (any, error) {
+	if op.Path == nil {
+		return nil, fmt.Errorf("%w: missing path", errMalformedPatch)
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: missing value", errMalformedPatch)
+		}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, fmt.Errorf("%w: %v", errMalformedPatch, err)
+		}
+	case "move", "copy":
+		if op.From == nil {
+			return nil, fmt.Errorf("%w: missing from", errMalformedPatch)
+		}
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" && isPrefix(from, path) && len(from) < len(path) {
+			return nil, fmt.Errorf("%w: cannot move a value into itself", errMalformedPatch)
+		}
+
+		if value, err = getPointer(doc, from); err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if doc, err = removePointer(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			value = deepCopy(value)
+		}
+	case "remove":
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", errMalformedPatch, op.Op)
+	}
+
+	switch op.Op {
+	case "add", "move", "copy":
+		return addPointer(doc, path, value)
+	case "remove":
+		return removePointer(doc, path)
+	case "replace":
+		if doc, err = removePointer(doc, path); err != nil {
+			return nil, err
+		}
+		return addPointer(doc, path, value)
+	default: // test
+		current, err := getPointer(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, fmt.Errorf("%w: test failed at %q", errPatchConflict, *op.Path)
+		}
+		return doc, nil
+	}
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: JSON Pointer %q must start with /", errMalformedPatch, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func arrayIndex(array []any, token string, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return len(array), nil
+	}
+
+	// RFC 6901 forbids leading zeros and signs
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (len(token) > 1 && token[0] == '0') || strings.HasPrefix(token, "+") {
+		return 0, fmt.Errorf("%w: %q is not an array index", errPatchConflict, token)
+	}
+
+	limit := len(array)
+	if allowEnd {
+		limit++
+	}
+	if index >= limit {
+		return 0, fmt.Errorf("%w: array index %d out of range", errPatchConflict, index)
+	}
+	return index, nil
+}
+
+func child(doc any, token string) (any, error) {
+	switch node := doc.(type) {
+	case map[string]any:
+		value, exists := node[token]
+		if !exists {
+			return nil, fmt.Errorf("%w: member %q does not exist", errPatchConflict, token)
+		}
+		return value, nil
+	case []any:
+		index, err := arrayIndex(node, token, false)
+		if err != nil {
+			return nil, err
+		}
+		return node[index], nil
+	default:
+		return nil, fmt.Errorf("%w: cannot descend into a scalar at %q", errPatchConflict, token)
+	}
+}
+
+func getPointer(doc any, path []string) (any, error) {
+	for _, token := range path {
+		var err error
+		if doc, err = child(doc, token); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// updateAt walks to the parent of path and lets change rewrite the container
+// holding the last token. Containers are rebuilt on the way back up because
+// inserting into or removing from a slice can move it.
+func updateAt(doc any, path []string, change func(container any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return change(doc, path[0])
+	}
+
+	next, err := child(doc, path[0])
+	if err != nil {
+		return nil, err
+	}
+	if next, err = updateAt(next, path[1:], change); err != nil {
+		return nil, err
+	}
+
+	switch node := doc.(type) {
+	case map[string]any:
+		node[path[0]] = next
+	case []any:
+		index, _ := arrayIndex(node, path[0], false)
+		node[index] = next
+	}
+	return doc, nil
+}
+
+func addPointer(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return updateAt(doc, path, func(container any, token string) (any, error) {
+		switch node := container.(type) {
+		case map[string]any:
+			node[token] = value
+			return node, nil
+		case []any:
+			index, err := arrayIndex(node, token, true)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:index], append([]any{value}, node[index:]...)...), nil
+		default:
+			return nil, fmt.Errorf("%w: cannot add a member to a scalar", errPatchConflict)
+		}
+	})
+}
+
+func removePointer(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+
+	return updateAt(doc, path, func(container any, token string) (any, error) {
+		switch node := container.(type) {
+		case map[string]any:
+			if _, exists := node[token]; !exists {
+				return nil, fmt.Errorf("%w: member %q does not exist", errPatchConflict, token)
+			}
+			delete(node, token)
+			return node, nil
+		case []any:
+			index, err := arrayIndex(node, token, false)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:index], node[index+1:]...), nil
+		default:
+			return nil, fmt.Errorf("%w: cannot remove a member from a scalar", errPatchConflict)
+		}
+	})
+}
+
+func deepCopy(value any) any {
+	switch node := value.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(node))
+		for key, item := range node {
+			copied[key] = deepCopy(item)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(node))
+		for i, item := range node {
+			copied[i] = deepCopy(item)
+		}
+		return copied
+	default:
+		return value
+	}
+}
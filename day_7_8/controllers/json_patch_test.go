@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, doc string) any {
+	var value any
+	if err := json.Unmarshal([]byte(doc), &value); err != nil {
+		t.Fatalf("bad test JSON %s: %v", doc, err)
+	}
+	return value
+}
+
+// cases from the examples in RFC 7386 appendix A
+func TestMergePatch(t *testing.T) {
+	testCases := []struct {
+		target string
+		patch  string
+		result string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.target+" + "+tc.patch, func(t *testing.T) {
+			got := mergePatch(decodeJSON(t, tc.target), decodeJSON(t, tc.patch))
+
+			if want := decodeJSON(t, tc.result); !reflect.DeepEqual(got, want) {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+// cases from the examples in RFC 6902 appendix A
+func TestJSONPatch(t *testing.T) {
+	testCases := []struct {
+		name   string
+		doc    string
+		patch  string
+		result string
+		err    error
+	}{
+		{
+			name:   "Add Object Member",
+			doc:    `{"foo":"bar"}`,
+			patch:  `[{"op":"add","path":"/baz","value":"qux"}]`,
+			result: `{"baz":"qux","foo":"bar"}`,
+		},
+		{
+			name:   "Add Array Element",
+			doc:    `{"foo":["bar","baz"]}`,
+			patch:  `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			result: `{"foo":["bar","qux","baz"]}`,
+		},
+		{
+			name:   "Remove Object Member",
+			doc:    `{"baz":"qux","foo":"bar"}`,
+			patch:  `[{"op":"remove","path":"/baz"}]`,
+			result: `{"foo":"bar"}`,
+		},
+		{
+			name:   "Remove Array Element",
+			doc:    `{"foo":["bar","qux","baz"]}`,
+			patch:  `[{"op":"remove","path":"/foo/1"}]`,
+			result: `{"foo":["bar","baz"]}`,
+		},
+		{
+			name:   "Replace Value",
+			doc:    `{"baz":"qux","foo":"bar"}`,
+			patch:  `[{"op":"replace","path":"/baz","value":"boo"}]`,
+			result: `{"baz":"boo","foo":"bar"}`,
+		},
+		{
+			name:   "Move Value",
+			doc:    `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			patch:  `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			result: `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+		},
+		{
+			name:   "Move Array Element",
+			doc:    `{"foo":["all","grass","cows","eat"]}`,
+			patch:  `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			result: `{"foo":["all","cows","eat","grass"]}`,
+		},
+		{
+			name:   "Test Success",
+			doc:    `{"baz":"qux","foo":["a",2,"c"]}`,
+			patch:  `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			result: `{"baz":"qux","foo":["a",2,"c"]}`,
+		},
+		{
+			name:  "Test Failure",
+			doc:   `{"baz":"qux"}`,
+			patch: `[{"op":"test","path":"/baz","value":"bar"}]`,
+			err:   errPatchConflict,
+		},
+		{
+			name:   "Add Nested Member",
+			doc:    `{"foo":"bar"}`,
+			patch:  `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			result: `{"foo":"bar","child":{"grandchild":{}}}`,
+		},
+		{
+			name:   "Ignore Unrecognized Members",
+			doc:    `{"foo":"bar"}`,
+			patch:  `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`,
+			result: `{"foo":"bar","baz":"qux"}`,
+		},
+		{
+			name:  "Add To Nonexistent Target",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+			err:   errPatchConflict,
+		},
+		{
+			name:   "Escape Ordering",
+			doc:    `{"/":9,"~1":10}`,
+			patch:  `[{"op":"test","path":"/~01","value":10}]`,
+			result: `{"/":9,"~1":10}`,
+		},
+		{
+			name:  "Comparing Strings And Numbers",
+			doc:   `{"/":9,"~1":10}`,
+			patch: `[{"op":"test","path":"/~01","value":"10"}]`,
+			err:   errPatchConflict,
+		},
+		{
+			name:   "Add Array Value",
+			doc:    `{"foo":["bar"]}`,
+			patch:  `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			result: `{"foo":["bar",["abc","def"]]}`,
+		},
+		{
+			name:   "Copy Is Deep",
+			doc:    `{"a":{"b":1}}`,
+			patch:  `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`,
+			result: `{"a":{"b":1},"c":{"b":2}}`,
+		},
+		{
+			name:  "Remove Missing Member",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"remove","path":"/baz"}]`,
+			err:   errPatchConflict,
+		},
+		{
+			name:  "Index With Leading Zero",
+			doc:   `{"foo":["a","b"]}`,
+			patch: `[{"op":"remove","path":"/foo/01"}]`,
+			err:   errPatchConflict,
+		},
+		{
+			name:  "Move Into Own Child",
+			doc:   `{"a":{"b":1}}`,
+			patch: `[{"op":"move","from":"/a","path":"/a/b"}]`,
+			err:   errMalformedPatch,
+		},
+		{
+			name:  "Unknown Op",
+			doc:   `{}`,
+			patch: `[{"op":"merge","path":"/a","value":1}]`,
+			err:   errMalformedPatch,
+		},
+		{
+			name:  "Missing Value",
+			doc:   `{}`,
+			patch: `[{"op":"add","path":"/a"}]`,
+			err:   errMalformedPatch,
+		},
+		{
+			name:  "Not An Array",
+			doc:   `{}`,
+			patch: `{"op":"add","path":"/a","value":1}`,
+			err:   errMalformedPatch,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := jsonPatch(decodeJSON(t, tc.doc), []byte(tc.patch))
+
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("expected %v, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("jsonPatch() failed: %v", err)
+			}
+			if want := decodeJSON(t, tc.result); !reflect.DeepEqual(got, want) {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+		})
+	}
+}
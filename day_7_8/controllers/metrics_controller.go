@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"golang-training/day_7_8/middlewares"
+	"golang-training/day_7_8/models"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsController serves the request metrics plus gauges read from the
+// store at scrape time. If the store cannot be read the request metrics are
+// still served, with books_store_up at 0.
+func MetricsController(c *gin.Context, metrics *middlewares.Metrics, store models.BookRepository) {
+	var b strings.Builder
+	metrics.WriteTo(&b)
+
+	counts, err := store.CountBooks(c.Request.Context())
+
+	middlewares.WriteMetricHeader(&b, "books_store_up", "gauge", "Whether the store could be read for this scrape.")
+	if err != nil {
+		c.Error(err)
+		middlewares.WriteMetric(&b, "books_store_up", 0)
+	} else {
+		middlewares.WriteMetric(&b, "books_store_up", 1)
+
+		middlewares.WriteMetricHeader(&b, "books_store_books", "gauge", "Books in the catalogue, not counting the trash.")
+		middlewares.WriteMetric(&b, "books_store_books", float64(counts.Live))
+
+		// the allowed genres are always listed, so a genre running empty reads
+		// 0 rather than vanishing; any other genre in the store is listed too
+		genres := slices.Clone(models.AllowedGenres)
+		for genre := range counts.ByGenre {
+			if !slices.Contains(genres, genre) {
+				genres = append(genres, genre)
+			}
+		}
+		slices.Sort(genres)
+		middlewares.WriteMetricHeader(&b, "books_store_books_by_genre", "gauge", "Books in the catalogue, by genre.")
+		for _, genre := range genres {
+			middlewares.WriteMetric(&b, "books_store_books_by_genre", float64(counts.ByGenre[genre]), "genre", genre)
+		}
+
+		middlewares.WriteMetricHeader(&b, "books_store_trashed_books", "gauge", "Books in the trash waiting to be purged.")
+		middlewares.WriteMetric(&b, "books_store_trashed_books", float64(counts.Trashed))
+	}
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
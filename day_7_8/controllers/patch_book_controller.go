@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-training/day_7_8/models"
+	"golang-training/day_7_8/problem"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+
+	// without If-Match a patch is re-applied to the fresh book this many
+	// times when a concurrent write gets in between the read and the write
+	patchAttempts = 3
+)
+
+var errInvalidPatchedBook = errors.New("patched book is invalid")
+
+// PatchBookController applies a JSON Merge Patch (RFC 7386), or a JSON Patch
+// (RFC 6902) when sent as application/json-patch+json, to a single book.
+func PatchBookController(c *gin.Context, store models.BookRepository) {
+	bookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, problem.BadRequest, "Book ID must be an integer")
+		return
+	}
+
+	limitBody(c, maxBodyBytes)
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		badBody(c, err)
+		return
+	}
+
+	var apply func(doc any) (any, error)
+	switch c.ContentType() {
+	case mergePatchContentType, "application/json":
+		var patch any
+		if err := json.Unmarshal(body, &patch); err != nil {
+			problem.Abort(c, problem.BadRequest, decodeError(err))
+			return
+		}
+		apply = func(doc any) (any, error) {
+			return mergePatch(doc, deepCopy(patch)), nil
+		}
+	case jsonPatchContentType:
+		apply = func(doc any) (any, error) {
+			return jsonPatch(doc, body)
+		}
+	default:
+		c.Header("Accept-Patch", mergePatchContentType+", "+jsonPatchContentType)
+		problem.Abort(c, problem.UnsupportedMediaType, "Content-Type must be "+mergePatchContentType+" or "+jsonPatchContentType)
+		return
+	}
+
+	version, viaIfMatch, err := ifMatchVersion(c.GetHeader("If-Match"), bookID)
+	if err != nil {
+		abortOnIfMatchError(c, err)
+		return
+	}
+	conditional := viaIfMatch && version != 0
+
+	for range patchAttempts {
+		current, err := store.GetBook(c.Request.Context(), bookID)
+		if errors.Is(err, models.ErrBookNotFound) {
+			problem.Abort(c, problem.NotFound, "Book not found")
+			return
+		}
+		if err != nil {
+			internalError(c, err)
+			return
+		}
+		if conditional && current.Version != version {
+			versionConflict(c, true)
+			return
+		}
+
+		patched, err := patchBook(current, apply)
+		switch {
+		case errors.Is(err, errMalformedPatch), errors.Is(err, errInvalidPatchedBook):
+			problem.Abort(c, problem.BadRequest, err.Error())
+			return
+		case fieldErrors(err) != nil:
+			invalidBook(c, err)
+			return
+		case errors.Is(err, errPatchConflict):
+			problem.Abort(c, problem.VersionConflict, err.Error())
+			return
+		case err != nil:
+			internalError(c, err)
+			return
+		}
+
+		// the write only lands if nobody else wrote since the read above
+		patched.Version = current.Version
+		updated, err := store.UpdateBook(c.Request.Context(), patched)
+		if errors.Is(err, models.ErrVersionConflict) {
+			if conditional {
+				versionConflict(c, true)
+				return
+			}
+			continue
+		}
+		if errors.Is(err, models.ErrBookNotFound) {
+			problem.Abort(c, problem.NotFound, "Book not found")
+			return
+		}
+		if err != nil {
+			internalError(c, err)
+			return
+		}
+
+		c.Header("ETag", bookETag(updated))
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Book patched successfully",
+			"book":    updated,
+		})
+		return
+	}
+
+	problem.Abort(c, problem.VersionConflict, "Book kept changing while the patch was applied, please retry")
+}
+
+// patchBook runs a patch over the JSON form of a book and decodes and
+// validates the result. The ID cannot be patched and CreatedAt always survives.
+func patchBook(book models.Books, apply func(doc any) (any, error)) (models.Books, error) {
+	data, err := json.Marshal(book)
+	if err != nil {
+		return models.Books{}, err
+	}
+
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return models.Books{}, err
+	}
+
+	if doc, err = apply(doc); err != nil {
+		return models.Books{}, err
+	}
+
+	if data, err = json.Marshal(doc); err != nil {
+		return models.Books{}, err
+	}
+
+	patched, provided, err := models.DecodeBook(data, true)
+	if errors.As(err, new(*models.ValidationError)) {
+		return models.Books{}, err
+	}
+	if err != nil {
+		// a patch can turn the book into any JSON value, e.g. an array
+		return models.Books{}, fmt.Errorf("%w: %v", errInvalidPatchedBook, err)
+	}
+
+	if patched.ID != book.ID {
+		return models.Books{}, fmt.Errorf("%w: id cannot be changed", errInvalidPatchedBook)
+	}
+	patched.CreatedAt = book.CreatedAt
+
+	return patched, models.ValidateBook(patched, provided)
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"errors"
+	"golang-training/day_7_8/models"
+	"golang-training/day_7_8/problem"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultSearchLimit = 20
+
+func SearchBooksController(c *gin.Context, store models.BookRepository) {
+	limit, err := intQuery(c, "limit")
+	if err != nil {
+		problem.Abort(c, problem.BadRequest, err.Error())
+		return
+	}
+	if _, ok := c.GetQuery("limit"); !ok {
+		limit = defaultSearchLimit
+	}
+
+	results, err := store.SearchBooks(c.Request.Context(), c.Query("q"), limit)
+	if errors.Is(err, models.ErrInvalidQuery) {
+		problem.Abort(c, problem.BadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Books searched successfully",
+		"results": results,
+	})
+}
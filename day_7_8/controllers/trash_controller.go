@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"errors"
+	"golang-training/day_7_8/models"
+	"golang-training/day_7_8/problem"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetTrashController lists soft-deleted books, most recently deleted first.
+func GetTrashController(c *gin.Context, store models.BookRepository) {
+	books, err := store.GetDeletedBooks(c.Request.Context())
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Trash retrieved successfully",
+		"books":   books,
+	})
+}
+
+func RestoreBookController(c *gin.Context, store models.BookRepository) {
+	bookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, problem.BadRequest, "Book ID must be an integer")
+		return
+	}
+
+	book, err := store.RestoreBook(c.Request.Context(), bookID)
+	if errors.Is(err, models.ErrBookNotFound) {
+		problem.Abort(c, problem.NotFound, "Book not found in trash")
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	c.Header("ETag", bookETag(book))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Book restored successfully",
+		"book":    book,
+	})
+}
+
+// PurgeTrashController empties the trash for good. older_than, e.g. "24h",
+// keeps the books deleted more recently than that.
+func PurgeTrashController(c *gin.Context, store models.BookRepository) {
+	var olderThan time.Duration
+	if value := c.Query("older_than"); value != "" {
+		var err error
+		if olderThan, err = time.ParseDuration(value); err != nil || olderThan < 0 {
+			problem.Abort(c, problem.BadRequest, "older_than must be a duration such as 24h")
+			return
+		}
+	}
+
+	purged, err := store.PurgeDeletedBooks(c.Request.Context(), time.Now().Add(-olderThan))
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Trash purged successfully",
+		"purged":  purged,
+	})
+}
@@ -1,29 +1,46 @@
 package controllers
 
 import (
+	"errors"
 	"golang-training/day_7_8/models"
+	"golang-training/day_7_8/problem"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
-func UpdateBookController(c *gin.Context, store *models.LibraryStore) {
-	var (
-		updatedBook models.Books
-		ok          bool
-	)
+func UpdateBookController(c *gin.Context, store models.BookRepository) {
+	updatedBook, err := bindBook(c)
+	if err != nil {
+		invalidBook(c, err)
+		return
+	}
 
-	if err := c.ShouldBindJSON(&updatedBook); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	// If-Match takes precedence over a version given in the body
+	version, viaIfMatch, err := ifMatchVersion(c.GetHeader("If-Match"), updatedBook.ID)
+	if err != nil {
+		abortOnIfMatchError(c, err)
 		return
 	}
+	if viaIfMatch {
+		updatedBook.Version = version
+	}
 
-	updatedBook, ok = store.UpdateBook(updatedBook)
-	if !ok {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+	updatedBook, err = store.UpdateBook(c.Request.Context(), updatedBook)
+	if errors.Is(err, models.ErrBookNotFound) {
+		problem.Abort(c, problem.NotFound, "Book not found")
+		return
+	}
+	if errors.Is(err, models.ErrVersionConflict) {
+		versionConflict(c, viaIfMatch)
+		return
+	}
+	if err != nil {
+		internalError(c, err)
 		return
 	}
 
+	c.Header("ETag", bookETag(updatedBook))
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Book updated successfully",
 		"book":    updatedBook,
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"errors"
+	"golang-training/day_7_8/models"
+	"golang-training/day_7_8/problem"
+	"io"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bindBook decodes the request body into a book and checks it against the
+// rules declared on models.Books.
+func bindBook(c *gin.Context) (models.Books, error) {
+	limitBody(c, maxBodyBytes)
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return models.Books{}, err
+	}
+
+	book, provided, err := models.DecodeBook(body, false)
+	if err != nil {
+		return models.Books{}, err
+	}
+	return book, models.ValidateBook(book, provided)
+}
+
+// invalidBook answers 400 for a book that could not be decoded, listing every
+// broken rule when it decoded but failed validation, or 413 for a body
+// over the limit.
+func invalidBook(c *gin.Context, err error) {
+	var invalid *models.ValidationError
+	if errors.As(err, &invalid) {
+		problem.AbortWith(c, problem.InvalidBook, invalid.Error(), map[string]any{"errors": invalid.Errors})
+		return
+	}
+	badBody(c, err)
+}
+
+// fieldErrors returns the per-field details of err, if it has any
+func fieldErrors(err error) []models.FieldError {
+	var invalid *models.ValidationError
+	if errors.As(err, &invalid) {
+		return invalid.Errors
+	}
+	return nil
+}
@@ -0,0 +1,663 @@
+// Package docs describes the books API as an OpenAPI 3 document and bundles
+// a page that renders it.
+package docs
+
+import (
+	"cmp"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang-training/day_7_8/models"
+	"golang-training/day_7_8/problem"
+)
+
+// Document is the subset of OpenAPI 3.0 this API needs.
+type Document struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Tags       []Tag                 `json:"tags"`
+	Paths      map[string]PathItem   `json:"paths"`
+	Components Components            `json:"components"`
+	Security   []map[string][]string `json:"security"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem maps a lower case HTTP method to its operation.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary"`
+	Description string              `json:"description,omitempty"`
+	Tags        []string            `json:"tags"`
+	Parameters  []*Parameter        `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+type Parameter struct {
+	Ref         string  `json:"$ref,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	In          string  `json:"in,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Ref         string               `json:"$ref,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Minimum     *float64           `json:"minimum,omitempty"`
+	Maximum     *float64           `json:"maximum,omitempty"`
+	MaxLength   *int               `json:"maxLength,omitempty"`
+	ReadOnly    bool               `json:"readOnly,omitempty"`
+	Nullable    bool               `json:"nullable,omitempty"`
+	OneOf       []*Schema          `json:"oneOf,omitempty"`
+	AllOf       []*Schema          `json:"allOf,omitempty"`
+	Example     any                `json:"example,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	Parameters      map[string]*Parameter     `json:"parameters"`
+	Responses       map[string]Response       `json:"responses"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Name         string `json:"name,omitempty"`
+	In           string `json:"in,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// schemaNames are the types that get a schema of their own under
+// components, and are referred to by name everywhere else
+var schemaNames = map[reflect.Type]string{
+	reflect.TypeFor[models.Books]():        "Book",
+	reflect.TypeFor[models.FieldError]():   "FieldError",
+	reflect.TypeFor[models.HistoryEntry](): "HistoryEntry",
+	reflect.TypeFor[models.ChangeEvent]():  "ChangeEvent",
+	reflect.TypeFor[models.SearchResult](): "SearchResult",
+}
+
+// bookFieldNotes explains the Books fields that the tags alone do not
+var bookFieldNotes = map[string]string{
+	"id":         "Chosen by the server when left out or 0. IDs are never reused.",
+	"version":    "Bumped on every write. Send it back on PUT or DELETE, or use If-Match, to make the write conditional.",
+	"deleted_at": "Only set while the book is in the trash.",
+}
+
+var readOnlyFields = map[string]bool{"created_at": true, "updated_at": true, "deleted_at": true}
+
+// Spec returns the document for every route the server registers. It is
+// built once; callers must not change it.
+var Spec = sync.OnceValue(func() *Document {
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info: Info{
+			Title:   "Books API",
+			Version: "1.0.0",
+			Description: "A catalogue of books with a trash, a change history and a live change feed. " +
+				"Authentication and authorization are only enforced when the auth and authorize middlewares are enabled.",
+		},
+		Tags: []Tag{
+			{Name: "books", Description: "Reading and writing the catalogue"},
+			{Name: "trash", Description: "Deleted books, until they are purged"},
+			{Name: "history", Description: "Every change made to a book"},
+			{Name: "events", Description: "Live change notifications"},
+			{Name: "operations", Description: "Metrics, profiling and these docs"},
+		},
+		Paths: map[string]PathItem{},
+		Components: Components{
+			Schemas:    map[string]*Schema{},
+			Parameters: parameters(),
+			Responses:  responses(),
+			SecuritySchemes: map[string]SecurityScheme{
+				"apiKey": {Type: "apiKey", In: "header", Name: "X-API-Key", Description: "A key from the server's API keys file"},
+				"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT", Description: "An HS256 or RS256 token whose sub names the caller and whose roles claim lists their roles"},
+			},
+		},
+		// the empty requirement is for a server running without the auth middleware
+		Security: []map[string][]string{{"apiKey": {}}, {"bearer": {}}, {}},
+	}
+	for t, name := range schemaNames {
+		doc.Components.Schemas[name] = structSchema(t)
+	}
+	doc.Components.Schemas["Problem"] = problemSchema()
+	doc.Components.Schemas["InvalidBookProblem"] = withProblem(object(map[string]*Schema{
+		"errors": arrayOf(ref("FieldError")),
+	}, "errors"))
+	doc.Components.Schemas["BulkResult"] = object(map[string]*Schema{
+		"index":   {Type: "integer", Description: "Position of the item in the request, from 0"},
+		"status":  {Type: "string", Enum: []string{"created", "failed", "skipped"}},
+		"book":    ref("Book"),
+		"error":   {Type: "string"},
+		"details": arrayOf(ref("FieldError")),
+	}, "index", "status")
+
+	for _, op := range operations() {
+		add(doc, op)
+	}
+	return doc
+})
+
+// route is one operation and where it lives
+type route struct {
+	method string
+	path   string
+	op     *Operation
+}
+
+// add files an operation under its path, converting gin's :name and *name
+// segments to OpenAPI's {name}. Every operation can also be turned away by
+// the middlewares, so their responses are added here rather than to each.
+func add(doc *Document, r route) {
+	path := PathFromRoute(r.path)
+	if doc.Paths[path] == nil {
+		doc.Paths[path] = PathItem{}
+	}
+	for _, status := range []string{"401", "403", "429", "500"} {
+		if _, set := r.op.Responses[status]; !set {
+			r.op.Responses[status] = Response{Ref: "#/components/responses/" + status}
+		}
+	}
+	doc.Paths[path][strings.ToLower(r.method)] = r.op
+}
+
+// PathFromRoute turns a gin route template into an OpenAPI path.
+func PathFromRoute(route string) string {
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func operations() []route {
+	bookEnvelope := func(message string) *Schema {
+		return envelope(message, map[string]*Schema{"book": ref("Book")})
+	}
+	booksPage := envelope("Books retrieved successfully", map[string]*Schema{
+		"books":       arrayOf(ref("Book")),
+		"total":       {Type: "integer", Description: "Books matching the filters, across every page"},
+		"next_cursor": {Type: "string", Description: "Pass as cursor for the next page; empty on the last page"},
+	})
+	etag := map[string]Header{"ETag": {Description: "Strong validator of the returned book, usable in If-Match", Schema: &Schema{Type: "string"}}}
+	replayed := map[string]Header{"Idempotent-Replayed": {Description: "Set to true when the response is a replay of an earlier request", Schema: &Schema{Type: "string"}}}
+
+	routes := []route{
+		{"GET", "/books", &Operation{
+			OperationID: "listBooks", Summary: "List books", Tags: []string{"books"},
+			Description: "Pages with limit and offset, or with the cursor from the previous page.",
+			Parameters: []*Parameter{
+				query("limit", "Books per page; 0 or absent returns them all", integer(0, nil)),
+				query("offset", "Books to skip; cannot be combined with cursor", integer(0, nil)),
+				query("cursor", "next_cursor from the previous page", &Schema{Type: "string"}),
+				query("sort", "Field to sort by", enum(models.SortByID, models.SortByTitle, models.SortByAuthor, models.SortByRating, models.SortByCreatedAt)),
+				query("order", "Sort direction", enum("asc", "desc")),
+				query("genre", "Only books of this genre", &Schema{Type: "string"}),
+				query("author", "Only books by this author", &Schema{Type: "string"}),
+				query("min_rating", "Lowest rating to include", number(0, 5)),
+				query("max_rating", "Highest rating to include", number(0, 5)),
+			},
+			Responses: map[string]Response{"200": jsonResponse("A page of books", booksPage), "400": refResponse("400")},
+		}},
+		{"GET", "/books/export", &Operation{
+			OperationID: "exportBooks", Summary: "Export every book", Tags: []string{"books"},
+			Description: "Streams the whole catalogue as a file download.",
+			Parameters:  []*Parameter{query("format", "File format", enum("ndjson", "csv"))},
+			Responses: map[string]Response{
+				"200": {Description: "One book per line", Content: map[string]MediaType{
+					"application/x-ndjson":    {Schema: ref("Book")},
+					"text/csv; charset=utf-8": {Schema: &Schema{Type: "string"}},
+				}},
+				"400": refResponse("400"),
+			},
+		}},
+		{"GET", "/books/events", &Operation{
+			OperationID: "streamBookEvents", Summary: "Stream changes as server-sent events", Tags: []string{"events"},
+			Description: "Each event is named created, updated or deleted and carries a ChangeEvent. " +
+				"A reset event means changes were missed and the catalogue should be reloaded.",
+			Parameters: []*Parameter{{Name: "Last-Event-ID", In: "header", Description: "Resume after this event, given as its SSE id: the epoch and the event id joined by a dash", Schema: &Schema{Type: "string"}}},
+			Responses: map[string]Response{
+				"200": {Description: "An endless event stream", Content: map[string]MediaType{"text/event-stream": {Schema: ref("ChangeEvent")}}},
+				"400": refResponse("400"),
+			},
+		}},
+		{"GET", "/books/ws", &Operation{
+			OperationID: "bookSocket", Summary: "Subscribe to changes over a WebSocket", Tags: []string{"events"},
+			Description: `Send {"action": "subscribe" or "unsubscribe", "topic": "all", "genre:<genre>" or "book:<id>"}. ` +
+				`Changes arrive as {"type": "change", "topics": [...], "event": ChangeEvent}.`,
+			Responses: map[string]Response{
+				"101": {Description: "Switched to the WebSocket protocol"},
+				"400": {Description: "Not a WebSocket handshake"},
+			},
+		}},
+		{"GET", "/books/trash", &Operation{
+			OperationID: "listTrash", Summary: "List deleted books", Tags: []string{"trash"},
+			Responses: map[string]Response{"200": jsonResponse("Books in the trash", envelope("Trash retrieved successfully", map[string]*Schema{"books": arrayOf(ref("Book"))}))},
+		}},
+		{"DELETE", "/books/trash", &Operation{
+			OperationID: "purgeTrash", Summary: "Purge deleted books for good", Tags: []string{"trash"},
+			Parameters: []*Parameter{query("older_than", "Keep books deleted more recently than this duration, e.g. 24h", &Schema{Type: "string"})},
+			Responses: map[string]Response{
+				"200": jsonResponse("How many books were purged", envelope("Trash purged successfully", map[string]*Schema{"purged": {Type: "integer"}})),
+				"400": refResponse("400"),
+			},
+		}},
+		{"GET", "/books/search", &Operation{
+			OperationID: "searchBooks", Summary: "Search titles, authors and genres", Tags: []string{"books"},
+			Parameters: []*Parameter{
+				query("q", "Words to look for; the last one may be a prefix", &Schema{Type: "string"}),
+				query("limit", "Most results to return", integer(0, nil)),
+			},
+			Responses: map[string]Response{
+				"200": jsonResponse("Best matches first", envelope("Books searched successfully", map[string]*Schema{"results": arrayOf(ref("SearchResult"))})),
+				"400": refResponse("400"),
+			},
+		}},
+		{"GET", "/books/:id", &Operation{
+			OperationID: "getBook", Summary: "Get a book", Tags: []string{"books"},
+			Parameters: []*Parameter{paramRef("BookID"), paramRef("IfNoneMatch")},
+			Responses: map[string]Response{
+				"200": withHeaders(jsonResponse("The book", bookEnvelope("Book retrieved successfully")), etag),
+				"304": {Description: "The book still matches If-None-Match"},
+				"400": refResponse("400"), "404": refResponse("404"),
+			},
+		}},
+		{"GET", "/books/:id/history", &Operation{
+			OperationID: "getBookHistory", Summary: "List every change to a book", Tags: []string{"history"},
+			Description: "Kept even after the book is purged.",
+			Parameters:  []*Parameter{paramRef("BookID")},
+			Responses: map[string]Response{
+				"200": jsonResponse("Oldest change first", envelope("History retrieved successfully", map[string]*Schema{"history": arrayOf(ref("HistoryEntry"))})),
+				"400": refResponse("400"), "404": refResponse("404"),
+			},
+		}},
+		{"POST", "/books", &Operation{
+			OperationID: "addBook", Summary: "Add a book", Tags: []string{"books"},
+			Description: "A retry sent with the same Idempotency-Key gets the first response again instead of adding the book twice.",
+			Parameters:  []*Parameter{paramRef("IdempotencyKey")},
+			RequestBody: jsonBody(ref("Book")),
+			Responses: map[string]Response{
+				"201": withHeaders(jsonResponse("The stored book", bookEnvelope("Book added successfully")), replayed),
+				"400": refResponse("ValidationFailed"),
+				"409": problemResponse("The ID is taken, or the first request with this Idempotency-Key is still running", ref("Problem")),
+				"413": refResponse("413"),
+				"422": problemResponse("The Idempotency-Key was already used with a different body", ref("Problem")),
+			},
+		}},
+		{"POST", "/books/bulk", &Operation{
+			OperationID: "bulkAddBooks", Summary: "Add many books at once", Tags: []string{"books"},
+			Description: "Takes a JSON array or one book per line. Without atomic each valid book is stored on its own; " +
+				"with atomic=true either every book is stored or none is.",
+			Parameters: []*Parameter{query("atomic", "Store all the books or none", &Schema{Type: "boolean"})},
+			RequestBody: &RequestBody{Required: true, Content: map[string]MediaType{
+				"application/json":     {Schema: arrayOf(ref("Book"))},
+				"application/x-ndjson": {Schema: ref("Book")},
+			}},
+			Responses: map[string]Response{
+				"200": jsonResponse("Per-book results of a non-atomic import", bulkEnvelope()),
+				"201": jsonResponse("Every book of an atomic import was stored", bulkEnvelope()),
+				"400": refResponse("400"), "409": refResponse("409"),
+				"413": problemResponse("Too many books in one import, or a body over 32 MiB", ref("Problem")),
+				"415": problemResponse("Neither JSON nor NDJSON", ref("Problem")),
+				"422": problemResponse("An atomic import had invalid books, so none were stored", withProblem(bulkEnvelope())),
+			},
+		}},
+		{"POST", "/books/:id/restore", &Operation{
+			OperationID: "restoreBook", Summary: "Take a book out of the trash", Tags: []string{"trash"},
+			Parameters: []*Parameter{paramRef("BookID")},
+			Responses: map[string]Response{
+				"200": withHeaders(jsonResponse("The restored book", bookEnvelope("Book restored successfully")), etag),
+				"400": refResponse("400"), "404": refResponse("404"),
+			},
+		}},
+		{"POST", "/books/:id/revert", &Operation{
+			OperationID: "revertBook", Summary: "Roll a book back to a revision", Tags: []string{"history"},
+			Parameters:  []*Parameter{paramRef("BookID")},
+			RequestBody: jsonBody(object(map[string]*Schema{"revision": {Type: "integer", Minimum: float(1)}}, "revision")),
+			Responses: map[string]Response{
+				"200": withHeaders(jsonResponse("The book as the revision left it", bookEnvelope("Book reverted successfully")), etag),
+				"400": refResponse("400"), "404": refResponse("404"), "413": refResponse("413"),
+			},
+		}},
+		{"PUT", "/books", &Operation{
+			OperationID: "updateBook", Summary: "Replace a book", Tags: []string{"books"},
+			Description: "The body names the book by id. A version in the body or in If-Match makes the update conditional.",
+			Parameters:  []*Parameter{paramRef("IfMatch")},
+			RequestBody: jsonBody(ref("Book")),
+			Responses: map[string]Response{
+				"200": withHeaders(jsonResponse("The updated book", bookEnvelope("Book updated successfully")), etag),
+				"400": refResponse("ValidationFailed"), "404": refResponse("404"), "409": refResponse("409"), "412": refResponse("412"),
+				"413": refResponse("413"),
+			},
+		}},
+		{"PATCH", "/books/:id", &Operation{
+			OperationID: "patchBook", Summary: "Change part of a book", Tags: []string{"books"},
+			Description: "Takes a JSON Merge Patch (RFC 7386) or a JSON Patch (RFC 6902).",
+			Parameters:  []*Parameter{paramRef("BookID"), paramRef("IfMatch")},
+			RequestBody: &RequestBody{Required: true, Content: map[string]MediaType{
+				"application/merge-patch+json": {Schema: &Schema{Type: "object"}},
+				"application/json-patch+json": {Schema: arrayOf(object(map[string]*Schema{
+					"op":    enum("add", "remove", "replace", "move", "copy", "test"),
+					"path":  {Type: "string"},
+					"from":  {Type: "string"},
+					"value": {Description: "Any JSON value"},
+				}, "op", "path"))},
+			}},
+			Responses: map[string]Response{
+				"200": withHeaders(jsonResponse("The patched book", bookEnvelope("Book patched successfully")), etag),
+				"400": refResponse("ValidationFailed"), "404": refResponse("404"), "409": refResponse("409"), "412": refResponse("412"),
+				"413": refResponse("413"),
+				"415": problemResponse("Not a patch format this API understands", ref("Problem")),
+			},
+		}},
+		{"DELETE", "/books", &Operation{
+			OperationID: "deleteBook", Summary: "Move a book to the trash", Tags: []string{"books", "trash"},
+			Parameters: []*Parameter{paramRef("IfMatch")},
+			RequestBody: jsonBody(object(map[string]*Schema{
+				"id":      {Type: "integer"},
+				"version": {Type: "integer", Description: "Only delete if the book is still at this version"},
+			}, "id")),
+			Responses: map[string]Response{
+				"200": jsonResponse("The book is in the trash", envelope("Book moved to trash", nil)),
+				"400": refResponse("400"), "404": refResponse("404"), "409": refResponse("409"), "412": refResponse("412"),
+				"413": refResponse("413"),
+			},
+		}},
+		{"GET", "/metrics", &Operation{
+			OperationID: "metrics", Summary: "Prometheus metrics", Tags: []string{"operations"},
+			Responses: map[string]Response{"200": {Description: "Metrics in the text exposition format", Content: map[string]MediaType{
+				"text/plain; version=0.0.4; charset=utf-8": {Schema: &Schema{Type: "string"}},
+			}}},
+		}},
+		{"GET", "/openapi.json", &Operation{
+			OperationID: "openAPI", Summary: "This document", Tags: []string{"operations"},
+			Responses: map[string]Response{"200": jsonResponse("The OpenAPI document", &Schema{Type: "object"})},
+		}},
+		{"GET", "/docs", &Operation{
+			OperationID: "docs", Summary: "A page that renders this document", Tags: []string{"operations"},
+			Responses: map[string]Response{"200": {Description: "HTML", Content: map[string]MediaType{"text/html; charset=utf-8": {Schema: &Schema{Type: "string"}}}}},
+		}},
+	}
+
+	// net/http/pprof, as gin-contrib/pprof registers it
+	profile := func(method, name, summary string) route {
+		return route{method, "/debug/pprof/" + name, &Operation{
+			OperationID: "pprof-" + strings.ToLower(method) + "-" + cmp.Or(name, "index"),
+			Summary:     summary, Tags: []string{"operations"},
+			Responses: map[string]Response{"200": {Description: "See https://pkg.go.dev/net/http/pprof"}},
+		}}
+	}
+	routes = append(routes,
+		profile("GET", "", "Index of the available profiles"),
+		profile("GET", "cmdline", "Command line of the running server"),
+		profile("GET", "profile", "CPU profile"),
+		profile("GET", "symbol", "Look up program counters"),
+		profile("POST", "symbol", "Look up program counters"),
+		profile("GET", "trace", "Execution trace"),
+	)
+	for _, name := range []string{"allocs", "block", "goroutine", "heap", "mutex", "threadcreate"} {
+		routes = append(routes, profile("GET", name, name+" profile"))
+	}
+	return routes
+}
+
+func parameters() map[string]*Parameter {
+	return map[string]*Parameter{
+		"BookID": {Name: "id", In: "path", Required: true, Schema: &Schema{Type: "integer"}},
+		"IfMatch": {
+			Name: "If-Match", In: "header", Schema: &Schema{Type: "string"},
+			Description: `Only write if the book is still at this version: an ETag from a read, a quoted version such as "3", or *`,
+		},
+		"IdempotencyKey": {
+			Name: "Idempotency-Key", In: "header", Schema: &Schema{Type: "string"},
+			Description: "Up to 255 visible ASCII characters, such as a UUID, that make the request safe to retry for 24 hours by default",
+		},
+		"IfNoneMatch": {Name: "If-None-Match", In: "header", Schema: &Schema{Type: "string"}, Description: "Answer 304 if the book still has one of these ETags"},
+	}
+}
+
+func responses() map[string]Response {
+	errorResponse := func(description string) Response { return problemResponse(description, ref("Problem")) }
+
+	forbidden := withProblem(object(map[string]*Schema{
+		"permission": {Type: "string", Description: "The permission the route needs, when a rule names one"},
+		"roles":      arrayOf(&Schema{Type: "string"}),
+	}))
+	retryAfter := map[string]Header{
+		"Retry-After":         {Description: "Seconds until a request will be let through", Schema: &Schema{Type: "integer"}},
+		"RateLimit-Limit":     {Schema: &Schema{Type: "integer"}},
+		"RateLimit-Remaining": {Schema: &Schema{Type: "integer"}},
+		"RateLimit-Reset":     {Schema: &Schema{Type: "integer"}},
+	}
+
+	return map[string]Response{
+		"400":              errorResponse("The request was malformed"),
+		"ValidationFailed": problemResponse("The body was not a book, or the book broke one or more rules", &Schema{OneOf: []*Schema{ref("InvalidBookProblem"), ref("Problem")}}),
+		"401": withHeaders(errorResponse("No valid API key or token was sent"), map[string]Header{
+			"WWW-Authenticate": {Description: "The schemes the server accepts", Schema: &Schema{Type: "string"}},
+		}),
+		"403": problemResponse("The caller's roles do not grant the route's permission", forbidden),
+		"404": errorResponse("No such book"),
+		"409": errorResponse("The ID is taken, or the book changed since the given version"),
+		"412": errorResponse("The book no longer matches If-Match"),
+		"413": errorResponse("The request body is over 64 KiB"),
+		"429": withHeaders(errorResponse("The caller is over its rate limit"), retryAfter),
+		"500": errorResponse("Something went wrong on the server"),
+	}
+}
+
+// structSchema describes a struct by its json tags. Rules from a validate
+// tag become the matching schema keywords.
+func structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+
+		property := typeSchema(field.Type)
+		for part := range strings.SplitSeq(field.Tag.Get("validate"), ",") {
+			rule, param, _ := strings.Cut(part, "=")
+			switch rule {
+			case "required":
+				schema.Required = append(schema.Required, name)
+			case "min", "max":
+				value, _ := strconv.ParseFloat(param, 64)
+				switch {
+				case property.Type == "string" && rule == "max":
+					maxLength := int(value)
+					property.MaxLength = &maxLength
+				case rule == "min":
+					property.Minimum = &value
+				default:
+					property.Maximum = &value
+				}
+			case "genre":
+				property.Enum = models.AllowedGenres
+			}
+		}
+		if t == reflect.TypeFor[models.Books]() {
+			property.Description = bookFieldNotes[name]
+			property.ReadOnly = readOnlyFields[name]
+		}
+		schema.Properties[name] = property
+	}
+	return schema
+}
+
+func typeSchema(t reflect.Type) *Schema {
+	if name, named := schemaNames[t]; named {
+		return ref(name)
+	}
+	switch {
+	case t == reflect.TypeFor[time.Time]():
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Pointer:
+		schema := typeSchema(t.Elem())
+		if schema.Ref != "" {
+			// $ref siblings are ignored, so nullable needs a wrapper
+			return &Schema{OneOf: []*Schema{schema}, Nullable: true}
+		}
+		schema.Nullable = true
+		return schema
+	case t.Kind() == reflect.Slice:
+		return arrayOf(typeSchema(t.Elem()))
+	case t.Kind() == reflect.Struct:
+		return structSchema(t)
+	case t.Kind() == reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	default:
+		return &Schema{Type: "string"}
+	}
+}
+
+// envelope is the object every successful JSON response is wrapped in
+func envelope(message string, properties map[string]*Schema) *Schema {
+	schema := object(map[string]*Schema{"message": {Type: "string", Example: message}}, "message")
+	for name, property := range properties {
+		schema.Properties[name] = property
+		schema.Required = append(schema.Required, name)
+	}
+	return schema
+}
+
+// problemSchema is an RFC 7807 problem as the problem package writes it
+func problemSchema() *Schema {
+	types := make([]string, len(problem.Kinds))
+	for i, kind := range problem.Kinds {
+		types[i] = kind.Type
+	}
+	return object(map[string]*Schema{
+		"type":       {Type: "string", Format: "uri-reference", Enum: types},
+		"title":      {Type: "string", Description: "The same for every problem of a type"},
+		"status":     {Type: "integer"},
+		"detail":     {Type: "string", Description: "What went wrong this time"},
+		"instance":   {Type: "string", Format: "uri-reference", Description: "The request path"},
+		"request_id": {Type: "string", Description: "The ID the server logged the request under"},
+	}, "type", "title", "status", "detail", "instance")
+}
+
+// withProblem adds the members of a problem to extension members
+func withProblem(extensions *Schema) *Schema {
+	return &Schema{AllOf: []*Schema{ref("Problem"), extensions}}
+}
+
+func bulkEnvelope() *Schema {
+	return object(map[string]*Schema{
+		"message": {Type: "string"},
+		"created": {Type: "integer"},
+		"failed":  {Type: "integer"},
+		"results": arrayOf(ref("BulkResult")),
+	}, "created", "failed", "results")
+}
+
+func object(properties map[string]*Schema, required ...string) *Schema {
+	return &Schema{Type: "object", Properties: properties, Required: required}
+}
+
+func arrayOf(items *Schema) *Schema {
+	return &Schema{Type: "array", Items: items}
+}
+
+func ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func enum(values ...string) *Schema {
+	return &Schema{Type: "string", Enum: values}
+}
+
+func integer(minimum float64, maximum *float64) *Schema {
+	return &Schema{Type: "integer", Minimum: &minimum, Maximum: maximum}
+}
+
+func number(minimum, maximum float64) *Schema {
+	return &Schema{Type: "number", Minimum: &minimum, Maximum: &maximum}
+}
+
+func float(value float64) *float64 {
+	return &value
+}
+
+func query(name, description string, schema *Schema) *Parameter {
+	return &Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+func paramRef(name string) *Parameter {
+	return &Parameter{Ref: "#/components/parameters/" + name}
+}
+
+func jsonBody(schema *Schema) *RequestBody {
+	return &RequestBody{Required: true, Content: map[string]MediaType{"application/json": {Schema: schema}}}
+}
+
+func jsonResponse(description string, schema *Schema) Response {
+	return Response{Description: description, Content: map[string]MediaType{"application/json": {Schema: schema}}}
+}
+
+func problemResponse(description string, schema *Schema) Response {
+	return Response{Description: description, Content: map[string]MediaType{problem.ContentType: {Schema: schema}}}
+}
+
+func refResponse(name string) Response {
+	return Response{Ref: "#/components/responses/" + name}
+}
+
+func withHeaders(response Response, headers map[string]Header) Response {
+	response.Headers = headers
+	return response
+}
@@ -0,0 +1,47 @@
+package docs
+
+import (
+	"slices"
+	"testing"
+
+	"golang-training/day_7_8/models"
+)
+
+func TestBookSchemaFollowsValidation(t *testing.T) {
+	book := Spec().Components.Schemas["Book"]
+
+	for _, field := range []string{"title", "author", "genre", "rating"} {
+		if !slices.Contains(book.Required, field) {
+			t.Errorf("expected %s to be required, got %v", field, book.Required)
+		}
+	}
+	if slices.Contains(book.Required, "id") {
+		t.Errorf("expected id to be optional")
+	}
+	if title := book.Properties["title"]; title.MaxLength == nil || *title.MaxLength != 200 {
+		t.Errorf("expected title to be at most 200 long, got %+v", title)
+	}
+	if rating := book.Properties["rating"]; rating.Minimum == nil || *rating.Minimum != 0 || rating.Maximum == nil || *rating.Maximum != 5 {
+		t.Errorf("expected rating to be between 0 and 5, got %+v", rating)
+	}
+	if genre := book.Properties["genre"]; !slices.Equal(genre.Enum, models.AllowedGenres) {
+		t.Errorf("expected genre to list the allowed genres, got %v", genre.Enum)
+	}
+	if created := book.Properties["created_at"]; created.Format != "date-time" || !created.ReadOnly {
+		t.Errorf("expected created_at to be a read-only date-time, got %+v", created)
+	}
+}
+
+func TestPathFromRoute(t *testing.T) {
+	testCases := map[string]string{
+		"/books":             "/books",
+		"/books/:id/history": "/books/{id}/history",
+		"/files/*filepath":   "/files/{filepath}",
+		"/debug/pprof/":      "/debug/pprof/",
+	}
+	for route, expected := range testCases {
+		if path := PathFromRoute(route); path != expected {
+			t.Errorf("PathFromRoute(%q): expected %q, got %q", route, expected, path)
+		}
+	}
+}
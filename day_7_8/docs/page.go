@@ -0,0 +1,9 @@
+package docs
+
+import _ "embed"
+
+// Page renders the document served at /openapi.json, next to it, without
+// loading anything from elsewhere.
+//
+//go:embed docs.html
+var Page []byte
@@ -1,39 +1,259 @@
 package day7
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang-training/day_7_8/config"
 	"golang-training/day_7_8/controllers"
 	"golang-training/day_7_8/middlewares"
 	"golang-training/day_7_8/models"
+	"golang-training/day_7_8/problem"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-contrib/pprof"
 )
 
+// Day7 serves the books API, configured by its flags, environment and
+// config file, until it gets SIGINT or SIGTERM.
 func Day7() {
-	gin.SetMode(gin.ReleaseMode)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	server, err := NewServer(cfg)
+	if err != nil {
+		log.Fatalf("failed to start: %v", err)
+	}
+	if err := server.Run(ctx); err != nil {
+		log.Fatalf("server stopped uncleanly: %v", err)
+	}
+}
+
+// NewServer wires the books API up as cfg asks. Run or Serve it; either
+// closes the store on the way out.
+func NewServer(cfg config.Config) (*Server, error) {
+	gin.SetMode(cfg.Mode)
+
+	// everything, including the log package, logs JSON to stdout
+	level, err := cfg.LogLevel()
+	if err != nil {
+		return nil, err
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+	slog.SetDefault(logger)
 
 	router := gin.New()
+	// without trusted proxies ClientIP is the connecting address, so a
+	// client cannot pick its own rate limit bucket with X-Forwarded-For
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("setting trusted proxies: %w", err)
+	}
+	router.NoRoute(func(ctx *gin.Context) {
+		problem.Abort(ctx, problem.NotFound, "No route matches "+ctx.Request.URL.Path)
+	})
+
+	// the logger goes first to see what recovery and the filters turn away
+	if cfg.Enabled(config.MiddlewareLogger) {
+		router.Use(middlewares.Logger(middlewares.LoggerConfig{Logger: logger, SampleSuccess: cfg.Log.SampleSuccess}))
+	}
+	metrics := middlewares.NewMetrics()
+	if cfg.Enabled(config.MiddlewareMetrics) {
+		router.Use(metrics.Middleware())
+	}
+	if cfg.Enabled(config.MiddlewareRecovery) {
+		router.Use(middlewares.Recovery())
+	}
+	if cfg.Enabled(config.MiddlewareRequestFilter) {
+		router.Use(middlewares.RequestFilter())
+	}
+	var limiter *middlewares.RateLimiter
+	if cfg.Enabled(config.MiddlewareRateLimit) {
+		clients := map[string]middlewares.Quota{}
+		for subject, quota := range cfg.RateLimit.Clients {
+			clients[subject] = middlewares.Quota(quota)
+		}
+		limiter, err = middlewares.NewRateLimiter(middlewares.RateLimitConfig{
+			IP:          middlewares.Quota(cfg.RateLimit.IP),
+			Key:         middlewares.Quota(cfg.RateLimit.Key),
+			Clients:     clients,
+			IdleTimeout: cfg.RateLimit.IdleTimeout.Duration,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("setting up rate limiting: %w", err)
+		}
+	}
+	if cfg.Enabled(config.MiddlewareAuth) {
+		auth, err := middlewares.Auth(middlewares.AuthConfig{
+			APIKeysFile:      cfg.Auth.APIKeysFile,
+			HMACKeyFile:      cfg.Auth.HMACKeyFile,
+			RSAPublicKeyFile: cfg.Auth.RSAPublicKeyFile,
+			Issuer:           cfg.Auth.Issuer,
+			Audience:         cfg.Auth.Audience,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("setting up authentication: %w", err)
+		}
+		// a 401 never reaches Limit, so failed attempts are counted here
+		if limiter != nil {
+			router.Use(limiter.AuthFailures)
+		}
+		router.Use(auth)
+	} else {
+		log.Printf("authentication is disabled, anyone can change the catalogue")
+	}
+	// counts callers by identity once they are authenticated, so it runs after auth
+	if limiter != nil {
+		router.Use(limiter.Limit)
+	}
+	if cfg.Enabled(config.MiddlewareActor) {
+		router.Use(middlewares.Actor())
+	}
+	var policy *middlewares.Policy
+	if cfg.Enabled(config.MiddlewareAuthorize) {
+		if policy, err = middlewares.LoadPolicy(cfg.PolicyFile); err != nil {
+			return nil, err
+		}
+		router.Use(middlewares.Authorize(policy))
+	}
+	// only on POST /books, where a retried request would add the book twice
+	idempotency, err := middlewares.Idempotency(middlewares.IdempotencyConfig{
+		TTL:        cfg.Idempotency.TTL.Duration,
+		MaxEntries: cfg.Idempotency.MaxEntries,
+		MaxBytes:   int64(cfg.Idempotency.MaxBytes),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("setting up idempotency keys: %w", err)
+	}
+
+	// a bad seed file is reported row by row before anything is opened
+	var seed []models.Books
+	if cfg.SeedPath != "" {
+		if seed, err = models.LoadSeedFile(cfg.SeedPath); err != nil {
+			return nil, err
+		}
+	}
+
+	newStore, err := models.NewRepository(cfg.Store.Backend, cfg.Store.Path)
+	if err != nil {
+		return nil, fmt.Errorf("opening book store: %w", err)
+	}
+	server := &Server{
+		Addr:              cfg.Listen,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.Timeouts.ReadHeader.Duration,
+		ReadTimeout:       cfg.Timeouts.Read.Duration,
+		WriteTimeout:      cfg.Timeouts.Write.Duration,
+		IdleTimeout:       cfg.Timeouts.Idle.Duration,
+		ShutdownTimeout:   cfg.Timeouts.Shutdown.Duration,
+	}
+	if closer, ok := newStore.(io.Closer); ok {
+		server.Closers = append(server.Closers, closer)
+	}
+
+	// seeded before the feed is wired up, so clients never hear about the seed
+	seeded, err := models.SeedStore(context.Background(), newStore, seed)
+	if err != nil {
+		return nil, errors.Join(err, server.close())
+	}
+	if seeded > 0 {
+		slog.Info("seeded book store", "path", cfg.SeedPath, "books", seeded)
+	}
 
-	router.Use(gin.Recovery())
-	router.Use(middlewares.RequestFilter())
-	router.Use(middlewares.Logger())
+	// keeps the last 1000 changes for clients resuming the event stream
+	feed := models.NewChangeFeed(1000)
+	newStore.OnChange(feed.Publish)
 
-	newStore := models.NewBookStore()
+	retention := cfg.Store.TrashRetention.Duration
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	purged := make(chan struct{})
+	go func() {
+		defer close(purged)
+		models.PurgeTrash(purgeCtx, newStore, retention, min(retention, time.Hour))
+	}()
+
+	// streams end when shutdown starts; the purge finishes before the store
+	// is closed on every way out, including a failed listener
+	server.OnShutdown = append(server.OnShutdown, feed.Close)
+	server.BeforeClose = append(server.BeforeClose, func() {
+		stopPurge()
+		<-purged
+	})
 
 	router.GET("/books", func(ctx *gin.Context) {
 		controllers.GetBooksController(ctx, newStore)
 	})
-	router.POST("/books", func(ctx *gin.Context) {
+	router.GET("/books/export", func(ctx *gin.Context) {
+		controllers.ExportBooksController(ctx, newStore)
+	})
+	router.GET("/books/events", func(ctx *gin.Context) {
+		controllers.BookEventsController(ctx, feed)
+	})
+	router.GET("/books/ws", func(ctx *gin.Context) {
+		controllers.BookSocketController(ctx, feed)
+	})
+	router.GET("/books/trash", func(ctx *gin.Context) {
+		controllers.GetTrashController(ctx, newStore)
+	})
+	router.GET("/books/search", func(ctx *gin.Context) {
+		controllers.SearchBooksController(ctx, newStore)
+	})
+	router.GET("/books/:id", func(ctx *gin.Context) {
+		controllers.GetBookController(ctx, newStore)
+	})
+	router.GET("/books/:id/history", func(ctx *gin.Context) {
+		controllers.GetBookHistoryController(ctx, newStore)
+	})
+	router.POST("/books", idempotency, func(ctx *gin.Context) {
 		controllers.AddBookController(ctx, newStore)
 	})
+	router.POST("/books/bulk", func(ctx *gin.Context) {
+		controllers.BulkAddBooksController(ctx, newStore)
+	})
+	router.POST("/books/:id/restore", func(ctx *gin.Context) {
+		controllers.RestoreBookController(ctx, newStore)
+	})
+	router.POST("/books/:id/revert", func(ctx *gin.Context) {
+		controllers.RevertBookController(ctx, newStore)
+	})
 	router.PUT("/books", func(ctx *gin.Context) {
 		controllers.UpdateBookController(ctx, newStore)
 	})
+	router.PATCH("/books/:id", func(ctx *gin.Context) {
+		controllers.PatchBookController(ctx, newStore)
+	})
 	router.DELETE("/books", func(ctx *gin.Context) {
 		controllers.DeleteBookController(ctx, newStore)
 	})
+	router.DELETE("/books/trash", func(ctx *gin.Context) {
+		controllers.PurgeTrashController(ctx, newStore)
+	})
+
+	router.GET("/metrics", func(ctx *gin.Context) {
+		controllers.MetricsController(ctx, metrics, newStore)
+	})
+	router.GET("/openapi.json", controllers.OpenAPIController)
+	router.GET("/docs", controllers.DocsController)
 
 	pprof.Register(router)
 
-	router.Run("localhost:8080")
+	if policy != nil {
+		if err := policy.CheckRoutes(router.Routes()); err != nil {
+			server.close()
+			return nil, fmt.Errorf("policy does not cover every route: %w", err)
+		}
+	}
+
+	return server, nil
 }
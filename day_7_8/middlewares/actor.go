@@ -0,0 +1,23 @@
+package middlewares
+
+import (
+	"golang-training/day_7_8/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Actor tags the request context with the caller named in X-Actor, which the
+// stores write into the change history. An authenticated caller cannot
+// claim to be someone else.
+func Actor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, authenticated := CurrentIdentity(c); authenticated {
+			c.Next()
+			return
+		}
+		if actor := c.GetHeader("X-Actor"); actor != "" {
+			c.Request = c.Request.WithContext(models.WithActor(c.Request.Context(), actor))
+		}
+		c.Next()
+	}
+}
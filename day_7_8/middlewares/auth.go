@@ -0,0 +1,225 @@
+package middlewares
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rsa"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang-training/day_7_8/models"
+	"golang-training/day_7_8/problem"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// IdentityKey is where Auth leaves the caller's Identity on the gin.Context.
+const IdentityKey = "identity"
+
+const authRealm = "books"
+
+// AuthConfig points at the files holding the credentials Auth accepts. Any
+// of them may be left empty, but at least one must be set.
+type AuthConfig struct {
+	// APIKeysFile has one key per line, followed by the caller's name and an
+	// optional comma separated list of roles, e.g. "s3cr3t alice editor".
+	// Blank lines and lines starting with # are skipped.
+	APIKeysFile string
+	// HMACKeyFile holds the shared secret for HS256 tokens
+	HMACKeyFile string
+	// RSAPublicKeyFile holds the PEM public key for RS256 tokens
+	RSAPublicKeyFile string
+	// Issuer and Audience, when set, must match the token's iss and aud
+	Issuer   string
+	Audience string
+}
+
+// Identity is the authenticated caller.
+type Identity struct {
+	Subject string
+	// Method is "api_key" or "jwt"
+	Method string
+	Roles  []string
+}
+
+// CurrentIdentity returns the caller Auth let through.
+func CurrentIdentity(c *gin.Context) (Identity, bool) {
+	value, exists := c.Get(IdentityKey)
+	identity, ok := value.(Identity)
+	return identity, exists && ok
+}
+
+type apiKey struct {
+	key      []byte
+	identity Identity
+}
+
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles,omitempty"`
+}
+
+// Auth loads the configured keys and returns a middleware that lets a
+// request through only with a valid X-API-Key or "Authorization: Bearer"
+// token. The caller becomes the request's Identity and its history actor.
+func Auth(config AuthConfig) (gin.HandlerFunc, error) {
+	var (
+		keys      []apiKey
+		hmacKey   []byte
+		rsaKey    *rsa.PublicKey
+		methods   []string
+		challenge []string
+	)
+
+	if config.APIKeysFile != "" {
+		var err error
+		if keys, err = loadAPIKeys(config.APIKeysFile); err != nil {
+			return nil, err
+		}
+		challenge = append(challenge, fmt.Sprintf("ApiKey realm=%q", authRealm))
+	}
+	if config.HMACKeyFile != "" {
+		data, err := os.ReadFile(config.HMACKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading HMAC key: %w", err)
+		}
+		if hmacKey = bytes.TrimSpace(data); len(hmacKey) < 32 {
+			return nil, fmt.Errorf("HMAC key in %s must be at least 32 bytes", config.HMACKeyFile)
+		}
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if config.RSAPublicKeyFile != "" {
+		data, err := os.ReadFile(config.RSAPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading RSA public key: %w", err)
+		}
+		if rsaKey, err = jwt.ParseRSAPublicKeyFromPEM(data); err != nil {
+			return nil, fmt.Errorf("parsing RSA public key in %s: %w", config.RSAPublicKeyFile, err)
+		}
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	if len(keys) == 0 && len(methods) == 0 {
+		return nil, errors.New("auth needs API keys or a JWT key")
+	}
+
+	options := []jwt.ParserOption{jwt.WithValidMethods(methods)}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+	parser := jwt.NewParser(options...)
+	keyFunc := func(token *jwt.Token) (any, error) {
+		// WithValidMethods has already rejected any other algorithm
+		if token.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+			return hmacKey, nil
+		}
+		return rsaKey, nil
+	}
+	if len(methods) > 0 {
+		challenge = append(challenge, fmt.Sprintf("Bearer realm=%q", authRealm))
+	}
+
+	unauthorized := func(c *gin.Context, message, tokenError string) {
+		for _, scheme := range challenge {
+			if tokenError != "" && strings.HasPrefix(scheme, "Bearer") {
+				scheme += fmt.Sprintf(", error=%q, error_description=%q", tokenError, message)
+			}
+			c.Writer.Header().Add("WWW-Authenticate", scheme)
+		}
+		problem.Abort(c, problem.Unauthorized, message)
+	}
+
+	return func(c *gin.Context) {
+		var identity Identity
+
+		if key := c.GetHeader("X-API-Key"); key != "" {
+			match, found := matchAPIKey(keys, key)
+			if !found {
+				unauthorized(c, "Invalid API key", "")
+				return
+			}
+			identity = match
+		} else if header := c.GetHeader("Authorization"); header != "" {
+			scheme, raw, _ := strings.Cut(header, " ")
+			if !strings.EqualFold(scheme, "Bearer") || len(methods) == 0 {
+				unauthorized(c, "Unsupported authorization scheme", "invalid_request")
+				return
+			}
+			claims := &tokenClaims{}
+			if _, err := parser.ParseWithClaims(strings.TrimSpace(raw), claims, keyFunc); err != nil {
+				// why it failed is only logged, it would help someone forging tokens
+				c.Error(err)
+				unauthorized(c, "Invalid or expired token", "invalid_token")
+				return
+			}
+			if claims.Subject == "" {
+				unauthorized(c, "Token has no subject", "invalid_token")
+				return
+			}
+			identity = Identity{Subject: claims.Subject, Method: "jwt", Roles: claims.Roles}
+		} else {
+			unauthorized(c, "Authentication required", "")
+			return
+		}
+
+		c.Set(IdentityKey, identity)
+		c.Request = c.Request.WithContext(models.WithActor(c.Request.Context(), identity.Subject))
+		c.Next()
+	}, nil
+}
+
+// matchAPIKey compares against every key in constant time, so the response
+// time gives nothing away about how close a guess was.
+func matchAPIKey(keys []apiKey, key string) (Identity, bool) {
+	var (
+		match Identity
+		found bool
+	)
+	for _, candidate := range keys {
+		if subtle.ConstantTimeCompare(candidate.key, []byte(key)) == 1 {
+			match, found = candidate.identity, true
+		}
+	}
+	return match, found
+}
+
+func loadAPIKeys(path string) ([]apiKey, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading API keys: %w", err)
+	}
+	defer file.Close()
+
+	var keys []apiKey
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected a key, a name and optional roles", path, line)
+		}
+		identity := Identity{Subject: fields[1], Method: "api_key"}
+		if len(fields) == 3 {
+			identity.Roles = strings.Split(fields[2], ",")
+		}
+		keys = append(keys, apiKey{key: []byte(fields[0]), identity: identity})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading API keys: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no API keys in %s", path)
+	}
+
+	return keys, nil
+}
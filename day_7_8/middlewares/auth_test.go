@@ -0,0 +1,151 @@
+package middlewares_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang-training/day_7_8/middlewares"
+	"golang-training/day_7_8/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("writing %s failed: %v", name, err)
+	}
+	return path
+}
+
+func TestAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hmacKey := []byte("0123456789abcdef0123456789abcdef")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key failed: %v", err)
+	}
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	publicKey, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+
+	auth, err := middlewares.Auth(middlewares.AuthConfig{
+		APIKeysFile:      writeFile(t, "keys", []byte("# service accounts\nreader-key robot reader\nadmin-key root admin,editor\n")),
+		HMACKeyFile:      writeFile(t, "hmac", append(hmacKey, '\n')),
+		RSAPublicKeyFile: writeFile(t, "rsa.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})),
+		Issuer:           "books-test",
+	})
+	if err != nil {
+		t.Fatalf("Auth() failed: %v", err)
+	}
+
+	router := gin.New()
+	router.Use(auth, middlewares.Actor())
+	router.GET("/whoami", func(c *gin.Context) {
+		identity, _ := middlewares.CurrentIdentity(c)
+		c.JSON(http.StatusOK, gin.H{"subject": identity.Subject, "roles": identity.Roles, "actor": models.ActorFrom(c.Request.Context())})
+	})
+
+	sign := func(method jwt.SigningMethod, key any, claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatalf("signing token failed: %v", err)
+		}
+		return "Bearer " + token
+	}
+	valid := func(subject string) jwt.MapClaims {
+		return jwt.MapClaims{"sub": subject, "iss": "books-test", "exp": time.Now().Add(time.Hour).Unix(), "roles": []string{"editor"}}
+	}
+	expired := valid("late")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	noSubject := valid("")
+	delete(noSubject, "sub")
+	wrongIssuer := valid("stranger")
+	wrongIssuer["iss"] = "elsewhere"
+
+	tests := []struct {
+		name         string
+		headers      map[string]string
+		expectedCode int
+		expectedBody string
+		tokenError   string
+	}{
+		{"API Key", map[string]string{"X-API-Key": "admin-key"}, http.StatusOK, `"subject":"root"`, ""},
+		{"API Key Roles", map[string]string{"X-API-Key": "admin-key"}, http.StatusOK, `"roles":["admin","editor"]`, ""},
+		{"HS256 Token", map[string]string{"Authorization": sign(jwt.SigningMethodHS256, hmacKey, valid("alice"))}, http.StatusOK, `"actor":"alice"`, ""},
+		{"RS256 Token", map[string]string{"Authorization": sign(jwt.SigningMethodRS256, rsaKey, valid("bob"))}, http.StatusOK, `"roles":["editor"]`, ""},
+		{"Actor Header Ignored", map[string]string{"X-API-Key": "reader-key", "X-Actor": "root"}, http.StatusOK, `"actor":"robot"`, ""},
+		{"No Credentials", nil, http.StatusUnauthorized, "Authentication required", ""},
+		{"Unknown API Key", map[string]string{"X-API-Key": "guess"}, http.StatusUnauthorized, "Invalid API key", ""},
+		{"Basic Scheme", map[string]string{"Authorization": "Basic cm9vdDpyb290"}, http.StatusUnauthorized, "Unsupported authorization scheme", "invalid_request"},
+		{"Malformed Token", map[string]string{"Authorization": "Bearer not.a.token"}, http.StatusUnauthorized, `"detail":"Invalid or expired token"`, "invalid_token"},
+		{"Wrong RSA Key", map[string]string{"Authorization": sign(jwt.SigningMethodRS256, otherKey, valid("mallory"))}, http.StatusUnauthorized, `"detail":"Invalid or expired token"`, "invalid_token"},
+		{"Unlisted Algorithm", map[string]string{"Authorization": sign(jwt.SigningMethodHS512, hmacKey, valid("mallory"))}, http.StatusUnauthorized, `"detail":"Invalid or expired token"`, "invalid_token"},
+		{"Expired Token", map[string]string{"Authorization": sign(jwt.SigningMethodHS256, hmacKey, expired)}, http.StatusUnauthorized, `"detail":"Invalid or expired token"`, "invalid_token"},
+		{"Wrong Issuer", map[string]string{"Authorization": sign(jwt.SigningMethodHS256, hmacKey, wrongIssuer)}, http.StatusUnauthorized, `"detail":"Invalid or expired token"`, "invalid_token"},
+		{"No Subject", map[string]string{"Authorization": sign(jwt.SigningMethodHS256, hmacKey, noSubject)}, http.StatusUnauthorized, "Token has no subject", "invalid_token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/whoami", nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedCode || !strings.Contains(w.Body.String(), tt.expectedBody) {
+				t.Fatalf("expected %d with %q, got %d %s", tt.expectedCode, tt.expectedBody, w.Code, w.Body.String())
+			}
+			if tt.expectedCode != http.StatusUnauthorized {
+				return
+			}
+
+			challenges := strings.Join(w.Header().Values("WWW-Authenticate"), "; ")
+			if !strings.Contains(challenges, `Bearer realm="books"`) || !strings.Contains(challenges, `ApiKey realm="books"`) {
+				t.Errorf("expected both challenges, got %q", challenges)
+			}
+			if tt.tokenError != "" && !strings.Contains(challenges, `error="`+tt.tokenError+`"`) {
+				t.Errorf("expected error %q in the challenge, got %q", tt.tokenError, challenges)
+			}
+			// the parser's reason for rejecting a token stays on the server
+			if strings.Contains(tt.expectedBody, "Invalid or expired token") && !strings.Contains(challenges, `error_description="Invalid or expired token"`) {
+				t.Errorf("expected the fixed description in the challenge, got %q", challenges)
+			}
+		})
+	}
+}
+
+func TestAuthConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config middlewares.AuthConfig
+	}{
+		{"Nothing Configured", middlewares.AuthConfig{}},
+		{"Missing Keys File", middlewares.AuthConfig{APIKeysFile: filepath.Join(t.TempDir(), "missing")}},
+		{"Key Without Name", middlewares.AuthConfig{APIKeysFile: writeFile(t, "keys", []byte("lonely-key\n"))}},
+		{"Empty Keys File", middlewares.AuthConfig{APIKeysFile: writeFile(t, "keys", []byte("# nobody yet\n"))}},
+		{"Short HMAC Key", middlewares.AuthConfig{HMACKeyFile: writeFile(t, "hmac", []byte("secret"))}},
+		{"Not A PEM Key", middlewares.AuthConfig{RSAPublicKeyFile: writeFile(t, "rsa.pem", []byte("secret"))}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := middlewares.Auth(tt.config); err == nil {
+				t.Errorf("expected Auth() to reject the config")
+			}
+		})
+	}
+}
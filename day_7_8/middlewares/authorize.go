@@ -0,0 +1,177 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"golang-training/day_7_8/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RoleAnonymous is the role of a caller who presented no identity at all.
+const RoleAnonymous = "anonymous"
+
+// Policy says which permission each route needs and which roles grant it.
+// Routes are gin route templates such as "/books/:id"; one ending in "/*"
+// covers every route under it, and method "*" covers every method.
+type Policy struct {
+	// TrustedRolesHeader, when set, names a header carrying the caller's
+	// comma separated roles. Only use it behind a proxy that sets it.
+	TrustedRolesHeader string              `json:"trusted_roles_header,omitempty"`
+	Roles              map[string][]string `json:"roles"`
+	Routes             []PolicyRoute       `json:"routes"`
+}
+
+type PolicyRoute struct {
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Permission string `json:"permission"`
+}
+
+// LoadPolicy reads a JSON policy file and checks it is consistent.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading policy: %w", err)
+	}
+
+	var policy Policy
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("parsing policy %s: %w", path, err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("policy %s: %w", path, err)
+	}
+
+	return &policy, nil
+}
+
+func (p *Policy) validate() error {
+	var problems []error
+	seen := map[string]bool{}
+	for i, route := range p.Routes {
+		if route.Method == "" || route.Path == "" || route.Permission == "" {
+			problems = append(problems, fmt.Errorf("route %d needs a method, a path and a permission", i))
+			continue
+		}
+		key := strings.ToUpper(route.Method) + " " + route.Path
+		if seen[key] {
+			problems = append(problems, fmt.Errorf("%s is listed twice", key))
+		}
+		seen[key] = true
+		if len(p.rolesWith(route.Permission)) == 0 {
+			problems = append(problems, fmt.Errorf("no role grants %q, needed by %s", route.Permission, key))
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// CheckRoutes makes sure every route on the router is covered, so a new
+// route cannot slip out without a rule.
+func (p *Policy) CheckRoutes(routes gin.RoutesInfo) error {
+	var missing []error
+	for _, route := range routes {
+		if _, found := p.permission(route.Method, route.Path); !found {
+			missing = append(missing, fmt.Errorf("no policy for %s %s", route.Method, route.Path))
+		}
+	}
+	return errors.Join(missing...)
+}
+
+// permission finds the rule for a route, preferring an exact path over a
+// wildcard and an exact method over "*".
+func (p *Policy) permission(method, path string) (string, bool) {
+	best, bestScore := "", 0
+	for _, route := range p.Routes {
+		score := 0
+		switch {
+		case route.Path == path:
+			score = 2
+		case strings.HasSuffix(route.Path, "/*") && strings.HasPrefix(path, strings.TrimSuffix(route.Path, "*")):
+			score = 1
+		default:
+			continue
+		}
+		switch {
+		case strings.EqualFold(route.Method, method):
+			score = score*2 + 1
+		case route.Method == "*":
+			score = score * 2
+		default:
+			continue
+		}
+		if score > bestScore {
+			best, bestScore = route.Permission, score
+		}
+	}
+	return best, bestScore > 0
+}
+
+func (p *Policy) rolesWith(permission string) []string {
+	var roles []string
+	for role, permissions := range p.Roles {
+		if slices.Contains(permissions, permission) {
+			roles = append(roles, role)
+		}
+	}
+	slices.Sort(roles)
+	return roles
+}
+
+// callerRoles takes the roles from Auth's identity, then from the trusted
+// header, and falls back to anonymous.
+func (p *Policy) callerRoles(c *gin.Context) []string {
+	if identity, authenticated := CurrentIdentity(c); authenticated {
+		return identity.Roles
+	}
+	if p.TrustedRolesHeader != "" {
+		if header := c.GetHeader(p.TrustedRolesHeader); header != "" {
+			var roles []string
+			for _, role := range strings.Split(header, ",") {
+				if role = strings.TrimSpace(role); role != "" {
+					roles = append(roles, role)
+				}
+			}
+			return roles
+		}
+	}
+	return []string{RoleAnonymous}
+}
+
+// Authorize lets a request through only if one of the caller's roles grants
+// the permission the policy asks for on its route. It goes after Auth.
+func Authorize(policy *Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			// nothing matched, let the router answer 404
+			c.Next()
+			return
+		}
+
+		permission, found := policy.permission(c.Request.Method, route)
+		if !found {
+			problem.Abort(c, problem.Forbidden, "No policy allows this route")
+			return
+		}
+		for _, role := range policy.callerRoles(c) {
+			if slices.Contains(policy.Roles[role], permission) {
+				c.Next()
+				return
+			}
+		}
+
+		problem.AbortWith(c, problem.Forbidden, fmt.Sprintf("Missing permission %s", permission), map[string]any{
+			"permission": permission,
+			"roles":      policy.rolesWith(permission),
+		})
+	}
+}
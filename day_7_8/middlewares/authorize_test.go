@@ -0,0 +1,119 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang-training/day_7_8/middlewares"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAuthorize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	policy, err := middlewares.LoadPolicy(filepath.Join("..", "policy.json"))
+	if err != nil {
+		t.Fatalf("LoadPolicy() failed: %v", err)
+	}
+	policy.TrustedRolesHeader = "X-Roles"
+
+	auth, err := middlewares.Auth(middlewares.AuthConfig{
+		APIKeysFile: writeFile(t, "keys", []byte("editor-key ed editor\nnobody-key nobody\n")),
+	})
+	if err != nil {
+		t.Fatalf("Auth() failed: %v", err)
+	}
+
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	router := gin.New()
+	// only callers with a key go through Auth, the rest rely on X-Roles
+	router.Use(func(c *gin.Context) {
+		if c.GetHeader("X-API-Key") != "" {
+			auth(c)
+		}
+	}, middlewares.Authorize(policy))
+	router.GET("/books", ok)
+	router.GET("/books/:id/history", ok)
+	router.POST("/books/:id/revert", ok)
+	router.PATCH("/books/:id", ok)
+	router.DELETE("/books", ok)
+	router.DELETE("/books/trash", ok)
+	router.GET("/debug/pprof/heap", ok)
+	router.GET("/unlisted", ok)
+
+	if err := policy.CheckRoutes(router.Routes()); err == nil || !strings.Contains(err.Error(), "GET /unlisted") {
+		t.Errorf("expected CheckRoutes() to report GET /unlisted, got %v", err)
+	}
+
+	tests := []struct {
+		name               string
+		method, path       string
+		headers            map[string]string
+		expectedCode       int
+		expectedPermission string
+	}{
+		{"Reader Lists Books", "GET", "/books", map[string]string{"X-Roles": "reader"}, http.StatusNoContent, ""},
+		{"Reader Reads History", "GET", "/books/1/history", map[string]string{"X-Roles": "reader"}, http.StatusNoContent, ""},
+		{"Reader Cannot Patch", "PATCH", "/books/1", map[string]string{"X-Roles": "reader"}, http.StatusForbidden, "books:write"},
+		{"Editor Patches", "PATCH", "/books/1", map[string]string{"X-Roles": "editor"}, http.StatusNoContent, ""},
+		{"Editor Reverts", "POST", "/books/1/revert", map[string]string{"X-Roles": "editor"}, http.StatusNoContent, ""},
+		{"Editor Cannot Delete", "DELETE", "/books", map[string]string{"X-Roles": "editor"}, http.StatusForbidden, "books:delete"},
+		{"Editor Cannot Purge", "DELETE", "/books/trash", map[string]string{"X-Roles": "editor"}, http.StatusForbidden, "books:purge"},
+		{"Admin Deletes", "DELETE", "/books", map[string]string{"X-Roles": "admin"}, http.StatusNoContent, ""},
+		{"Admin Purges", "DELETE", "/books/trash", map[string]string{"X-Roles": "admin"}, http.StatusNoContent, ""},
+		{"Any Listed Role", "DELETE", "/books", map[string]string{"X-Roles": "reader, admin"}, http.StatusNoContent, ""},
+		{"Only Admin Profiles", "GET", "/debug/pprof/heap", map[string]string{"X-Roles": "editor"}, http.StatusForbidden, "debug:profile"},
+		{"Anonymous", "GET", "/books", nil, http.StatusForbidden, "books:read"},
+		{"Unknown Role", "GET", "/books", map[string]string{"X-Roles": "librarian"}, http.StatusForbidden, "books:read"},
+		{"Unlisted Route", "GET", "/unlisted", map[string]string{"X-Roles": "admin"}, http.StatusForbidden, ""},
+		{"Unknown Path Falls Through", "GET", "/nowhere", nil, http.StatusNotFound, ""},
+		{"API Key Roles", "PATCH", "/books/1", map[string]string{"X-API-Key": "editor-key"}, http.StatusNoContent, ""},
+		{"API Key Beats Header", "DELETE", "/books", map[string]string{"X-API-Key": "editor-key", "X-Roles": "admin"}, http.StatusForbidden, "books:delete"},
+		{"API Key Without Roles", "GET", "/books", map[string]string{"X-API-Key": "nobody-key"}, http.StatusForbidden, "books:read"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedCode {
+				t.Fatalf("expected %d, got %d %s", tt.expectedCode, w.Code, w.Body.String())
+			}
+			if tt.expectedPermission != "" && !strings.Contains(w.Body.String(), `"permission":"`+tt.expectedPermission+`"`) {
+				t.Errorf("expected the missing permission %q, got %s", tt.expectedPermission, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        string
+		expectedError string
+	}{
+		{"Not JSON", `roles: {}`, "parsing policy"},
+		{"Unknown Field", `{"rules": []}`, "unknown field"},
+		{"Incomplete Route", `{"roles": {"reader": ["books:read"]}, "routes": [{"method": "GET", "permission": "books:read"}]}`, "needs a method, a path and a permission"},
+		{"Duplicate Route", `{"roles": {"reader": ["books:read"]}, "routes": [{"method": "GET", "path": "/books", "permission": "books:read"}, {"method": "get", "path": "/books", "permission": "books:read"}]}`, "listed twice"},
+		{"Ungranted Permission", `{"roles": {"reader": ["books:read"]}, "routes": [{"method": "DELETE", "path": "/books", "permission": "books:delete"}]}`, `no role grants "books:delete"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := middlewares.LoadPolicy(writeFile(t, "policy.json", []byte(tt.policy)))
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("expected an error containing %q, got %v", tt.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package middlewares
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang-training/day_7_8/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyKeyHeader names the header a client sets to make a retry safe.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKey is the longest key accepted, long enough for any UUID
+// or ULID a client is likely to send.
+const maxIdempotencyKey = 255
+
+// IdempotencyConfig sets how long responses are kept; zero values fall
+// back to the defaults.
+type IdempotencyConfig struct {
+	// TTL is how long a response is replayed for, default 24 hours
+	TTL time.Duration
+	// MaxBody is the largest body a keyed request may have, default 1 MiB.
+	// The body is read up front to compare it with the first request's.
+	MaxBody int64
+	// MaxEntries and MaxBytes bound the stored responses, default 10000 and
+	// 64 MiB. Past either, the least recently used responses are dropped
+	// early; requests still in flight are never dropped.
+	MaxEntries int
+	MaxBytes   int64
+}
+
+// idempotentResponse is the outcome of the first request with a key. It is
+// in flight until done is closed; after that the fields are only read.
+type idempotentResponse struct {
+	fingerprint [sha256.Size]byte
+	done        chan struct{}
+	// stored is false if the first request failed and was not kept
+	stored  bool
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+	// size and element are the response's share of MaxBytes and its place
+	// in the cache's LRU list, once stored
+	size    int64
+	element *list.Element
+}
+
+func (r *idempotentResponse) expired(now time.Time) bool {
+	return !r.expires.IsZero() && !now.Before(r.expires)
+}
+
+// replay writes the stored response. Headers the earlier middlewares set
+// for this request, such as X-Request-ID, are kept.
+func (r *idempotentResponse) replay(c *gin.Context) {
+	header := c.Writer.Header()
+	for name, values := range r.header {
+		if _, set := header[name]; !set {
+			header[name] = values
+		}
+	}
+	header.Set("Idempotent-Replayed", "true")
+	c.Writer.WriteHeader(r.status)
+	c.Writer.WriteHeaderNow()
+	c.Writer.Write(r.body)
+	c.Abort()
+}
+
+// recordingWriter keeps a copy of the body on its way to the client
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+type idempotencyCache struct {
+	config    IdempotencyConfig
+	mu        sync.Mutex
+	responses map[string]*idempotentResponse
+	// lru holds the keys of the stored responses, most recently used first
+	lru       *list.List
+	bytes     int64
+	lastSweep time.Time
+}
+
+// Idempotency makes requests carrying an Idempotency-Key safe to retry. The
+// first response for a key is kept for the TTL and replayed to every retry,
+// marked with Idempotent-Replayed. Reusing a key with another body answers
+// 422, and a retry that arrives while the first request is still running
+// waits for its response. Server errors are not kept, so the next retry
+// runs again. Keys are per identity, so it goes after Auth; callers
+// without one share a single space of keys. Requests without the header
+// are passed through.
+func Idempotency(config IdempotencyConfig) (gin.HandlerFunc, error) {
+	if config.TTL == 0 {
+		config.TTL = 24 * time.Hour
+	}
+	if config.MaxBody == 0 {
+		config.MaxBody = 1 << 20
+	}
+	if config.MaxEntries == 0 {
+		config.MaxEntries = 10000
+	}
+	if config.MaxBytes == 0 {
+		config.MaxBytes = 64 << 20
+	}
+	if config.TTL < 0 {
+		return nil, fmt.Errorf("idempotency TTL must not be negative")
+	}
+	if config.MaxBody < 0 || config.MaxEntries < 0 || config.MaxBytes < 0 {
+		return nil, fmt.Errorf("idempotency limits must not be negative")
+	}
+
+	cache := &idempotencyCache{
+		config:    config,
+		responses: map[string]*idempotentResponse{},
+		lru:       list.New(),
+		lastSweep: time.Now(),
+	}
+	return cache.handle, nil
+}
+
+func (i *idempotencyCache) handle(c *gin.Context) {
+	key := c.GetHeader(IdempotencyKeyHeader)
+	if key == "" {
+		c.Next()
+		return
+	}
+	if !validIdempotencyKey(key) {
+		problem.Abort(c, problem.BadRequest, fmt.Sprintf("%s must be 1 to %d visible ASCII characters", IdempotencyKeyHeader, maxIdempotencyKey))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, i.config.MaxBody+1))
+	if err != nil {
+		problem.Abort(c, problem.BadRequest, "Request body could not be read")
+		return
+	}
+	if int64(len(body)) > i.config.MaxBody {
+		problem.Abort(c, problem.TooLarge, fmt.Sprintf("Request body is larger than %d bytes", i.config.MaxBody))
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	fingerprint := sha256.Sum256(body)
+
+	scope := "anonymous"
+	if identity, authenticated := CurrentIdentity(c); authenticated {
+		scope = "id:" + identity.Subject
+	}
+	cacheKey := strings.Join([]string{scope, c.Request.Method, c.FullPath(), key}, "\x00")
+
+	for {
+		response, first := i.claim(cacheKey, fingerprint)
+		if response.fingerprint != fingerprint {
+			problem.Abort(c, problem.IdempotencyKeyReused, fmt.Sprintf("%s %q was already used with a different request body", IdempotencyKeyHeader, key))
+			return
+		}
+		if first {
+			i.record(c, cacheKey, response)
+			return
+		}
+
+		select {
+		case <-response.done:
+		case <-c.Request.Context().Done():
+			problem.Abort(c, problem.IdempotencyInFlight, fmt.Sprintf("A request with %s %q is still being processed", IdempotencyKeyHeader, key))
+			return
+		}
+		if response.stored {
+			response.replay(c)
+			return
+		}
+		// the first request failed and gave up the key, so try to take it
+	}
+}
+
+// claim returns the response for a key, and whether this request is the
+// first with it and so has to produce the response.
+func (i *idempotencyCache) claim(key string, fingerprint [sha256.Size]byte) (*idempotentResponse, bool) {
+	now := time.Now()
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.sweep(now)
+	if response, found := i.responses[key]; found && !response.expired(now) {
+		if response.element != nil {
+			i.lru.MoveToFront(response.element)
+		}
+		return response, false
+	}
+	if response, found := i.responses[key]; found {
+		i.drop(key, response)
+	}
+	response := &idempotentResponse{fingerprint: fingerprint, done: make(chan struct{})}
+	i.responses[key] = response
+	return response, true
+}
+
+// record runs the rest of the handlers and keeps what they answered. A
+// server error or a panic gives the key up instead.
+func (i *idempotencyCache) record(c *gin.Context, key string, response *idempotentResponse) {
+	writer := &recordingWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+
+	completed := false
+	defer func() {
+		i.mu.Lock()
+		if completed && writer.Status() < http.StatusInternalServerError {
+			response.stored = true
+			response.status = writer.Status()
+			response.header = writer.Header().Clone()
+			response.body = writer.body.Bytes()
+			response.expires = time.Now().Add(i.config.TTL)
+			response.size = int64(len(key) + len(response.body))
+			for name, values := range response.header {
+				for _, value := range values {
+					response.size += int64(len(name) + len(value))
+				}
+			}
+			response.element = i.lru.PushFront(key)
+			i.bytes += response.size
+			i.evict()
+		} else {
+			delete(i.responses, key)
+		}
+		i.mu.Unlock()
+		close(response.done)
+	}()
+
+	c.Next()
+	completed = true
+}
+
+// evict drops the least recently used responses while the cache is over
+// MaxEntries or MaxBytes, keeping at least the newest. It must be called
+// with i.mu held.
+func (i *idempotencyCache) evict() {
+	for i.lru.Len() > 1 && (i.lru.Len() > i.config.MaxEntries || i.bytes > i.config.MaxBytes) {
+		key := i.lru.Back().Value.(string)
+		i.drop(key, i.responses[key])
+	}
+}
+
+// drop removes a stored response. It must be called with i.mu held.
+func (i *idempotencyCache) drop(key string, response *idempotentResponse) {
+	delete(i.responses, key)
+	if response.element != nil {
+		i.lru.Remove(response.element)
+		i.bytes -= response.size
+		response.element = nil
+	}
+}
+
+// sweep drops the responses older than the TTL. It runs at most once a
+// minute, or once per TTL if that is shorter, and never drops a request
+// still in flight. It must be called with i.mu held.
+func (i *idempotencyCache) sweep(now time.Time) {
+	if now.Sub(i.lastSweep) < min(i.config.TTL, time.Minute) {
+		return
+	}
+	for key, response := range i.responses {
+		if response.expired(now) {
+			i.drop(key, response)
+		}
+	}
+	i.lastSweep = now
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKey {
+		return false
+	}
+	for _, r := range key {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,279 @@
+package middlewares_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang-training/day_7_8/middlewares"
+	"golang-training/day_7_8/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+// idempotentRouter counts the books it adds, answering 201 with the count
+// so a replay can be told apart from a second run.
+func idempotentRouter(t *testing.T, config middlewares.IdempotencyConfig, handler gin.HandlerFunc) (*gin.Engine, *atomic.Int32) {
+	t.Helper()
+	idempotency, err := middlewares.Idempotency(config)
+	if err != nil {
+		t.Fatalf("Idempotency() failed: %v", err)
+	}
+
+	var runs atomic.Int32
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Header("X-Request-ID", c.GetHeader("X-Test-Request"))
+		if subject := c.GetHeader("X-Test-Subject"); subject != "" {
+			c.Set(middlewares.IdentityKey, middlewares.Identity{Subject: subject})
+		}
+	})
+	router.POST("/books", idempotency, func(c *gin.Context) {
+		n := runs.Add(1)
+		if handler != nil {
+			handler(c)
+			return
+		}
+		c.Header("Location", fmt.Sprintf("/books/%d", n))
+		c.JSON(http.StatusCreated, gin.H{"id": n})
+	})
+	return router, &runs
+}
+
+type keyedRequest struct {
+	key, body, subject, requestID string
+}
+
+func (r keyedRequest) send(router *gin.Engine) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/books", strings.NewReader(r.body))
+	if r.key != "" {
+		req.Header.Set(middlewares.IdempotencyKeyHeader, r.key)
+	}
+	req.Header.Set("X-Test-Subject", r.subject)
+	req.Header.Set("X-Test-Request", r.requestID)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotency(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	testCases := []struct {
+		name     string
+		first    keyedRequest
+		retry    keyedRequest
+		status   int
+		replayed bool
+		runs     int32
+	}{
+		{
+			name:  "Retry Is Replayed",
+			first: keyedRequest{key: "k1", body: `{"title":"Emma"}`}, retry: keyedRequest{key: "k1", body: `{"title":"Emma"}`},
+			status: http.StatusCreated, replayed: true, runs: 1,
+		},
+		{
+			name:  "Different Body Is Rejected",
+			first: keyedRequest{key: "k1", body: `{"title":"Emma"}`}, retry: keyedRequest{key: "k1", body: `{"title":"Dune"}`},
+			status: http.StatusUnprocessableEntity, runs: 1,
+		},
+		{
+			name:  "Different Key Runs Again",
+			first: keyedRequest{key: "k1", body: `{"title":"Emma"}`}, retry: keyedRequest{key: "k2", body: `{"title":"Emma"}`},
+			status: http.StatusCreated, runs: 2,
+		},
+		{
+			name:  "No Key Runs Again",
+			first: keyedRequest{body: `{"title":"Emma"}`}, retry: keyedRequest{body: `{"title":"Emma"}`},
+			status: http.StatusCreated, runs: 2,
+		},
+		{
+			name:  "Keys Are Per Identity",
+			first: keyedRequest{key: "k1", body: `{}`, subject: "alice"}, retry: keyedRequest{key: "k1", body: `{}`, subject: "bob"},
+			status: http.StatusCreated, runs: 2,
+		},
+		{
+			name:  "Key Too Long",
+			first: keyedRequest{body: `{}`}, retry: keyedRequest{key: strings.Repeat("k", 256), body: `{}`},
+			status: http.StatusBadRequest, runs: 1,
+		},
+		{
+			name:  "Key With Spaces",
+			first: keyedRequest{body: `{}`}, retry: keyedRequest{key: "my key", body: `{}`},
+			status: http.StatusBadRequest, runs: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router, runs := idempotentRouter(t, middlewares.IdempotencyConfig{}, nil)
+
+			first := tc.first.send(router)
+			tc.retry.requestID = "retry"
+			retry := tc.retry.send(router)
+
+			if retry.Code != tc.status {
+				t.Fatalf("expected %d, got %d: %s", tc.status, retry.Code, retry.Body)
+			}
+			if runs.Load() != tc.runs {
+				t.Errorf("expected the handler to run %d times, ran %d", tc.runs, runs.Load())
+			}
+			if replayed := retry.Header().Get("Idempotent-Replayed") == "true"; replayed != tc.replayed {
+				t.Errorf("expected Idempotent-Replayed %v, got %q", tc.replayed, retry.Header().Get("Idempotent-Replayed"))
+			}
+			if !tc.replayed {
+				return
+			}
+			if retry.Body.String() != first.Body.String() || retry.Header().Get("Location") != first.Header().Get("Location") {
+				t.Errorf("expected the first response %s, got %s", first.Body, retry.Body)
+			}
+			if id := retry.Header().Get("X-Request-ID"); id != "retry" {
+				t.Errorf("expected the retry to keep its own request ID, got %q", id)
+			}
+		})
+	}
+}
+
+func TestIdempotencyReusedKeyProblem(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router, _ := idempotentRouter(t, middlewares.IdempotencyConfig{}, nil)
+
+	keyedRequest{key: "k1", body: `{"title":"Emma"}`}.send(router)
+	w := keyedRequest{key: "k1", body: `{"title":"Dune"}`}.send(router)
+	if w.Header().Get("Content-Type") != problem.ContentType || !strings.Contains(w.Body.String(), problem.IdempotencyKeyReused.Type) {
+		t.Errorf("expected an %s problem, got %s", problem.IdempotencyKeyReused.Type, w.Body)
+	}
+}
+
+func TestIdempotencyServerErrorsAreNotKept(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var fail atomic.Bool
+	fail.Store(true)
+	router, runs := idempotentRouter(t, middlewares.IdempotencyConfig{}, func(c *gin.Context) {
+		if fail.Load() {
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+		c.Status(http.StatusCreated)
+	})
+
+	request := keyedRequest{key: "k1", body: `{}`}
+	if w := request.send(router); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", w.Code)
+	}
+	fail.Store(false)
+	if w := request.send(router); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expected the retry to run and answer 201, got %d", w.Code)
+	}
+	if w := request.send(router); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("expected the success to be replayed, got %d", w.Code)
+	}
+	if runs.Load() != 2 {
+		t.Errorf("expected the handler to run twice, ran %d", runs.Load())
+	}
+}
+
+func TestIdempotencyConcurrentRetries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	router, runs := idempotentRouter(t, middlewares.IdempotencyConfig{}, func(c *gin.Context) {
+		close(started)
+		<-release
+		c.JSON(http.StatusCreated, gin.H{"id": 1})
+	})
+
+	request := keyedRequest{key: "k1", body: `{"title":"Emma"}`}
+	responses := make([]*httptest.ResponseRecorder, 5)
+	var wg sync.WaitGroup
+	wg.Go(func() { responses[0] = request.send(router) })
+	<-started
+	for i := 1; i < len(responses); i++ {
+		wg.Go(func() { responses[i] = request.send(router) })
+	}
+	// a different body is turned away without waiting for the first
+	if w := (keyedRequest{key: "k1", body: `{"title":"Dune"}`}).send(router); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 while the first is in flight, got %d", w.Code)
+	}
+	close(release)
+	wg.Wait()
+
+	if runs.Load() != 1 {
+		t.Errorf("expected the handler to run once, ran %d", runs.Load())
+	}
+	for i, w := range responses {
+		if w.Code != http.StatusCreated || w.Body.String() != responses[0].Body.String() {
+			t.Errorf("response %d: expected the first response, got %d %s", i, w.Code, w.Body)
+		}
+	}
+}
+
+func TestIdempotencyExpires(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router, runs := idempotentRouter(t, middlewares.IdempotencyConfig{TTL: 50 * time.Millisecond}, nil)
+
+	request := keyedRequest{key: "k1", body: `{}`}
+	request.send(router)
+	request.send(router)
+	time.Sleep(60 * time.Millisecond)
+	if w := request.send(router); w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("expected an expired key to run again")
+	}
+	if runs.Load() != 2 {
+		t.Errorf("expected the handler to run twice, ran %d", runs.Load())
+	}
+}
+
+func TestIdempotencyEvictsLeastRecentlyUsed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	testCases := []struct {
+		name   string
+		config middlewares.IdempotencyConfig
+	}{
+		{name: "Max Entries", config: middlewares.IdempotencyConfig{MaxEntries: 2}},
+		// a response is about 175 bytes with its key and headers, so two fit
+		{name: "Max Bytes", config: middlewares.IdempotencyConfig{MaxBytes: 400}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router, runs := idempotentRouter(t, tc.config, func(c *gin.Context) {
+				c.String(http.StatusCreated, strings.Repeat("b", 100))
+			})
+			replayed := func(key string) bool {
+				return keyedRequest{key: key, body: `{}`}.send(router).Header().Get("Idempotent-Replayed") == "true"
+			}
+
+			replayed("k1")
+			replayed("k2")
+			// using k1 again makes k2 the least recently used, so k3 pushes it out
+			if !replayed("k1") {
+				t.Fatalf("expected k1 to be replayed")
+			}
+			replayed("k3")
+
+			if !replayed("k1") {
+				t.Errorf("expected the recently used k1 to be kept")
+			}
+			if replayed("k2") {
+				t.Errorf("expected k2 to be evicted")
+			}
+			if runs.Load() != 4 {
+				t.Errorf("expected the handler to run 4 times, ran %d", runs.Load())
+			}
+		})
+	}
+}
+
+func TestIdempotencyRejectsBadConfig(t *testing.T) {
+	for _, config := range []middlewares.IdempotencyConfig{{TTL: -time.Second}, {MaxBody: -1}, {MaxEntries: -1}, {MaxBytes: -1}} {
+		if _, err := middlewares.Idempotency(config); err == nil {
+			t.Errorf("expected Idempotency(%+v) to fail", config)
+		}
+	}
+}
@@ -1,24 +1,115 @@
 package middlewares
 
 import (
-	"log"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func Logger() gin.HandlerFunc {
+const (
+	// RequestIDHeader is read from the request and echoed on the response
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDKey is where Logger leaves the request ID on the gin.Context
+	RequestIDKey = "request_id"
+	// a longer ID than this is not propagated, to keep junk out of the logs
+	maxRequestIDLength = 128
+)
+
+type LoggerConfig struct {
+	// Logger receives one record per request, default JSON on stdout
+	Logger *slog.Logger
+	// SampleSuccess logs only one in every SampleSuccess requests that end
+	// below 400 without errors; 0 and 1 log them all. Failures are always
+	// logged.
+	SampleSuccess int
+}
+
+// RequestID returns the ID Logger gave the request.
+func RequestID(c *gin.Context) string {
+	return c.GetString(RequestIDKey)
+}
+
+// Logger tags every request with an X-Request-ID, kept from the client when
+// it sent a sensible one, and logs it once handled. It goes first so that
+// it sees the requests other middlewares turn away and the panics Recovery
+// turns into 500s.
+func Logger(config LoggerConfig) gin.HandlerFunc {
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+	var successes atomic.Uint64
+
 	return func(c *gin.Context) {
 		start := time.Now()
+
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		c.Set(RequestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+
 		c.Next()
-		duration := time.Since(start)
-
-		log.Printf("[Custom Logger] timestamp=%s method=%s endpoint=%s status=%d duration=%s\n",
-			time.Now().Format(time.RFC3339),
-			c.Request.Method,
-			c.Request.URL.Path,
-			c.Writer.Status(),
-			duration,
-		)
+
+		status := c.Writer.Status()
+		if status < 400 && len(c.Errors) == 0 && config.SampleSuccess > 1 {
+			if successes.Add(1)%uint64(config.SampleSuccess) != 1 {
+				return
+			}
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400 || len(c.Errors) > 0:
+			level = slog.LevelWarn
+		}
+
+		attrs := []slog.Attr{
+			slog.String("request_id", requestID),
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user_agent", c.Request.UserAgent()),
+		}
+		if identity, authenticated := CurrentIdentity(c); authenticated {
+			attrs = append(attrs, slog.String("subject", identity.Subject))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.Any("errors", c.Errors.Errors()))
+		}
+
+		logger.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// validRequestID accepts printable ASCII only, so a client cannot forge
+// extra log lines or fields.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
 	}
+	return true
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
 }
@@ -0,0 +1,137 @@
+package middlewares_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang-training/day_7_8/middlewares"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLogger(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var output bytes.Buffer
+	router := gin.New()
+	router.Use(middlewares.Logger(middlewares.LoggerConfig{
+		Logger:        slog.New(slog.NewJSONHandler(&output, nil)),
+		SampleSuccess: 3,
+	}), middlewares.Recovery())
+	router.GET("/books/:id", func(c *gin.Context) {
+		switch c.Param("id") {
+		case "fail":
+			c.Error(errors.New("disk on fire"))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "disk on fire"})
+		case "panic":
+			panic("out of pages")
+		case "missing":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		default:
+			c.String(http.StatusOK, "hello")
+		}
+	})
+
+	send := func(path, requestID string) (*httptest.ResponseRecorder, map[string]any) {
+		output.Reset()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("User-Agent", "shelf-reader/1.0")
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if output.Len() == 0 {
+			return w, nil
+		}
+		var record map[string]any
+		if err := json.Unmarshal(output.Bytes(), &record); err != nil {
+			t.Fatalf("expected a JSON record, got %q", output.String())
+		}
+		return w, record
+	}
+
+	t.Run("Fields", func(t *testing.T) {
+		w, record := send("/books/1", "trace-123")
+		if w.Header().Get("X-Request-ID") != "trace-123" {
+			t.Errorf("expected the request ID to be echoed, got %q", w.Header().Get("X-Request-ID"))
+		}
+		expected := map[string]any{
+			"msg": "request", "level": "INFO", "request_id": "trace-123", "method": "GET", "path": "/books/1",
+			"route": "/books/:id", "status": float64(200), "bytes": float64(5), "user_agent": "shelf-reader/1.0",
+		}
+		for field, value := range expected {
+			if record[field] != value {
+				t.Errorf("expected %s = %v, got %v", field, value, record[field])
+			}
+		}
+		if _, found := record["latency_ms"]; !found {
+			t.Errorf("expected a latency, got %v", record)
+		}
+	})
+
+	tests := []struct {
+		name          string
+		path          string
+		requestID     string
+		expectedLevel string
+		expectedError string
+	}{
+		{"Client Error", "/books/missing", "", "WARN", ""},
+		{"Handler Error", "/books/fail", "", "ERROR", "disk on fire"},
+		{"Panic", "/books/panic", "", "ERROR", "panic: out of pages"},
+		{"Unsafe Request ID", "/books/missing", "evil\nlevel=INFO", "WARN", ""},
+		{"Oversized Request ID", "/books/missing", strings.Repeat("x", 200), "WARN", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, record := send(tt.path, tt.requestID)
+			if record == nil || record["level"] != tt.expectedLevel {
+				t.Fatalf("expected a %s record, got %v", tt.expectedLevel, record)
+			}
+
+			requestID := w.Header().Get("X-Request-ID")
+			if len(requestID) != 32 || record["request_id"] != requestID {
+				t.Errorf("expected a generated request ID in header and log, got %q and %v", requestID, record["request_id"])
+			}
+			if tt.expectedError != "" && !strings.Contains(strings.Join(toStrings(record["errors"]), " "), tt.expectedError) {
+				t.Errorf("expected error %q, got %v", tt.expectedError, record["errors"])
+			}
+		})
+	}
+
+	t.Run("Sampling", func(t *testing.T) {
+		logged := 0
+		for range 9 {
+			if _, record := send("/books/1", ""); record != nil {
+				logged++
+			}
+		}
+		if logged != 3 {
+			t.Errorf("expected one in three successes logged, got %d of 9", logged)
+		}
+		for range 3 {
+			if _, record := send("/books/missing", ""); record == nil {
+				t.Fatalf("expected every failure to be logged")
+			}
+		}
+	})
+}
+
+func toStrings(value any) []string {
+	var result []string
+	values, _ := value.([]any)
+	for _, v := range values {
+		s, _ := v.(string)
+		result = append(result, s)
+	}
+	return result
+}
@@ -0,0 +1,158 @@
+package middlewares
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// latencyBuckets are Prometheus' default histogram buckets, in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestKey struct {
+	route, method, status string
+}
+
+type routeKey struct {
+	route, method string
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// Metrics counts the requests the router handles and writes them out in the
+// Prometheus text format. There is no client library behind it, the format
+// is simple enough to write by hand.
+type Metrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	latencies map[routeKey]*histogram
+	inFlight  atomic.Int64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{requests: map[requestKey]uint64{}, latencies: map[routeKey]*histogram{}}
+}
+
+// Middleware records every request under its route template, so the label
+// count stays bounded whatever paths clients make up.
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		m.inFlight.Add(1)
+		start := time.Now()
+		defer func() {
+			m.observe(c, time.Since(start))
+			m.inFlight.Add(-1)
+		}()
+
+		c.Next()
+	}
+}
+
+func (m *Metrics) observe(c *gin.Context, latency time.Duration) {
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	method := c.Request.Method
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS":
+	default:
+		method = "OTHER"
+	}
+	seconds := latency.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestKey{route, method, strconv.Itoa(c.Writer.Status())}]++
+
+	h := m.latencies[routeKey{route, method}]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latencies[routeKey{route, method}] = h
+	}
+	if i, _ := slices.BinarySearch(latencyBuckets, seconds); i < len(latencyBuckets) {
+		h.counts[i]++
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// WriteTo writes the request metrics, sorted so scrapes are easy to diff.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	WriteMetricHeader(&b, "books_http_requests_total", "counter", "Requests handled, by route, method and status.")
+	requests := slices.SortedFunc(maps.Keys(m.requests), func(a, b requestKey) int {
+		return cmp.Or(cmp.Compare(a.route, b.route), cmp.Compare(a.method, b.method), cmp.Compare(a.status, b.status))
+	})
+	for _, key := range requests {
+		WriteMetric(&b, "books_http_requests_total", float64(m.requests[key]), "route", key.route, "method", key.method, "status", key.status)
+	}
+
+	WriteMetricHeader(&b, "books_http_request_duration_seconds", "histogram", "Time taken to handle requests, by route and method.")
+	routes := slices.SortedFunc(maps.Keys(m.latencies), func(a, b routeKey) int {
+		return cmp.Or(cmp.Compare(a.route, b.route), cmp.Compare(a.method, b.method))
+	})
+	for _, key := range routes {
+		h := m.latencies[key]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			WriteMetric(&b, "books_http_request_duration_seconds_bucket", float64(cumulative),
+				"route", key.route, "method", key.method, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		WriteMetric(&b, "books_http_request_duration_seconds_bucket", float64(h.count), "route", key.route, "method", key.method, "le", "+Inf")
+		WriteMetric(&b, "books_http_request_duration_seconds_sum", h.sum, "route", key.route, "method", key.method)
+		WriteMetric(&b, "books_http_request_duration_seconds_count", float64(h.count), "route", key.route, "method", key.method)
+	}
+
+	WriteMetricHeader(&b, "books_http_requests_in_flight", "gauge", "Requests being handled right now.")
+	WriteMetric(&b, "books_http_requests_in_flight", float64(m.inFlight.Load()))
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// WriteMetricHeader writes the HELP and TYPE lines that start a metric family.
+func WriteMetricHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// WriteMetric writes one sample; labels come in name, value pairs.
+func WriteMetric(b *strings.Builder, name string, value float64, labels ...string) {
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(b, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(b, " %s\n", strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,69 @@
+package middlewares_test
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang-training/day_7_8/middlewares"
+	"golang-training/day_7_8/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMiddlewareProblems(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	rateLimit, err := middlewares.RateLimit(middlewares.RateLimitConfig{IP: middlewares.Quota{Burst: 1, Refill: 0.001}})
+	if err != nil {
+		t.Fatalf("RateLimit() failed: %v", err)
+	}
+	router := gin.New()
+	router.Use(
+		middlewares.Logger(middlewares.LoggerConfig{Logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}),
+		middlewares.Recovery(),
+		middlewares.RequestFilter(),
+	)
+	router.GET("/panic", func(c *gin.Context) { panic("secret internals") })
+	router.GET("/limited", rateLimit, func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	testCases := []struct {
+		name   string
+		method string
+		path   string
+		kind   problem.Kind
+	}{
+		{name: "Recovery", method: "GET", path: "/panic", kind: problem.Internal},
+		{name: "Request Filter", method: "OPTIONS", path: "/limited", kind: problem.MethodNotAllowed},
+		{name: "Rate Limit", method: "GET", path: "/limited", kind: problem.RateLimited},
+	}
+	// the first request spends the only token, so the one in the table is limited
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/limited", nil))
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			req.Header.Set("X-Request-ID", "trace-1")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.kind.Status || w.Header().Get("Content-Type") != problem.ContentType {
+				t.Fatalf("expected %d as %s, got %d as %s", tc.kind.Status, problem.ContentType, w.Code, w.Header().Get("Content-Type"))
+			}
+			var body map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding the problem failed: %v", err)
+			}
+			if body["type"] != tc.kind.Type || body["instance"] != tc.path || body["request_id"] != "trace-1" {
+				t.Errorf("expected a %s problem for %s quoting the request ID, got %v", tc.kind.Type, tc.path, body)
+			}
+			if strings.Contains(w.Body.String(), "secret internals") {
+				t.Errorf("expected the panic to stay out of the response, got %s", w.Body)
+			}
+		})
+	}
+}
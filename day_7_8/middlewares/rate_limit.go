@@ -0,0 +1,201 @@
+package middlewares
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang-training/day_7_8/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Quota is a token bucket: a client can make Burst requests at once, and
+// earns Refill more every second.
+type Quota struct {
+	Burst  int
+	Refill float64
+}
+
+// RateLimitConfig sets the quotas; zero values fall back to the defaults.
+type RateLimitConfig struct {
+	// IP applies to callers without an identity, default 20 burst, 10/s
+	IP Quota
+	// Key applies to each authenticated caller, default 100 burst, 50/s
+	Key Quota
+	// Clients overrides Key for the named subjects
+	Clients map[string]Quota
+	// IdleTimeout is how long an unused bucket is kept, default 10 minutes.
+	// Keep it above the time a bucket takes to refill, or a client that goes
+	// quiet comes back with a full bucket early.
+	IdleTimeout time.Duration
+}
+
+type bucket struct {
+	quota    Quota
+	tokens   float64
+	lastSeen time.Time
+}
+
+// refill adds the tokens earned since the bucket was last seen.
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.lastSeen).Seconds()
+	b.tokens = math.Min(float64(b.quota.Burst), b.tokens+elapsed*b.quota.Refill)
+	b.lastSeen = now
+}
+
+// take refills the bucket and spends a token if there is one.
+func (b *bucket) take(now time.Time) bool {
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *bucket) secondsUntil(tokens float64) int {
+	return int(math.Ceil(math.Max(0, tokens-b.tokens) / b.quota.Refill))
+}
+
+// RateLimiter holds the buckets. Limit counts every request; AuthFailures
+// counts the ones Auth turns away.
+type RateLimiter struct {
+	config    RateLimitConfig
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// RateLimit is NewRateLimiter for callers that only need Limit.
+func RateLimit(config RateLimitConfig) (gin.HandlerFunc, error) {
+	limiter, err := NewRateLimiter(config)
+	if err != nil {
+		return nil, err
+	}
+	return limiter.Limit, nil
+}
+
+// NewRateLimiter gives every client a token bucket; see Limit and
+// AuthFailures for how they are spent.
+func NewRateLimiter(config RateLimitConfig) (*RateLimiter, error) {
+	if config.IP == (Quota{}) {
+		config.IP = Quota{Burst: 20, Refill: 10}
+	}
+	if config.Key == (Quota{}) {
+		config.Key = Quota{Burst: 100, Refill: 50}
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = 10 * time.Minute
+	}
+
+	quotas := map[string]Quota{"IP": config.IP, "key": config.Key}
+	for subject, quota := range config.Clients {
+		quotas["client "+subject] = quota
+	}
+	for name, quota := range quotas {
+		if quota.Burst < 1 || quota.Refill <= 0 {
+			return nil, fmt.Errorf("%s quota needs a burst of at least 1 and a positive refill", name)
+		}
+	}
+	if config.IdleTimeout < 0 {
+		return nil, fmt.Errorf("idle timeout must not be negative")
+	}
+
+	return &RateLimiter{config: config, buckets: map[string]*bucket{}, lastSweep: time.Now()}, nil
+}
+
+// Limit answers 429 once the caller's bucket is empty. Authenticated
+// callers are counted per identity, so it goes after Auth; everyone else
+// is counted per IP. Each response carries the RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers.
+func (l *RateLimiter) Limit(c *gin.Context) {
+	key, quota := "ip:"+c.ClientIP(), l.config.IP
+	if identity, authenticated := CurrentIdentity(c); authenticated {
+		key, quota = "id:"+identity.Subject, l.config.Key
+		if override, found := l.config.Clients[identity.Subject]; found {
+			quota = override
+		}
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	l.sweep(now)
+	b, found := l.buckets[key]
+	if !found || b.quota != quota {
+		b = &bucket{quota: quota, tokens: float64(quota.Burst), lastSeen: now}
+		l.buckets[key] = b
+	}
+	allowed := b.take(now)
+	remaining := int(b.tokens)
+	reset := b.secondsUntil(float64(quota.Burst))
+	retryAfter := b.secondsUntil(1)
+	l.mu.Unlock()
+
+	c.Header("RateLimit-Limit", strconv.Itoa(quota.Burst))
+	c.Header("RateLimit-Remaining", strconv.Itoa(remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(reset))
+	if !allowed {
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		problem.Abort(c, problem.RateLimited, fmt.Sprintf("Try again in %d seconds", retryAfter))
+		return
+	}
+
+	c.Next()
+}
+
+// AuthFailures goes before Auth, which answers 401 before Limit can count
+// the request. Each 401 spends a token from a bucket of the IP quota kept
+// for the client's failures, and once that is empty every request from
+// the client is turned away, so credentials cannot be guessed any faster
+// than an anonymous client can make requests.
+func (l *RateLimiter) AuthFailures(c *gin.Context) {
+	key, now := "auth:"+c.ClientIP(), time.Now()
+
+	l.mu.Lock()
+	l.sweep(now)
+	retryAfter := 0
+	if b, found := l.buckets[key]; found {
+		b.refill(now)
+		retryAfter = b.secondsUntil(1)
+	}
+	l.mu.Unlock()
+
+	if retryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		problem.Abort(c, problem.RateLimited, fmt.Sprintf("Too many failed authentication attempts, try again in %d seconds", retryAfter))
+		return
+	}
+
+	c.Next()
+
+	if c.Writer.Status() != http.StatusUnauthorized {
+		return
+	}
+	l.mu.Lock()
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{quota: l.config.IP, tokens: float64(l.config.IP.Burst), lastSeen: now}
+		l.buckets[key] = b
+	}
+	b.take(time.Now())
+	l.mu.Unlock()
+}
+
+// sweep drops the buckets nobody has used for IdleTimeout. It runs at most
+// once per IdleTimeout, so the map never holds more than the clients seen
+// in the last two timeouts. It must be called with l.mu held.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.config.IdleTimeout {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) >= l.config.IdleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,175 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang-training/day_7_8/middlewares"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	auth, err := middlewares.Auth(middlewares.AuthConfig{
+		APIKeysFile: writeFile(t, "keys", []byte("small-key small\nbig-key big\n")),
+	})
+	if err != nil {
+		t.Fatalf("Auth() failed: %v", err)
+	}
+	// one token an hour, so nothing refills while the test runs
+	rateLimit, err := middlewares.RateLimit(middlewares.RateLimitConfig{
+		IP:          middlewares.Quota{Burst: 2, Refill: 1.0 / 3600},
+		Key:         middlewares.Quota{Burst: 3, Refill: 1.0 / 3600},
+		Clients:     map[string]middlewares.Quota{"big": {Burst: 5, Refill: 1.0 / 3600}},
+		IdleTimeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("RateLimit() failed: %v", err)
+	}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if c.GetHeader("X-API-Key") != "" {
+			auth(c)
+		}
+	}, rateLimit)
+	router.GET("/books", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	send := func(ip, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/books", nil)
+		req.RemoteAddr = ip + ":1234"
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	allowed := func(ip, key string) int {
+		count := 0
+		for range 10 {
+			if send(ip, key).Code != http.StatusNoContent {
+				break
+			}
+			count++
+		}
+		return count
+	}
+
+	tests := []struct {
+		name     string
+		ip, key  string
+		expected int
+	}{
+		{"Per IP", "10.0.0.1", "", 2},
+		{"Separate IP", "10.0.0.2", "", 2},
+		{"API Key Ignores Spent IP", "10.0.0.1", "small-key", 3},
+		{"API Key Across IPs", "10.0.0.3", "small-key", 0},
+		{"Client Override", "10.0.0.1", "big-key", 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := allowed(tt.ip, tt.key); got != tt.expected {
+				t.Errorf("expected %d requests through, got %d", tt.expected, got)
+			}
+		})
+	}
+
+	t.Run("Headers", func(t *testing.T) {
+		w := send("10.0.0.4", "")
+		if w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Remaining") != "1" {
+			t.Errorf("expected limit 2 with 1 remaining, got %v", w.Header())
+		}
+		send("10.0.0.4", "")
+
+		w = send("10.0.0.4", "")
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("RateLimit-Remaining") != "0" {
+			t.Fatalf("expected 429 with nothing remaining, got %d %v", w.Code, w.Header())
+		}
+		retryAfter, _ := strconv.Atoi(w.Header().Get("Retry-After"))
+		reset, _ := strconv.Atoi(w.Header().Get("RateLimit-Reset"))
+		if retryAfter < 3500 || retryAfter > 3600 || reset < 7100 || reset > 7200 {
+			t.Errorf("expected about an hour to retry and two to reset, got Retry-After %d and RateLimit-Reset %d", retryAfter, reset)
+		}
+	})
+
+	t.Run("Idle Eviction", func(t *testing.T) {
+		time.Sleep(60 * time.Millisecond)
+		// the first request after the timeout sweeps the idle buckets away
+		send("10.0.0.5", "")
+		if got := allowed("10.0.0.1", ""); got != 2 {
+			t.Errorf("expected an evicted client to start afresh, got %d requests through", got)
+		}
+	})
+}
+
+func TestRateLimitConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config middlewares.RateLimitConfig
+	}{
+		{"No Refill", middlewares.RateLimitConfig{IP: middlewares.Quota{Burst: 5}}},
+		{"No Burst", middlewares.RateLimitConfig{Key: middlewares.Quota{Refill: 1}}},
+		{"Bad Client", middlewares.RateLimitConfig{Clients: map[string]middlewares.Quota{"alice": {Burst: -1, Refill: 1}}}},
+		{"Negative Idle Timeout", middlewares.RateLimitConfig{IdleTimeout: -time.Second}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := middlewares.RateLimit(tt.config); err == nil {
+				t.Errorf("expected RateLimit() to reject the config")
+			}
+		})
+	}
+}
+
+func TestRateLimitAuthFailures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	auth, err := middlewares.Auth(middlewares.AuthConfig{
+		APIKeysFile: writeFile(t, "keys", []byte("good-key alice\n")),
+	})
+	if err != nil {
+		t.Fatalf("Auth() failed: %v", err)
+	}
+	limiter, err := middlewares.NewRateLimiter(middlewares.RateLimitConfig{
+		IP: middlewares.Quota{Burst: 2, Refill: 1.0 / 3600},
+	})
+	if err != nil {
+		t.Fatalf("NewRateLimiter() failed: %v", err)
+	}
+
+	router := gin.New()
+	router.Use(limiter.AuthFailures, auth, limiter.Limit)
+	router.GET("/books", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	send := func(ip, key string) int {
+		req := httptest.NewRequest("GET", "/books", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// the IP burst of guesses is answered, then the client is shut out,
+	// even with the right key, while a caller elsewhere is not
+	var codes []int
+	for _, key := range []string{"guess-1", "guess-2", "guess-3", "good-key"} {
+		codes = append(codes, send("10.0.0.1", key))
+	}
+	expected := []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusTooManyRequests}
+	if !slices.Equal(codes, expected) {
+		t.Errorf("expected %v, got %v", expected, codes)
+	}
+	if code := send("10.0.0.2", "good-key"); code != http.StatusNoContent {
+		t.Errorf("expected another IP to get through, got %d", code)
+	}
+}
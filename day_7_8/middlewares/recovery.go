@@ -0,0 +1,20 @@
+package middlewares
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+
+	"golang-training/day_7_8/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Recovery turns a panic into a 500 and hands it, stack and all, to Logger
+// through c.Error instead of printing it to stderr.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		c.Error(fmt.Errorf("panic: %v\n%s", recovered, debug.Stack()))
+		problem.Abort(c, problem.Internal, "The request could not be completed")
+	})
+}
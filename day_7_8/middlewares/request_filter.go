@@ -1,21 +1,20 @@
 package middlewares
 
 import (
-	"net/http"
 	"slices"
 
+	"golang-training/day_7_8/problem"
+
 	"github.com/gin-gonic/gin"
 )
 
 func RequestFilter() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if slices.Contains([]string{"GET", "POST", "PUT", "DELETE"}, c.Request.Method) {
+		if slices.Contains([]string{"GET", "POST", "PUT", "PATCH", "DELETE"}, c.Request.Method) {
 			c.Next()
 			return
 		}
 
-		c.AbortWithStatusJSON(http.StatusMethodNotAllowed, gin.H{
-			"error": "Method not allowed",
-		})
+		problem.Abort(c, problem.MethodNotAllowed, c.Request.Method+" is not supported")
 	}
 }
@@ -1,6 +1,10 @@
 package models
 
 import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
 	"sync"
 	"time"
 )
@@ -8,77 +12,406 @@ import (
 type Books struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
-	Rating    float64   `json:"rating"`
+	// DeletedAt is set while the book sits in the trash
+	DeletedAt time.Time `json:"deleted_at,omitzero"`
+	Rating    float64   `json:"rating" validate:"required,min=0,max=5"`
 	ID        int       `json:"id"`
-	Title     string    `json:"title"`
-	Author    string    `json:"author"`
-	Genre     string    `json:"genre"`
+	Version   int       `json:"version"`
+	Title     string    `json:"title" validate:"required,max=200"`
+	Author    string    `json:"author" validate:"required,max=100"`
+	Genre     string    `json:"genre" validate:"required,genre"`
 }
 
 type LibraryStore struct {
 	mu    sync.RWMutex
 	Books map[int]Books
+
+	// journal is nil for the plain in-memory store. When set, every write is
+	// recorded there before the map is touched, so a failed write leaves the
+	// map unchanged.
+	journal journal
+
+	// index is only changed together with Books, under the same lock
+	index *searchIndex
+
+	// history holds every change per book, oldest first. It is only
+	// appended to, under the same lock as Books.
+	history map[int][]HistoryEntry
+
+	// hooks hear about every committed change, in commit order
+	hooks []func(HistoryEntry)
+
+	// nextID only ever grows, so an ID is never handed out twice even after
+	// the book holding it is purged
+	nextID int
+
+	// counts is kept up to date by put and remove
+	counts BookCounts
 }
 
 func NewBookStore() *LibraryStore {
-	return &LibraryStore{
-		Books: map[int]Books{
-			1: {ID: 1, Title: "The Great Gatsby", Author: "F. Scott Fitzgerald", Genre: "Fiction", Rating: 4.2, CreatedAt: time.Now(), UpdatedAt: time.Now()},
-			2: {ID: 2, Title: "To Kill a Mockingbird", Author: "Harper Lee", Genre: "Fiction", Rating: 4.3, CreatedAt: time.Now(), UpdatedAt: time.Now()},
-			3: {ID: 3, Title: "1984", Author: "George Orwell", Genre: "Dystopian", Rating: 4.4, CreatedAt: time.Now(), UpdatedAt: time.Now()},
-		},
+	return newLibraryStore(nil)
+}
+
+func newLibraryStore(books map[int]Books) *LibraryStore {
+	ls := &LibraryStore{
+		Books:   make(map[int]Books, len(books)),
+		index:   newSearchIndex(),
+		history: map[int][]HistoryEntry{},
+		nextID:  1,
+		counts:  BookCounts{ByGenre: map[string]int{}},
+	}
+	for _, book := range books {
+		ls.put(book)
+	}
+	return ls
+}
+
+func (ls *LibraryStore) GetBook(ctx context.Context, bookID int) (Books, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	book, exists := ls.live(bookID)
+	if !exists {
+		return Books{}, ErrBookNotFound
 	}
+
+	return book, nil
 }
 
-func (ls *LibraryStore) GetAllBooks() []Books {
+func (ls *LibraryStore) GetAllBooks(ctx context.Context) ([]Books, error) {
 	ls.mu.RLock()
 	defer ls.mu.RUnlock()
 
 	books := make([]Books, 0, len(ls.Books))
 	for _, book := range ls.Books {
+		if !book.DeletedAt.IsZero() {
+			continue
+		}
 		// I have done controlled appending here as I have already initialized the slice with proper length
 		// So, no reallocation will happen during append
 		books = append(books, book)
 	}
 
-	return books
+	return books, nil
 }
 
-func (ls *LibraryStore) AddBook(book Books) Books {
-	if book.ID == 0 {
-		book.ID = len(ls.Books) + 1
+func (ls *LibraryStore) QueryBooks(ctx context.Context, query BookQuery) (BookPage, error) {
+	books, err := ls.GetAllBooks(ctx)
+	if err != nil {
+		return BookPage{}, err
 	}
-	
+
+	return queryBooks(books, query)
+}
+
+// AddBook stores a new book. A zero ID is assigned from the sequence, any
+// other ID must not be taken yet.
+func (ls *LibraryStore) AddBook(ctx context.Context, book Books) (Books, error) {
 	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	batch := []Books{book}
+	if err := ls.assignIDs(batch); err != nil {
+		return Books{}, err
+	}
+	book = batch[0]
+
+	book.Version = 1
 	book.CreatedAt = time.Now()
+	book.UpdatedAt = book.CreatedAt
+	book.DeletedAt = time.Time{}
+	if err := ls.commit(journalEntry{Op: opPut, Book: book, History: []HistoryEntry{
+		ls.historyEntry(ctx, HistoryAdd, book.CreatedAt, nil, &book),
+	}}); err != nil {
+		return Books{}, err
+	}
+
+	return book, nil
+}
+
+// AddBooks adds every book or none of them.
+func (ls *LibraryStore) AddBooks(ctx context.Context, books []Books) ([]Books, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	now := time.Now()
+	added := slices.Clone(books)
+	if err := ls.assignIDs(added); err != nil {
+		return nil, err
+	}
+
+	history := make([]HistoryEntry, len(added))
+	for i := range added {
+		added[i].Version = 1
+		added[i].CreatedAt = now
+		added[i].UpdatedAt = now
+		added[i].DeletedAt = time.Time{}
+		history[i] = ls.historyEntry(ctx, HistoryAdd, now, nil, &added[i])
+	}
+
+	if err := ls.commit(journalEntry{Op: opPutBatch, Batch: added, History: history}); err != nil {
+		return nil, err
+	}
+
+	return added, nil
+}
+
+// DeleteBook moves a book to the trash, from where RestoreBook can bring it
+// back until it is purged. A non-zero version makes the delete conditional on
+// the book still being at that version.
+func (ls *LibraryStore) DeleteBook(ctx context.Context, bookID, version int) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	existing, exists := ls.live(bookID)
+	if !exists {
+		return ErrBookNotFound
+	}
+	if version != 0 && version != existing.Version {
+		return ErrVersionConflict
+	}
+
+	book := existing
+	book.Version++
 	book.UpdatedAt = time.Now()
-	ls.Books[book.ID] = book
-	ls.mu.Unlock()
+	book.DeletedAt = book.UpdatedAt
+	return ls.commit(journalEntry{Op: opPut, Book: book, History: []HistoryEntry{
+		ls.historyEntry(ctx, HistoryDelete, book.UpdatedAt, &existing, &book),
+	}})
+}
+
+// UpdateBook replaces a book. A non-zero book.Version makes the update
+// conditional on the stored book still being at that version.
+func (ls *LibraryStore) UpdateBook(ctx context.Context, book Books) (Books, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
 
-	return book
+	existing, exists := ls.live(book.ID)
+	if !exists {
+		return Books{}, ErrBookNotFound
+	}
+	if book.Version != 0 && book.Version != existing.Version {
+		return Books{}, ErrVersionConflict
+	}
+
+	// PUT carries the whole record, but the creation time belongs to the store
+	book.Version = existing.Version + 1
+	book.CreatedAt = existing.CreatedAt
+	book.UpdatedAt = time.Now()
+	book.DeletedAt = time.Time{}
+	if err := ls.commit(journalEntry{Op: opPut, Book: book, History: []HistoryEntry{
+		ls.historyEntry(ctx, HistoryUpdate, book.UpdatedAt, &existing, &book),
+	}}); err != nil {
+		return Books{}, err
+	}
+
+	return book, nil
 }
 
-func (ls *LibraryStore) DeleteBook(bookID int) bool {
-	if _, exists := ls.Books[bookID]; !exists {
-		return false
+func (ls *LibraryStore) CountBooks(ctx context.Context) (BookCounts, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	counts := ls.counts
+	counts.ByGenre = maps.Clone(ls.counts.ByGenre)
+	return counts, nil
+}
+
+// GetDeletedBooks lists the trash, most recently deleted first.
+func (ls *LibraryStore) GetDeletedBooks(ctx context.Context) ([]Books, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	books := []Books{}
+	for _, book := range ls.Books {
+		if !book.DeletedAt.IsZero() {
+			books = append(books, book)
+		}
 	}
-	
+	sortTrash(books)
+
+	return books, nil
+}
+
+// RestoreBook takes a book back out of the trash.
+func (ls *LibraryStore) RestoreBook(ctx context.Context, bookID int) (Books, error) {
 	ls.mu.Lock()
-	delete(ls.Books, bookID)
-	ls.mu.Unlock()
+	defer ls.mu.Unlock()
+
+	trashed, exists := ls.Books[bookID]
+	if !exists || trashed.DeletedAt.IsZero() {
+		return Books{}, ErrBookNotFound
+	}
+
+	book := trashed
+	book.Version++
+	book.UpdatedAt = time.Now()
+	book.DeletedAt = time.Time{}
+	if err := ls.commit(journalEntry{Op: opPut, Book: book, History: []HistoryEntry{
+		ls.historyEntry(ctx, HistoryRestore, book.UpdatedAt, &trashed, &book),
+	}}); err != nil {
+		return Books{}, err
+	}
+
+	return book, nil
+}
+
+// PurgeDeletedBooks permanently removes the books trashed before the cutoff
+// and reports how many went.
+func (ls *LibraryStore) PurgeDeletedBooks(ctx context.Context, before time.Time) (int, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	var (
+		now    = time.Now()
+		purged = 0
+	)
+	for id, book := range ls.Books {
+		if book.DeletedAt.IsZero() || !book.DeletedAt.Before(before) {
+			continue
+		}
+		if err := ls.commit(journalEntry{Op: opDelete, Book: Books{ID: id}, History: []HistoryEntry{
+			ls.historyEntry(ctx, HistoryPurge, now, &book, nil),
+		}}); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// assignIDs fills in the books without an ID from the sequence and checks
+// the others are free, within the batch too. An ID with history belonged to
+// a book that was purged and stays retired. Nothing is assigned unless
+// every book passes. It must be called with ls.mu held for writing;
+// the sequence itself only moves once the books are put.
+func (ls *LibraryStore) assignIDs(books []Books) error {
+	taken := map[int]bool{}
+	for _, book := range books {
+		if book.ID == 0 {
+			continue
+		}
+		_, exists := ls.Books[book.ID]
+		if exists || len(ls.history[book.ID]) > 0 || taken[book.ID] {
+			return fmt.Errorf("%w: %d", ErrDuplicateID, book.ID)
+		}
+		taken[book.ID] = true
+	}
+
+	// client-chosen IDs above the sequence push it forward, so assigned IDs
+	// cannot collide with them
+	next := ls.nextID
+	for id := range taken {
+		next = max(next, id+1)
+	}
+	for i := range books {
+		if books[i].ID == 0 {
+			books[i].ID = next
+			next++
+		}
+	}
 
-	return true
+	return nil
 }
 
-func (ls *LibraryStore) UpdateBook(book Books) (Books, bool) {
-	if _, exists := ls.Books[book.ID]; !exists {
+// live returns a book that exists and is not in the trash. It must be called
+// with ls.mu held.
+func (ls *LibraryStore) live(bookID int) (Books, bool) {
+	book, exists := ls.Books[bookID]
+	if !exists || !book.DeletedAt.IsZero() {
 		return Books{}, false
 	}
+	return book, true
+}
+
+// commit journals a write and then applies it. It must be called with ls.mu
+// held for writing.
+func (ls *LibraryStore) commit(entry journalEntry) error {
+	if ls.journal != nil {
+		if err := ls.journal.record(entry); err != nil {
+			return err
+		}
+	}
+	ls.apply(entry)
+
+	for _, change := range entry.History {
+		for _, hook := range ls.hooks {
+			hook(change)
+		}
+	}
+	return nil
+}
 
+// OnChange registers a hook that is called after every committed change.
+// Hooks run under the store lock, so they must be quick and must not call
+// back into the store.
+func (ls *LibraryStore) OnChange(hook func(HistoryEntry)) {
 	ls.mu.Lock()
-	book.UpdatedAt = time.Now()
+	defer ls.mu.Unlock()
+
+	ls.hooks = append(ls.hooks, hook)
+}
+
+// apply replays a journal entry onto the map without journaling it again.
+// Replay can meet an entry the snapshot already holds, if a crash came
+// between writing the snapshot and emptying the log, so history entries up
+// to the book's latest revision are skipped. The book states are simply
+// written again.
+func (ls *LibraryStore) apply(entry journalEntry) {
+	for _, change := range entry.History {
+		if change.Revision <= len(ls.history[change.BookID]) {
+			continue
+		}
+		ls.history[change.BookID] = append(ls.history[change.BookID], change)
+	}
+
+	switch entry.Op {
+	case opPut:
+		ls.put(entry.Book)
+	case opPutBatch:
+		for _, book := range entry.Batch {
+			ls.put(book)
+		}
+	case opDelete:
+		ls.remove(entry.Book.ID)
+	}
+}
+
+// put and remove are the only places that change Books, so the search index
+// can never drift from the map. Both must be called with ls.mu held for writing.
+func (ls *LibraryStore) put(book Books) {
+	if old, exists := ls.Books[book.ID]; exists {
+		ls.count(old, -1)
+	}
 	ls.Books[book.ID] = book
-	ls.mu.Unlock()
+	ls.count(book, 1)
+	ls.nextID = max(ls.nextID, book.ID+1)
 
-	return book, true
+	// trashed books stay in the map but cannot be found by search
+	if book.DeletedAt.IsZero() {
+		ls.index.add(book)
+	} else {
+		ls.index.remove(book.ID)
+	}
+}
+
+func (ls *LibraryStore) remove(bookID int) {
+	if old, exists := ls.Books[bookID]; exists {
+		ls.count(old, -1)
+	}
+	delete(ls.Books, bookID)
+	ls.index.remove(bookID)
+}
+
+func (ls *LibraryStore) count(book Books, delta int) {
+	if !book.DeletedAt.IsZero() {
+		ls.counts.Trashed += delta
+		return
+	}
+	ls.counts.Live += delta
+	if ls.counts.ByGenre[book.Genre] += delta; ls.counts.ByGenre[book.Genre] == 0 {
+		delete(ls.counts.ByGenre, book.Genre)
+	}
 }
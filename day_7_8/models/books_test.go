@@ -1,92 +1,305 @@
 package models
 
 import (
+	"context"
+	"errors"
+	"maps"
+	"path/filepath"
 	"testing"
 )
 
-func TestLibraryStoreInitialization(t *testing.T) {
-	store := NewBookStore()
+// every BookRepository implementation has to pass the same suite, each one
+// starting from the three-book demo fixture
+var repositoryFactories = map[string]func(t *testing.T) BookRepository{
+	"memory": func(t *testing.T) BookRepository {
+		return seedDemo(t, NewBookStore())
+	},
+	"file": func(t *testing.T) BookRepository {
+		store, err := NewFileStore(t.TempDir())
+		if err != nil {
+			t.Fatalf("NewFileStore() failed: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return seedDemo(t, store)
+	},
+	"sqlite": func(t *testing.T) BookRepository {
+		store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "books.db"))
+		if err != nil {
+			t.Fatalf("NewSQLiteStore() failed: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return seedDemo(t, store)
+	},
+}
 
-	if store == nil {
-		t.Fatal("NewBookStore() returned nil")
+func forEachRepository(t *testing.T, test func(t *testing.T, store BookRepository)) {
+	for name, newRepository := range repositoryFactories {
+		t.Run(name, func(t *testing.T) {
+			test(t, newRepository(t))
+		})
 	}
+}
 
-	if len(store.Books) != 3 {
-		t.Fatalf("expected default 3 books, got %d", len(store.Books))
+func countBooks(t *testing.T, store BookRepository) int {
+	books, err := store.GetAllBooks(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllBooks() failed: %v", err)
 	}
+	return len(books)
+}
+
+func TestLibraryStoreInitialization(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		if store == nil {
+			t.Fatal("store constructor returned nil")
+		}
+
+		if n := countBooks(t, store); n != 3 {
+			t.Fatalf("expected the 3 demo books, got %d", n)
+		}
+	})
 }
 
 func TestAddBookTDD(t *testing.T) {
-	store := NewBookStore()
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		book := Books{
+			Title:  "New Book",
+			Author: "Author A",
+			Genre:  "Fiction",
+			Rating: 4.5,
+		}
 
-	book := Books{
-		Title:  "New Book",
-		Author: "Author A",
-		Genre:  "Fiction",
-		Rating: 4.5,
-	}
+		added, err := store.AddBook(context.Background(), book)
+		if err != nil {
+			t.Fatalf("AddBook() failed: %v", err)
+		}
 
-	added := store.AddBook(book)
+		if added.ID == 0 {
+			t.Error("expected AddBook to assign a new non-zero ID")
+		}
 
-	if added.ID == 0 {
-		t.Error("expected AddBook to assign a new non-zero ID")
-	}
+		if added.CreatedAt.IsZero() || added.UpdatedAt.IsZero() {
+			t.Error("expected AddBook to set CreatedAt and UpdatedAt")
+		}
 
-	if len(store.Books) != 4 {
-		t.Errorf("expected 4 books after AddBook, got %d", len(store.Books))
-	}
+		if n := countBooks(t, store); n != 4 {
+			t.Errorf("expected 4 books after AddBook, got %d", n)
+		}
+	})
+}
+
+func TestGetBookTDD(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		book, err := store.GetBook(context.Background(), 3)
+		if err != nil {
+			t.Fatalf("expected GetBook to find book 3, got %v", err)
+		}
+
+		if book.Title != "1984" {
+			t.Errorf("expected title 1984, got %q", book.Title)
+		}
+
+		_, err = store.GetBook(context.Background(), 99)
+		if !errors.Is(err, ErrBookNotFound) {
+			t.Errorf("expected ErrBookNotFound for non-existing ID, got %v", err)
+		}
+	})
 }
 
 func TestGetAllBooksTDD(t *testing.T) {
-	store := NewBookStore()
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		books, err := store.GetAllBooks(context.Background())
+		if err != nil {
+			t.Fatalf("GetAllBooks() failed: %v", err)
+		}
 
-	books := store.GetAllBooks()
-	if len(books) != 3 {
-		t.Errorf("expected 3 books from GetAllBooks, got %d", len(books))
-	}
+		if len(books) != 3 {
+			t.Errorf("expected 3 books from GetAllBooks, got %d", len(books))
+		}
+	})
 }
 
 func TestDeleteBookTDD(t *testing.T) {
-	store := NewBookStore()
-
-	ok := store.DeleteBook(1)
-	if !ok {
-		t.Error("expected DeleteBook to return true for an existing book")
-	}
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		if err := store.DeleteBook(context.Background(), 1, 0); err != nil {
+			t.Errorf("expected DeleteBook to succeed for an existing book, got %v", err)
+		}
 
-	if len(store.Books) != 2 {
-		t.Errorf("expected size 2 after deletion, got %d", len(store.Books))
-	}
+		if n := countBooks(t, store); n != 2 {
+			t.Errorf("expected size 2 after deletion, got %d", n)
+		}
 
-	ok = store.DeleteBook(99)
-	if ok {
-		t.Error("expected DeleteBook to return false for non-existing ID")
-	}
+		err := store.DeleteBook(context.Background(), 99, 0)
+		if !errors.Is(err, ErrBookNotFound) {
+			t.Errorf("expected ErrBookNotFound for non-existing ID, got %v", err)
+		}
+	})
 }
 
 func TestUpdateBookTDD(t *testing.T) {
-	store := NewBookStore()
-
-	updated := Books{
-		ID:     1,
-		Title:  "Updated Title",
-		Author: "Updated Author",
-		Genre:  "Drama",
-		Rating: 4.9,
-	}
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		original, err := store.GetBook(context.Background(), 1)
+		if err != nil {
+			t.Fatalf("GetBook() failed: %v", err)
+		}
 
-	result, ok := store.UpdateBook(updated)
-	if !ok {
-		t.Fatal("expected UpdateBook to succeed for existing ID")
-	}
+		updated := Books{
+			ID:     1,
+			Title:  "Updated Title",
+			Author: "Updated Author",
+			Genre:  "Drama",
+			Rating: 4.9,
+		}
 
-	if result.Title != "Updated Title" {
-		t.Error("title was not updated")
-	}
+		result, err := store.UpdateBook(context.Background(), updated)
+		if err != nil {
+			t.Fatalf("expected UpdateBook to succeed for existing ID, got %v", err)
+		}
 
-	// non-existing
-	_, ok = store.UpdateBook(Books{ID: 987})
-	if ok {
-		t.Error("expected UpdateBook to fail for non-existing ID")
-	}
+		if result.Title != "Updated Title" {
+			t.Error("title was not updated")
+		}
+
+		if !result.CreatedAt.Equal(original.CreatedAt) {
+			t.Error("expected UpdateBook to keep the original CreatedAt")
+		}
+
+		// non-existing
+		_, err = store.UpdateBook(context.Background(), Books{ID: 987})
+		if !errors.Is(err, ErrBookNotFound) {
+			t.Errorf("expected ErrBookNotFound for non-existing ID, got %v", err)
+		}
+	})
+}
+
+func TestBookVersioning(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		ctx := context.Background()
+
+		added, err := store.AddBook(ctx, Books{Title: "Dune", Author: "Frank Herbert", Genre: "Science Fiction", Rating: 4.6, Version: 42})
+		if err != nil {
+			t.Fatalf("AddBook() failed: %v", err)
+		}
+		if added.Version != 1 {
+			t.Fatalf("expected a new book to start at version 1, got %d", added.Version)
+		}
+
+		// matching version
+		edit := added
+		edit.Rating = 4.7
+		updated, err := store.UpdateBook(ctx, edit)
+		if err != nil {
+			t.Fatalf("expected conditional update at the current version to succeed, got %v", err)
+		}
+		if updated.Version != 2 {
+			t.Errorf("expected version 2 after update, got %d", updated.Version)
+		}
+
+		// stale version, the book must stay untouched
+		edit.Rating = 1
+		if _, err := store.UpdateBook(ctx, edit); !errors.Is(err, ErrVersionConflict) {
+			t.Errorf("expected ErrVersionConflict for a stale update, got %v", err)
+		}
+		current, err := store.GetBook(ctx, added.ID)
+		if err != nil {
+			t.Fatalf("GetBook() failed: %v", err)
+		}
+		if current.Rating != 4.7 || current.Version != 2 {
+			t.Errorf("stale update leaked through: %+v", current)
+		}
+
+		// version 0 is an unconditional write
+		edit.Version = 0
+		if updated, err = store.UpdateBook(ctx, edit); err != nil || updated.Version != 3 {
+			t.Errorf("expected unconditional update to reach version 3, got %d, %v", updated.Version, err)
+		}
+
+		if err := store.DeleteBook(ctx, added.ID, 2); !errors.Is(err, ErrVersionConflict) {
+			t.Errorf("expected ErrVersionConflict for a stale delete, got %v", err)
+		}
+		if err := store.DeleteBook(ctx, added.ID, 3); err != nil {
+			t.Errorf("expected delete at the current version to succeed, got %v", err)
+		}
+		if err := store.DeleteBook(ctx, added.ID, 3); !errors.Is(err, ErrBookNotFound) {
+			t.Errorf("expected ErrBookNotFound once deleted, got %v", err)
+		}
+	})
+}
+
+func TestAddBooksTDD(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		batch := []Books{
+			{Title: "Dune", Author: "Frank Herbert", Genre: "Science Fiction", Rating: 4.6},
+			{Title: "Emma", Author: "Jane Austen", Genre: "Fiction", Rating: 3.9},
+		}
+
+		added, err := store.AddBooks(context.Background(), batch)
+		if err != nil {
+			t.Fatalf("AddBooks() failed: %v", err)
+		}
+
+		if len(added) != 2 || added[0].ID == 0 || added[0].ID == added[1].ID {
+			t.Errorf("expected two distinct new IDs, got %+v", added)
+		}
+		for _, book := range added {
+			if book.Version != 1 || book.CreatedAt.IsZero() {
+				t.Errorf("expected version and timestamps to be set, got %+v", book)
+			}
+		}
+
+		if n := countBooks(t, store); n != 5 {
+			t.Errorf("expected 5 books after AddBooks, got %d", n)
+		}
+	})
+}
+
+func TestCountBooks(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		ctx := context.Background()
+
+		// the counts must agree with what loading every book would give
+		check := func(step string) {
+			t.Helper()
+			counts, err := store.CountBooks(ctx)
+			if err != nil {
+				t.Fatalf("%s: CountBooks() failed: %v", step, err)
+			}
+			books, err := store.GetAllBooks(ctx)
+			if err != nil {
+				t.Fatalf("%s: GetAllBooks() failed: %v", step, err)
+			}
+			trash, err := store.GetDeletedBooks(ctx)
+			if err != nil {
+				t.Fatalf("%s: GetDeletedBooks() failed: %v", step, err)
+			}
+			expected := BookCounts{Live: len(books), Trashed: len(trash), ByGenre: map[string]int{}}
+			for _, book := range books {
+				expected.ByGenre[book.Genre]++
+			}
+			if counts.Live != expected.Live || counts.Trashed != expected.Trashed || !maps.Equal(counts.ByGenre, expected.ByGenre) {
+				t.Errorf("%s: expected %+v, got %+v", step, expected, counts)
+			}
+		}
+
+		check("seeded")
+		// the store does not check genres, so one outside AllowedGenres is counted too
+		added, err := store.AddBook(ctx, Books{Title: "Odes", Author: "John Keats", Genre: "Poetry", Rating: 4.2})
+		if err != nil {
+			t.Fatalf("AddBook() failed: %v", err)
+		}
+		check("added")
+		added.Genre = "Drama"
+		if _, err := store.UpdateBook(ctx, added); err != nil {
+			t.Fatalf("UpdateBook() failed: %v", err)
+		}
+		check("genre changed")
+		if err := store.DeleteBook(ctx, 1, 0); err != nil {
+			t.Fatalf("DeleteBook() failed: %v", err)
+		}
+		check("deleted")
+		if _, err := store.RestoreBook(ctx, 1); err != nil {
+			t.Fatalf("RestoreBook() failed: %v", err)
+		}
+		check("restored")
+	})
 }
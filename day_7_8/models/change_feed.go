@@ -0,0 +1,198 @@
+package models
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// event types as seen by feed clients, who mirror the live catalogue
+const (
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventDeleted = "deleted"
+)
+
+type ChangeEvent struct {
+	ID uint64 `json:"id"`
+	// Epoch names the run of the server, as IDs start again at 1 on a restart
+	Epoch string `json:"epoch"`
+	Type  string `json:"type"`
+	// Operation is the history operation behind the event, e.g. a restore
+	// arrives as a created event
+	Operation string    `json:"operation"`
+	Actor     string    `json:"actor,omitempty"`
+	At        time.Time `json:"at"`
+	Book      Books     `json:"book"`
+	// PreviousGenre is set when the change moved the book out of a genre,
+	// so a client following that genre can drop it
+	PreviousGenre string `json:"previous_genre,omitempty"`
+}
+
+// ChangeFeed numbers the changes published by a store and fans them out to
+// subscribers. The newest events are kept in a bounded buffer so a client
+// that reconnects can pick up where it left off.
+type ChangeFeed struct {
+	mu          sync.Mutex
+	epoch       string
+	nextID      uint64
+	buffer      []ChangeEvent // ring, oldest at start
+	start       int
+	capacity    int
+	subscribers map[chan ChangeEvent]struct{}
+	closed      bool
+}
+
+func NewChangeFeed(capacity int) *ChangeFeed {
+	return &ChangeFeed{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		nextID:      1,
+		capacity:    capacity,
+		buffer:      make([]ChangeEvent, 0, capacity),
+		subscribers: map[chan ChangeEvent]struct{}{},
+	}
+}
+
+// Publish turns a history entry into an event. It never blocks: a subscriber
+// whose channel is full is dropped, and can resume from the buffer.
+func (f *ChangeFeed) Publish(entry HistoryEntry) {
+	event := ChangeEvent{Operation: entry.Operation, Actor: entry.Actor, At: entry.At}
+	switch entry.Operation {
+	case HistoryAdd, HistoryRestore:
+		event.Type = EventCreated
+	case HistoryUpdate, HistoryRevert:
+		event.Type = EventUpdated
+	case HistoryDelete:
+		event.Type = EventDeleted
+	default:
+		// a purged book already left the catalogue when it was deleted
+		return
+	}
+	event.Book = *entry.After
+	if entry.Before != nil && entry.Before.Genre != entry.After.Genre {
+		event.PreviousGenre = entry.Before.Genre
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return
+	}
+	event.ID = f.nextID
+	event.Epoch = f.epoch
+	f.nextID++
+
+	if len(f.buffer) < f.capacity {
+		f.buffer = append(f.buffer, event)
+	} else if f.capacity > 0 {
+		f.buffer[f.start] = event
+		f.start = (f.start + 1) % f.capacity
+	}
+
+	for events := range f.subscribers {
+		select {
+		case events <- event:
+		default:
+			delete(f.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// EventID is what a client hands back to resume after the event, the
+// epoch and the number joined by a dash.
+func (e ChangeEvent) EventID() string {
+	return e.Epoch + "-" + strconv.FormatUint(e.ID, 10)
+}
+
+var errBadEventID = errors.New("not an event ID")
+
+// ParseEventID reads an ID made by EventID. current is false for an ID
+// from an earlier run of the server, or from before IDs had an epoch;
+// those events cannot be told apart from this run's, so the client has to
+// reload.
+func (f *ChangeFeed) ParseEventID(eventID string) (id uint64, current bool, err error) {
+	epoch, number, found := strings.Cut(eventID, "-")
+	if !found {
+		epoch, number = "", eventID
+	}
+	if id, err = strconv.ParseUint(number, 10, 64); err != nil {
+		return 0, false, errBadEventID
+	}
+	return id, found && epoch == f.epoch, nil
+}
+
+// Subscribe returns the buffered events after lastID and a channel for the
+// ones still to come. complete is false when events after lastID have
+// already left the buffer, or lastID is unknown, so the client has missed
+// some and should reload. The channel is closed by cancel, or by the feed
+// if the subscriber falls more than size events behind.
+func (f *ChangeFeed) Subscribe(lastID uint64, size int) (replay []ChangeEvent, events <-chan ChangeEvent, cancel func(), complete bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// an ID this feed never handed out comes from before a restart
+	complete = lastID < f.nextID
+	if lastID+1 < f.nextID {
+		oldest := f.nextID
+		if len(f.buffer) > 0 {
+			oldest = f.buffer[f.start].ID
+		}
+		complete = lastID+1 >= oldest
+
+		for i := range f.buffer {
+			if event := f.buffer[(f.start+i)%len(f.buffer)]; event.ID > lastID {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	channel := make(chan ChangeEvent, size)
+	if f.closed {
+		close(channel)
+	} else {
+		f.subscribers[channel] = struct{}{}
+	}
+
+	cancel = func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, subscribed := f.subscribers[channel]; subscribed {
+			delete(f.subscribers, channel)
+			close(channel)
+		}
+	}
+
+	return replay, channel, cancel, complete
+}
+
+// LastID is the ID of the newest event, 0 before the first one.
+func (f *ChangeFeed) LastID() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.nextID - 1
+}
+
+// Close ends every subscription, and any made afterwards, so that streams
+// finish when the server shuts down.
+func (f *ChangeFeed) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	for events := range f.subscribers {
+		delete(f.subscribers, events)
+		close(events)
+	}
+}
+
+// Closed reports whether Close has been called, telling a subscriber whose
+// channel closed apart from one that fell behind.
+func (f *ChangeFeed) Closed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
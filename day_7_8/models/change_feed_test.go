@@ -0,0 +1,234 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func publishUpdates(feed *ChangeFeed, n int) {
+	for i := range n {
+		book := Books{ID: i + 1, Title: "Book"}
+		feed.Publish(HistoryEntry{BookID: book.ID, Operation: HistoryUpdate, After: &book})
+	}
+}
+
+func eventIDs(events []ChangeEvent) []uint64 {
+	ids := make([]uint64, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+	return ids
+}
+
+func TestChangeFeedReplay(t *testing.T) {
+	feed := NewChangeFeed(3)
+	publishUpdates(feed, 5)
+
+	testCases := []struct {
+		name     string
+		lastID   uint64
+		replay   []uint64
+		complete bool
+	}{
+		{name: "Up To Date", lastID: 5, complete: true},
+		{name: "Within Buffer", lastID: 3, replay: []uint64{4, 5}, complete: true},
+		{name: "Oldest Buffered", lastID: 2, replay: []uint64{3, 4, 5}, complete: true},
+		{name: "Fell Out Of Buffer", lastID: 1, replay: []uint64{3, 4, 5}, complete: false},
+		{name: "From Before A Restart", lastID: 42, complete: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			replay, _, cancel, complete := feed.Subscribe(tc.lastID, 1)
+			defer cancel()
+
+			if got := eventIDs(replay); !slices.Equal(got, tc.replay) {
+				t.Errorf("expected replay %v, got %v", tc.replay, got)
+			}
+			if complete != tc.complete {
+				t.Errorf("expected complete=%v, got %v", tc.complete, complete)
+			}
+		})
+	}
+}
+
+func TestChangeFeedEventIDs(t *testing.T) {
+	feed := NewChangeFeed(3)
+	publishUpdates(feed, 2)
+	replay, _, cancel, _ := feed.Subscribe(0, 1)
+	cancel()
+	current := replay[1].EventID()
+
+	// a restarted server numbers its events from 1 again
+	restarted := NewChangeFeed(3)
+	publishUpdates(restarted, 2)
+
+	testCases := []struct {
+		name    string
+		feed    *ChangeFeed
+		eventID string
+		id      uint64
+		current bool
+		invalid bool
+	}{
+		{name: "Same Run", feed: feed, eventID: current, id: 2, current: true},
+		{name: "Earlier Run", feed: restarted, eventID: current, id: 2},
+		{name: "Without Epoch", feed: feed, eventID: "2", id: 2},
+		{name: "Not A Number", feed: feed, eventID: "abc", invalid: true},
+		{name: "Bad Number", feed: feed, eventID: current + "x", invalid: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			id, current, err := tc.feed.ParseEventID(tc.eventID)
+			if (err != nil) != tc.invalid {
+				t.Fatalf("expected invalid=%v, got %v", tc.invalid, err)
+			}
+			if id != tc.id || current != tc.current {
+				t.Errorf("expected %d current=%v, got %d current=%v", tc.id, tc.current, id, current)
+			}
+		})
+	}
+}
+
+func TestChangeFeedDropsSlowSubscribers(t *testing.T) {
+	feed := NewChangeFeed(10)
+	_, slow, cancelSlow := subscribe(feed, 1)
+	defer cancelSlow()
+	_, fast, cancelFast := subscribe(feed, 10)
+	defer cancelFast()
+
+	publishUpdates(feed, 3)
+
+	var received []uint64
+	for event := range slow {
+		received = append(received, event.ID)
+	}
+	if !slices.Equal(received, []uint64{1}) {
+		t.Errorf("expected the slow subscriber to get event 1 and then be closed, got %v", received)
+	}
+	if len(fast) != 3 {
+		t.Errorf("expected the fast subscriber to have 3 events queued, got %d", len(fast))
+	}
+
+	// cancelling twice, or after the feed closed the channel, is harmless
+	cancelSlow()
+	cancelFast()
+	cancelFast()
+}
+
+func TestChangeFeedClose(t *testing.T) {
+	feed := NewChangeFeed(10)
+	_, before, cancel := subscribe(feed, 10)
+	defer cancel()
+
+	feed.Close()
+	if _, open := <-before; open || !feed.Closed() {
+		t.Fatalf("expected Close to end the subscription")
+	}
+
+	_, after, cancelAfter := subscribe(feed, 10)
+	defer cancelAfter()
+	publishUpdates(feed, 1)
+	if _, open := <-after; open {
+		t.Errorf("expected a subscription after Close to be closed at once")
+	}
+	if feed.LastID() != 0 {
+		t.Errorf("expected a closed feed to drop published changes, got last ID %d", feed.LastID())
+	}
+}
+
+func subscribe(feed *ChangeFeed, size int) ([]ChangeEvent, <-chan ChangeEvent, func()) {
+	replay, events, cancel, _ := feed.Subscribe(feed.LastID(), size)
+	return replay, events, cancel
+}
+
+func TestStoresPublishChanges(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		feed := NewChangeFeed(10)
+		store.OnChange(feed.Publish)
+		ctx := context.Background()
+
+		added, err := store.AddBook(ctx, Books{Title: "Emma", Author: "Jane Austen", Genre: "Fiction", Rating: 3.9})
+		if err != nil {
+			t.Fatalf("AddBook() failed: %v", err)
+		}
+		added.Version = 0
+		if _, err := store.UpdateBook(ctx, added); err != nil {
+			t.Fatalf("UpdateBook() failed: %v", err)
+		}
+		if err := store.DeleteBook(ctx, added.ID, 0); err != nil {
+			t.Fatalf("DeleteBook() failed: %v", err)
+		}
+
+		replay, _, cancel, _ := feed.Subscribe(0, 1)
+		defer cancel()
+
+		var types []string
+		for _, event := range replay {
+			types = append(types, event.Type)
+			if event.Book.ID != added.ID {
+				t.Errorf("expected events about book %d, got %d", added.ID, event.Book.ID)
+			}
+		}
+		if want := []string{EventCreated, EventUpdated, EventDeleted}; !slices.Equal(types, want) {
+			t.Errorf("expected %v, got %v", want, types)
+		}
+	})
+}
+
+func TestStoresPublishChangesInCommitOrder(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		var (
+			mu       sync.Mutex
+			versions []int
+		)
+		// a slow hook gives a later commit the chance to overtake
+		store.OnChange(func(change HistoryEntry) {
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			versions = append(versions, change.After.Version)
+		})
+
+		// unconditional updates to one book, so each commit bumps the version
+		var wg sync.WaitGroup
+		for i := range 20 {
+			wg.Go(func() {
+				book := Books{ID: 1, Title: fmt.Sprintf("Revision %d", i), Author: "Writer", Genre: "Drama", Rating: 3}
+				if _, err := store.UpdateBook(context.Background(), book); err != nil {
+					t.Errorf("UpdateBook() failed: %v", err)
+				}
+			})
+		}
+		wg.Wait()
+
+		if !slices.IsSorted(versions) || len(versions) != 20 {
+			t.Errorf("expected the hooks to hear 20 versions in order, got %v", versions)
+		}
+	})
+}
+
+func TestChangeFeedPreviousGenre(t *testing.T) {
+	feed := NewChangeFeed(10)
+	poem := Books{ID: 1, Title: "Odes", Genre: "Poetry"}
+	drama, retitled := poem, poem
+	drama.Genre = "Drama"
+	retitled.Title = "Odes and Sonnets"
+	feed.Publish(HistoryEntry{BookID: 1, Operation: HistoryAdd, After: &poem})
+	feed.Publish(HistoryEntry{BookID: 1, Operation: HistoryUpdate, Before: &poem, After: &retitled})
+	feed.Publish(HistoryEntry{BookID: 1, Operation: HistoryUpdate, Before: &poem, After: &drama})
+
+	replay, _, cancel, _ := feed.Subscribe(0, 1)
+	defer cancel()
+
+	var previous []string
+	for _, event := range replay {
+		previous = append(previous, event.PreviousGenre)
+	}
+	if want := []string{"", "", "Poetry"}; !slices.Equal(previous, want) {
+		t.Errorf("expected previous genres %q, got %q", want, previous)
+	}
+}
@@ -0,0 +1,342 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+	// a batch is a single log line, so it is replayed completely or not at all
+	opPutBatch = "put_batch"
+)
+
+const (
+	snapshotFileName = "books.snapshot.json"
+	logFileName      = "books.log"
+
+	// after this many appended entries the next write folds the log into a
+	// fresh snapshot, so startup replay stays short
+	compactThreshold = 1000
+)
+
+type journal interface {
+	record(entry journalEntry) error
+}
+
+type journalEntry struct {
+	Op    string  `json:"op"`
+	Book  Books   `json:"book,omitzero"`
+	Batch []Books `json:"batch,omitempty"`
+	// History holds the audit entries for the write, so they survive with it
+	History []HistoryEntry `json:"history,omitempty"`
+}
+
+type snapshot struct {
+	Books []Books `json:"books"`
+	// NextID keeps the ID sequence past books that were purged
+	NextID  int            `json:"next_id,omitempty"`
+	History []HistoryEntry `json:"history,omitempty"`
+}
+
+// FileStore is a LibraryStore whose writes go to an append-only JSON log
+// before they reach the map. The log is periodically compacted into a
+// snapshot, and the two are replayed on startup.
+type FileStore struct {
+	*LibraryStore
+
+	dir        string
+	logFile    *os.File
+	logEntries int
+	// logSize is the offset just past the last acknowledged entry
+	logSize int64
+	// failed is set when a failed write could not be cut back off the log.
+	// The log no longer matches the map, so every later write is refused.
+	failed error
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create store directory: %w", err)
+	}
+
+	store := &FileStore{
+		LibraryStore: newLibraryStore(nil),
+		dir:          dir,
+	}
+
+	fresh, err := store.load()
+	if err != nil {
+		return nil, err
+	}
+
+	// a brand new store starts empty; SeedStore can fill it from a fixture
+	if fresh {
+		if err := store.writeSnapshot(); err != nil {
+			return nil, err
+		}
+	}
+
+	store.logFile, err = os.OpenFile(store.path(logFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open store log: %w", err)
+	}
+	info, err := store.logFile.Stat()
+	if err != nil {
+		store.logFile.Close()
+		return nil, fmt.Errorf("stat store log: %w", err)
+	}
+	store.logSize = info.Size()
+	store.journal = store
+
+	return store, nil
+}
+
+// Close folds the log into the snapshot and releases the log file.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.logFile == nil {
+		return nil
+	}
+
+	err := s.compact()
+	if closeErr := s.logFile.Close(); err == nil {
+		err = closeErr
+	}
+	s.logFile = nil
+
+	return err
+}
+
+func (s *FileStore) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// load reads the snapshot and replays the log on top of it. It reports
+// whether neither file existed yet.
+func (s *FileStore) load() (bool, error) {
+	snapshotFound, err := s.loadSnapshot()
+	if err != nil {
+		return false, err
+	}
+
+	logFound, err := s.replayLog()
+	if err != nil {
+		return false, err
+	}
+
+	return !snapshotFound && !logFound, nil
+}
+
+func (s *FileStore) loadSnapshot() (bool, error) {
+	data, err := os.ReadFile(s.path(snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("read snapshot: %w", err)
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return false, fmt.Errorf("decode snapshot: %w", err)
+	}
+
+	for _, book := range snap.Books {
+		s.put(book)
+	}
+	s.nextID = max(s.nextID, snap.NextID)
+	for _, entry := range snap.History {
+		s.history[entry.BookID] = append(s.history[entry.BookID], entry)
+	}
+
+	return true, nil
+}
+
+// replayLog applies the log on top of the snapshot. A last line cut short
+// by a crash is cut off the file as well, or the next append would be
+// written onto the end of it and lost on the following start.
+func (s *FileStore) replayLog() (bool, error) {
+	file, err := os.OpenFile(s.path(logFileName), os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("open store log: %w", err)
+	}
+	defer file.Close()
+
+	var (
+		reader = bufio.NewReaderSize(file, 64*1024)
+		line   = 0
+		// complete is the offset just past the last entry that was replayed
+		complete int64
+		offset   int64
+		torn     bool
+	)
+
+	for {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return true, fmt.Errorf("read store log: %w", err)
+		}
+		if len(data) == 0 {
+			break
+		}
+		line++
+		offset += int64(len(data))
+
+		// only the final line may be damaged, that is a write cut short by a crash
+		if torn {
+			return true, fmt.Errorf("corrupt store log entry at line %d", line-1)
+		}
+
+		// record writes the newline with the entry, so a line without one
+		// was never acknowledged, even if it happens to be valid JSON
+		trimmed := bytes.TrimSuffix(data, []byte("\n"))
+		var entry journalEntry
+		if len(trimmed) == len(data) || json.Unmarshal(trimmed, &entry) != nil {
+			if len(bytes.TrimSpace(data)) != 0 {
+				torn = true
+			}
+			continue
+		}
+
+		s.apply(entry)
+		s.logEntries++
+		complete = offset
+	}
+
+	if torn || offset != complete {
+		if err := file.Truncate(complete); err != nil {
+			return true, fmt.Errorf("truncate torn store log: %w", err)
+		}
+		if err := file.Sync(); err != nil {
+			return true, fmt.Errorf("sync store log: %w", err)
+		}
+	}
+
+	return true, nil
+}
+
+// record is called by LibraryStore with the write lock held. A write that
+// fails is cut back off the log, so it is neither applied on the next start
+// nor glued onto the front of the next entry.
+func (s *FileStore) record(entry journalEntry) error {
+	if s.logFile == nil {
+		return ErrStoreClosed
+	}
+	if s.failed != nil {
+		return fmt.Errorf("store log is unusable: %w", s.failed)
+	}
+
+	if s.logEntries >= compactThreshold {
+		if err := s.compact(); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	line := append(data, '\n')
+	if _, err := s.logFile.Write(line); err != nil {
+		return s.rollback(fmt.Errorf("append store log: %w", err))
+	}
+	if err := s.logFile.Sync(); err != nil {
+		return s.rollback(fmt.Errorf("sync store log: %w", err))
+	}
+	s.logEntries++
+	s.logSize += int64(len(line))
+
+	return nil
+}
+
+// rollback cuts a failed write off the log and returns err. If even that
+// fails the store is marked failed.
+func (s *FileStore) rollback(err error) error {
+	if truncErr := s.logFile.Truncate(s.logSize); truncErr != nil {
+		s.failed = truncErr
+		return errors.Join(err, truncErr)
+	}
+	if syncErr := s.logFile.Sync(); syncErr != nil {
+		s.failed = syncErr
+		return errors.Join(err, syncErr)
+	}
+	return err
+}
+
+func (s *FileStore) compact() error {
+	if err := s.writeSnapshot(); err != nil {
+		return err
+	}
+
+	if err := s.logFile.Truncate(0); err != nil {
+		return fmt.Errorf("truncate store log: %w", err)
+	}
+	s.logEntries = 0
+	s.logSize = 0
+
+	return nil
+}
+
+func (s *FileStore) writeSnapshot() error {
+	snap := snapshot{Books: make([]Books, 0, len(s.Books)), NextID: s.nextID, History: s.allHistory()}
+	for _, book := range s.Books {
+		snap.Books = append(snap.Books, book)
+	}
+	slices.SortFunc(snap.Books, func(a, b Books) int { return a.ID - b.ID })
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temp file and rename so a crash never leaves half a snapshot
+	tmp, err := os.CreateTemp(s.dir, snapshotFileName+".*")
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path(snapshotFileName)); err != nil {
+		return fmt.Errorf("replace snapshot: %w", err)
+	}
+
+	// the rename is only durable once the directory is synced, and compact
+	// empties the log straight after
+	dir, err := os.Open(s.dir)
+	if err != nil {
+		return fmt.Errorf("open store directory: %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("sync store directory: %w", err)
+	}
+
+	return nil
+}
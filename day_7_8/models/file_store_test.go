@@ -0,0 +1,250 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func reopenFileStore(t *testing.T, store *FileStore, dir string) *FileStore {
+	if store != nil {
+		if err := store.Close(); err != nil {
+			t.Fatalf("Close() failed: %v", err)
+		}
+	}
+
+	reopened, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore() failed: %v", err)
+	}
+	t.Cleanup(func() { reopened.Close() })
+	seedDemo(t, reopened)
+
+	return reopened
+}
+
+func TestFileStoreSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	store := reopenFileStore(t, nil, dir)
+	ctx := context.Background()
+
+	added, err := store.AddBook(ctx, Books{Title: "Dune", Author: "Frank Herbert", Genre: "Science Fiction", Rating: 4.6})
+	if err != nil {
+		t.Fatalf("AddBook() failed: %v", err)
+	}
+	if err := store.DeleteBook(ctx, 1, 0); err != nil {
+		t.Fatalf("DeleteBook() failed: %v", err)
+	}
+
+	store = reopenFileStore(t, store, dir)
+
+	if n := countBooks(t, store); n != 3 {
+		t.Fatalf("expected 3 books after restart, got %d", n)
+	}
+	if _, err := store.GetBook(ctx, added.ID); err != nil {
+		t.Errorf("expected added book to survive restart, got %v", err)
+	}
+	if _, err := store.GetBook(ctx, 1); err == nil {
+		t.Error("expected deleted book to stay deleted after restart")
+	}
+}
+
+func TestFileStoreBatchSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	store := reopenFileStore(t, nil, dir)
+
+	_, err := store.AddBooks(context.Background(), []Books{
+		{Title: "Dune", Author: "Frank Herbert", Genre: "Science Fiction", Rating: 4.6},
+		{Title: "Emma", Author: "Jane Austen", Genre: "Fiction", Rating: 3.9},
+	})
+	if err != nil {
+		t.Fatalf("AddBooks() failed: %v", err)
+	}
+
+	// reopen without Close so the batch has to come back from the log
+	replayed, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore() failed: %v", err)
+	}
+	defer replayed.Close()
+
+	if n := countBooks(t, replayed); n != 5 {
+		t.Errorf("expected 5 books after replaying the batch, got %d", n)
+	}
+
+	store.Close()
+	if _, err := store.AddBooks(context.Background(), []Books{{Title: "Late"}}); !errors.Is(err, ErrStoreClosed) {
+		t.Errorf("expected ErrStoreClosed from a closed store, got %v", err)
+	}
+}
+
+func TestFileStoreReplaysLogWithoutClose(t *testing.T) {
+	dir := t.TempDir()
+	store := reopenFileStore(t, nil, dir)
+
+	if _, err := store.UpdateBook(context.Background(), Books{ID: 2, Title: "Go Set a Watchman", Author: "Harper Lee", Genre: "Fiction", Rating: 3.9}); err != nil {
+		t.Fatalf("UpdateBook() failed: %v", err)
+	}
+
+	// simulate a crash: the snapshot is stale and only the log has the update
+	crashed, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore() failed: %v", err)
+	}
+	defer crashed.Close()
+
+	book, err := crashed.GetBook(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("GetBook() failed: %v", err)
+	}
+	if book.Title != "Go Set a Watchman" {
+		t.Errorf("expected update to be replayed from the log, got %q", book.Title)
+	}
+}
+
+func TestFileStoreIgnoresTornLastEntry(t *testing.T) {
+	dir := t.TempDir()
+	store := reopenFileStore(t, nil, dir)
+	store.Close()
+
+	logPath := filepath.Join(dir, logFileName)
+	torn := `{"op":"put","book":{"id":7,"title":"Half wri`
+	if err := os.WriteFile(logPath, []byte(torn), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store = reopenFileStore(t, nil, dir)
+	if n := countBooks(t, store); n != 3 {
+		t.Errorf("expected torn entry to be ignored, got %d books", n)
+	}
+
+	// the torn bytes are cut off, so the next entry is not appended to them
+	added, err := store.AddBook(context.Background(), Books{Title: "Emma", Author: "Jane Austen", Genre: "Fiction", Rating: 3.9})
+	if err != nil {
+		t.Fatalf("AddBook() failed: %v", err)
+	}
+	crashed, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore() after the torn entry failed: %v", err)
+	}
+	if _, err := crashed.GetBook(context.Background(), added.ID); err != nil {
+		t.Errorf("expected the book added after the torn entry to survive, got %v", err)
+	}
+	crashed.Close()
+	store.Close()
+
+	if err := os.WriteFile(logPath, []byte(torn+"\n"+`{"op":"delete","book":{"id":1}}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileStore(dir); err == nil {
+		t.Error("expected a corrupt entry in the middle of the log to be reported")
+	}
+}
+
+func TestFileStoreCompactsLog(t *testing.T) {
+	dir := t.TempDir()
+	store := reopenFileStore(t, nil, dir)
+	ctx := context.Background()
+
+	// the seed is the first log entry, so this goes one past the threshold
+	for range compactThreshold {
+		if _, err := store.UpdateBook(ctx, Books{ID: 3, Title: "1984", Author: "George Orwell", Genre: "Dystopian", Rating: 4.4}); err != nil {
+			t.Fatalf("UpdateBook() failed: %v", err)
+		}
+	}
+
+	if store.logEntries != 1 {
+		t.Errorf("expected log to be compacted down to 1 entry, got %d", store.logEntries)
+	}
+
+	store = reopenFileStore(t, store, dir)
+	if n := countBooks(t, store); n != 3 {
+		t.Errorf("expected 3 books after compaction and restart, got %d", n)
+	}
+}
+
+func TestFileStoreRollsBackFailedWrites(t *testing.T) {
+	dir := t.TempDir()
+	store := reopenFileStore(t, nil, dir)
+	ctx := context.Background()
+	emma := Books{Title: "Emma", Author: "Jane Austen", Genre: "Fiction", Rating: 3.9}
+
+	// a write cut short part way is taken back off the log
+	if _, err := store.logFile.Write([]byte(`{"op":"put","book":{"id":9,"ti`)); err != nil {
+		t.Fatal(err)
+	}
+	store.rollback(errors.New("disk full"))
+	added, err := store.AddBook(ctx, emma)
+	if err != nil {
+		t.Fatalf("AddBook() failed: %v", err)
+	}
+	crashed, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore() after a failed write failed: %v", err)
+	}
+	if _, err := crashed.GetBook(ctx, added.ID); err != nil {
+		t.Errorf("expected the book added after the failed write to survive, got %v", err)
+	}
+	crashed.Close()
+
+	// a log that cannot even be cut back refuses every later write
+	logFile := store.logFile
+	store.logFile, err = os.Open(filepath.Join(dir, logFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddBook(ctx, emma); err == nil {
+		logFile.Close()
+		t.Fatal("expected AddBook() to fail on a read-only log")
+	}
+	store.logFile.Close()
+	store.logFile = logFile
+	if _, err := store.AddBook(ctx, emma); err == nil {
+		t.Error("expected AddBook() to keep failing once the log is unusable")
+	}
+	if n := countBooks(t, store); n != 4 {
+		t.Errorf("expected the failed writes to leave 4 books, got %d", n)
+	}
+}
+
+func TestFileStoreReplaysLogAlreadyInSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	store := reopenFileStore(t, nil, dir)
+	ctx := context.Background()
+
+	book := Books{ID: 2, Title: "Go Set a Watchman", Author: "Harper Lee", Genre: "Fiction", Rating: 3.9}
+	if _, err := store.UpdateBook(ctx, book); err != nil {
+		t.Fatalf("UpdateBook() failed: %v", err)
+	}
+	// simulate a crash inside compact, after the snapshot but before the log is emptied
+	if err := store.writeSnapshot(); err != nil {
+		t.Fatalf("writeSnapshot() failed: %v", err)
+	}
+
+	crashed, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore() failed: %v", err)
+	}
+	defer crashed.Close()
+
+	history, err := crashed.GetBookHistory(ctx, 2)
+	if err != nil {
+		t.Fatalf("GetBookHistory() failed: %v", err)
+	}
+	for i, entry := range history {
+		if entry.Revision != i+1 {
+			t.Fatalf("expected revisions 1 to %d once each, got revision %d at %d", len(history), entry.Revision, i)
+		}
+	}
+	if len(history) != 2 {
+		t.Errorf("expected the add and the update, got %d entries", len(history))
+	}
+	if _, err := crashed.UpdateBook(ctx, Books{ID: 2, Title: "Mockingbird", Author: "Harper Lee", Genre: "Fiction", Rating: 4}); err != nil {
+		t.Fatalf("UpdateBook() failed: %v", err)
+	}
+	if history, _ := crashed.GetBookHistory(ctx, 2); history[len(history)-1].Revision != 3 {
+		t.Errorf("expected the next update to be revision 3, got %d", history[len(history)-1].Revision)
+	}
+}
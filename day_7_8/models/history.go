@@ -0,0 +1,141 @@
+package models
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// ErrRevisionNotFound means a book has no revision with that number, or the
+// revision has no state to go back to
+var ErrRevisionNotFound = errors.New("revision not found")
+
+const (
+	HistoryAdd     = "add"
+	HistoryUpdate  = "update"
+	HistoryDelete  = "delete"
+	HistoryRestore = "restore"
+	HistoryRevert  = "revert"
+	HistoryPurge   = "purge"
+)
+
+// HistoryEntry is one change to a book. Entries are never edited or removed,
+// not even when the book itself is purged.
+type HistoryEntry struct {
+	BookID    int       `json:"book_id"`
+	Revision  int       `json:"revision"`
+	Operation string    `json:"operation"`
+	Actor     string    `json:"actor,omitempty"`
+	At        time.Time `json:"at"`
+	// Before is nil for an add, After is nil for a purge
+	Before *Books `json:"before,omitempty"`
+	After  *Books `json:"after,omitempty"`
+}
+
+type actorKey struct{}
+
+// WithActor tags a context with who is making the change, so the stores can
+// put it in the history.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func ActorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// newHistoryEntry leaves the revision to the store, which numbers it
+// together with the write.
+func newHistoryEntry(ctx context.Context, operation string, at time.Time, before, after *Books) HistoryEntry {
+	entry := HistoryEntry{Operation: operation, Actor: ActorFrom(ctx), At: at, Before: before, After: after}
+	if after != nil {
+		entry.BookID = after.ID
+	} else {
+		entry.BookID = before.ID
+	}
+	return entry
+}
+
+// revertTarget finds the state a book was left in by a revision.
+func revertTarget(entries []HistoryEntry, revision int) (Books, error) {
+	index := slices.IndexFunc(entries, func(entry HistoryEntry) bool { return entry.Revision == revision })
+	if index < 0 || entries[index].After == nil {
+		return Books{}, fmt.Errorf("%w: %d", ErrRevisionNotFound, revision)
+	}
+	return *entries[index].After, nil
+}
+
+// reverted copies the catalogue fields of target onto the current book; the
+// ID, version and timestamps stay the store's business.
+func reverted(current, target Books) Books {
+	current.Title = target.Title
+	current.Author = target.Author
+	current.Genre = target.Genre
+	current.Rating = target.Rating
+	return current
+}
+
+// GetBookHistory lists every change to a book, oldest first. It works for
+// purged books too, as long as they ever had a history.
+func (ls *LibraryStore) GetBookHistory(ctx context.Context, bookID int) ([]HistoryEntry, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	entries, known := ls.history[bookID]
+	if _, exists := ls.Books[bookID]; !exists && !known {
+		return nil, ErrBookNotFound
+	}
+
+	return append([]HistoryEntry{}, entries...), nil
+}
+
+// RevertBook puts a book's title, author, genre and rating back to how a
+// revision left them. The revert is itself recorded as a new revision.
+func (ls *LibraryStore) RevertBook(ctx context.Context, bookID, revision int) (Books, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	current, exists := ls.live(bookID)
+	if !exists {
+		return Books{}, ErrBookNotFound
+	}
+	target, err := revertTarget(ls.history[bookID], revision)
+	if err != nil {
+		return Books{}, err
+	}
+
+	book := reverted(current, target)
+	book.Version++
+	book.UpdatedAt = time.Now()
+	if err := ls.commit(journalEntry{Op: opPut, Book: book, History: []HistoryEntry{
+		ls.historyEntry(ctx, HistoryRevert, book.UpdatedAt, &current, &book),
+	}}); err != nil {
+		return Books{}, err
+	}
+
+	return book, nil
+}
+
+// historyEntry numbers the next revision of a book. It must be called with
+// ls.mu held for writing.
+func (ls *LibraryStore) historyEntry(ctx context.Context, operation string, at time.Time, before, after *Books) HistoryEntry {
+	entry := newHistoryEntry(ctx, operation, at, before, after)
+	entry.Revision = len(ls.history[entry.BookID]) + 1
+	return entry
+}
+
+// allHistory flattens the history for a snapshot, ordered by book and revision.
+func (ls *LibraryStore) allHistory() []HistoryEntry {
+	var entries []HistoryEntry
+	for _, bookEntries := range ls.history {
+		entries = append(entries, bookEntries...)
+	}
+	slices.SortFunc(entries, func(a, b HistoryEntry) int {
+		return cmp.Or(cmp.Compare(a.BookID, b.BookID), cmp.Compare(a.Revision, b.Revision))
+	})
+	return entries
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func operations(entries []HistoryEntry) []string {
+	ops := make([]string, len(entries))
+	for i, entry := range entries {
+		ops[i] = entry.Operation
+	}
+	return ops
+}
+
+func TestBookHistory(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		ctx := WithActor(context.Background(), "librarian")
+
+		added, err := store.AddBook(ctx, Books{Title: "Emma", Author: "Jane Austen", Genre: "Fiction", Rating: 3.9})
+		if err != nil {
+			t.Fatalf("AddBook() failed: %v", err)
+		}
+		edited := added
+		edited.Title, edited.Version = "Emma (Annotated)", 0
+		if _, err := store.UpdateBook(ctx, edited); err != nil {
+			t.Fatalf("UpdateBook() failed: %v", err)
+		}
+		if err := store.DeleteBook(ctx, added.ID, 0); err != nil {
+			t.Fatalf("DeleteBook() failed: %v", err)
+		}
+		if _, err := store.RestoreBook(ctx, added.ID); err != nil {
+			t.Fatalf("RestoreBook() failed: %v", err)
+		}
+
+		history, err := store.GetBookHistory(ctx, added.ID)
+		if err != nil {
+			t.Fatalf("GetBookHistory() failed: %v", err)
+		}
+		if want := []string{HistoryAdd, HistoryUpdate, HistoryDelete, HistoryRestore}; !slices.Equal(operations(history), want) {
+			t.Fatalf("expected operations %v, got %v", want, operations(history))
+		}
+		for i, entry := range history {
+			if entry.Revision != i+1 || entry.Actor != "librarian" || entry.BookID != added.ID || entry.At.IsZero() {
+				t.Errorf("unexpected entry %d: %+v", i, entry)
+			}
+		}
+		if history[0].Before != nil || history[0].After.Title != "Emma" {
+			t.Errorf("expected the add to have only an after snapshot, got %+v", history[0])
+		}
+		if history[1].Before.Title != "Emma" || history[1].After.Title != "Emma (Annotated)" {
+			t.Errorf("expected before/after titles on the update, got %+v", history[1])
+		}
+
+		reverted, err := store.RevertBook(ctx, added.ID, 1)
+		if err != nil {
+			t.Fatalf("RevertBook() failed: %v", err)
+		}
+		if reverted.Title != "Emma" || reverted.Version != 5 {
+			t.Errorf("expected the revision 1 title at version 5, got %+v", reverted)
+		}
+		if book, _ := store.GetBook(ctx, added.ID); book.Title != "Emma" {
+			t.Errorf("expected the revert to be stored, got %q", book.Title)
+		}
+		if history, _ := store.GetBookHistory(ctx, added.ID); len(history) != 5 || history[4].Operation != HistoryRevert {
+			t.Errorf("expected the revert to be recorded as revision 5, got %v", operations(history))
+		}
+
+		if _, err := store.RevertBook(ctx, added.ID, 42); !errors.Is(err, ErrRevisionNotFound) {
+			t.Errorf("expected ErrRevisionNotFound, got %v", err)
+		}
+		if _, err := store.GetBookHistory(ctx, 999); !errors.Is(err, ErrBookNotFound) {
+			t.Errorf("expected ErrBookNotFound for an unknown book, got %v", err)
+		}
+	})
+}
+
+func TestHistoryOutlivesPurge(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		ctx := context.Background()
+
+		if err := store.DeleteBook(ctx, 1, 0); err != nil {
+			t.Fatalf("DeleteBook() failed: %v", err)
+		}
+		if _, err := store.PurgeDeletedBooks(ctx, time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("PurgeDeletedBooks() failed: %v", err)
+		}
+
+		history, err := store.GetBookHistory(ctx, 1)
+		if err != nil {
+			t.Fatalf("GetBookHistory() failed: %v", err)
+		}
+		if want := []string{HistoryAdd, HistoryDelete, HistoryPurge}; !slices.Equal(operations(history), want) {
+			t.Fatalf("expected operations %v, got %v", want, operations(history))
+		}
+		if history[2].After != nil || history[2].Before.Title != "The Great Gatsby" {
+			t.Errorf("expected the purge to keep the last state as before, got %+v", history[2])
+		}
+	})
+}
+
+func TestFileStoreHistorySurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	store := reopenFileStore(t, nil, dir)
+	ctx := WithActor(context.Background(), "librarian")
+
+	if _, err := store.UpdateBook(ctx, Books{ID: 1, Title: "Gatsby", Author: "F. Scott Fitzgerald", Genre: "Fiction", Rating: 4}); err != nil {
+		t.Fatalf("UpdateBook() failed: %v", err)
+	}
+	// one write lands in the snapshot on Close, the next only in the log
+	store = reopenFileStore(t, store, dir)
+	if err := store.DeleteBook(ctx, 1, 0); err != nil {
+		t.Fatalf("DeleteBook() failed: %v", err)
+	}
+
+	// simulate a crash so the delete only exists in the log
+	crashed, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore() failed: %v", err)
+	}
+	defer crashed.Close()
+
+	history, err := crashed.GetBookHistory(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetBookHistory() failed: %v", err)
+	}
+	if want := []string{HistoryAdd, HistoryUpdate, HistoryDelete}; !slices.Equal(operations(history), want) || history[2].Actor != "librarian" {
+		t.Errorf("expected %v by librarian after restart, got %+v", want, history)
+	}
+}
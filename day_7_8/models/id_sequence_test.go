@@ -0,0 +1,189 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIDsAreNeverReused(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		ctx := context.Background()
+
+		// the bug this guards against: delete 2 of 3, then add, used to hand out 3 again
+		if err := store.DeleteBook(ctx, 2, 0); err != nil {
+			t.Fatalf("DeleteBook() failed: %v", err)
+		}
+		if err := store.DeleteBook(ctx, 3, 0); err != nil {
+			t.Fatalf("DeleteBook() failed: %v", err)
+		}
+		if _, err := store.PurgeDeletedBooks(ctx, time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("PurgeDeletedBooks() failed: %v", err)
+		}
+
+		added, err := store.AddBook(ctx, Books{Title: "Emma", Author: "Jane Austen", Genre: "Fiction", Rating: 3.9})
+		if err != nil {
+			t.Fatalf("AddBook() failed: %v", err)
+		}
+		if added.ID != 4 {
+			t.Errorf("expected ID 4 after purging 2 and 3, got %d", added.ID)
+		}
+	})
+}
+
+func TestDuplicateIDsAreRejected(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		ctx := context.Background()
+		book := Books{Title: "Emma", Author: "Jane Austen", Genre: "Fiction", Rating: 3.9}
+
+		book.ID = 1
+		if _, err := store.AddBook(ctx, book); !errors.Is(err, ErrDuplicateID) {
+			t.Errorf("expected ErrDuplicateID for a live ID, got %v", err)
+		}
+
+		if err := store.DeleteBook(ctx, 2, 0); err != nil {
+			t.Fatalf("DeleteBook() failed: %v", err)
+		}
+		book.ID = 2
+		if _, err := store.AddBook(ctx, book); !errors.Is(err, ErrDuplicateID) {
+			t.Errorf("expected ErrDuplicateID for a trashed ID, got %v", err)
+		}
+
+		// a purged book's ID stays retired, or the newcomer would take over its history
+		if _, err := store.PurgeDeletedBooks(ctx, time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("PurgeDeletedBooks() failed: %v", err)
+		}
+		if _, err := store.AddBook(ctx, book); !errors.Is(err, ErrDuplicateID) {
+			t.Errorf("expected ErrDuplicateID for a purged ID, got %v", err)
+		}
+		if _, err := store.AddBooks(ctx, []Books{{ID: 2, Title: "A", Author: "B", Genre: "Drama", Rating: 3}}); !errors.Is(err, ErrDuplicateID) {
+			t.Errorf("expected ErrDuplicateID for a purged ID in a batch, got %v", err)
+		}
+
+		_, err := store.AddBooks(ctx, []Books{{ID: 10, Title: "A", Author: "B", Genre: "Drama"}, {ID: 10, Title: "C", Author: "D", Genre: "Drama"}})
+		if !errors.Is(err, ErrDuplicateID) {
+			t.Errorf("expected ErrDuplicateID for an ID repeated within a batch, got %v", err)
+		}
+
+		// a client-chosen ID moves the sequence past it
+		book.ID = 20
+		if _, err := store.AddBook(ctx, book); err != nil {
+			t.Fatalf("AddBook() with a free ID failed: %v", err)
+		}
+		book.ID = 0
+		added, err := store.AddBook(ctx, book)
+		if err != nil {
+			t.Fatalf("AddBook() failed: %v", err)
+		}
+		if added.ID != 21 {
+			t.Errorf("expected the next ID to be 21, got %d", added.ID)
+		}
+	})
+}
+
+func TestFileStoreSequenceSurvivesCompaction(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "books")
+	store := reopenFileStore(t, nil, dir)
+	ctx := context.Background()
+
+	if err := store.DeleteBook(ctx, 3, 0); err != nil {
+		t.Fatalf("DeleteBook() failed: %v", err)
+	}
+	if _, err := store.PurgeDeletedBooks(ctx, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("PurgeDeletedBooks() failed: %v", err)
+	}
+
+	// Close compacts, so the purged book is in neither the snapshot nor the log
+	store = reopenFileStore(t, store, dir)
+	added, err := store.AddBook(ctx, Books{Title: "Emma", Author: "Jane Austen", Genre: "Fiction", Rating: 3.9})
+	if err != nil {
+		t.Fatalf("AddBook() failed: %v", err)
+	}
+	if added.ID != 4 {
+		t.Errorf("expected ID 4 after a restart, got %d", added.ID)
+	}
+}
+
+// TestLibraryStoreConcurrentWrites is meant to be run with -race. Every
+// worker adds, reads, updates and deletes at the same time as the others.
+func TestLibraryStoreConcurrentWrites(t *testing.T) {
+	const (
+		workers = 16
+		rounds  = 50
+	)
+
+	for name, newRepository := range repositoryFactories {
+		if name == "sqlite" {
+			// SQLite serialises writes itself, this is about the map stores
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			store := newRepository(t)
+			ctx := context.Background()
+
+			var (
+				wg   sync.WaitGroup
+				mu   sync.Mutex
+				ids  = map[int]bool{}
+				kept = 0
+				errs = make(chan error, workers)
+			)
+			for worker := range workers {
+				wg.Go(func() {
+					for i := range rounds {
+						added, err := store.AddBook(ctx, Books{Title: fmt.Sprintf("Book %d-%d", worker, i), Author: "Writer", Genre: "Drama", Rating: 3})
+						if err != nil {
+							errs <- err
+							return
+						}
+
+						mu.Lock()
+						duplicate := ids[added.ID]
+						ids[added.ID] = true
+						mu.Unlock()
+						if duplicate {
+							errs <- fmt.Errorf("ID %d handed out twice", added.ID)
+							return
+						}
+
+						if _, err := store.GetBook(ctx, added.ID); err != nil {
+							errs <- err
+							return
+						}
+
+						// only this worker touches the book, so its version must still match
+						updated, err := store.UpdateBook(ctx, Books{ID: added.ID, Version: added.Version, Title: "Revised", Author: "Writer", Genre: "Drama", Rating: 4})
+						if err != nil {
+							errs <- err
+							return
+						}
+
+						if i%2 == 0 {
+							if err := store.DeleteBook(ctx, added.ID, updated.Version); err != nil {
+								errs <- err
+								return
+							}
+							continue
+						}
+						mu.Lock()
+						kept++
+						mu.Unlock()
+					}
+				})
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				t.Error(err)
+			}
+			if n := countBooks(t, store); n != 3+kept {
+				t.Errorf("expected %d live books, got %d", 3+kept, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package models
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+const (
+	SortByID        = "id"
+	SortByTitle     = "title"
+	SortByAuthor    = "author"
+	SortByRating    = "rating"
+	SortByCreatedAt = "created_at"
+)
+
+var sortFields = []string{SortByID, SortByTitle, SortByAuthor, SortByRating, SortByCreatedAt}
+
+// BookQuery is the query-options counterpart of GetAllBooks. A zero
+// BookQuery returns every book ordered by ID. Offset and Cursor are
+// alternative ways of paging and cannot be combined.
+type BookQuery struct {
+	Limit  int
+	Offset int
+	Cursor string
+
+	SortBy string
+	Desc   bool
+
+	Genre     string
+	Author    string
+	MinRating *float64
+	MaxRating *float64
+}
+
+type BookPage struct {
+	Books []Books
+	// Total counts every book matching the filters, not just this page
+	Total int
+	// NextCursor is empty on the last page
+	NextCursor string
+}
+
+// bookCursor pins a position in one particular ordering, so it is rejected
+// if the client changes the sort between pages.
+type bookCursor struct {
+	SortBy string `json:"s"`
+	Desc   bool   `json:"d"`
+	Key    any    `json:"k"`
+	ID     int    `json:"i"`
+}
+
+func (q *BookQuery) normalize() error {
+	if q.SortBy == "" {
+		q.SortBy = SortByID
+	}
+	if !slices.Contains(sortFields, q.SortBy) {
+		return fmt.Errorf("%w: cannot sort by %q", ErrInvalidQuery, q.SortBy)
+	}
+	if q.Limit < 0 || q.Offset < 0 {
+		return fmt.Errorf("%w: limit and offset must not be negative", ErrInvalidQuery)
+	}
+	if q.Offset > 0 && q.Cursor != "" {
+		return fmt.Errorf("%w: offset and cursor cannot be combined", ErrInvalidQuery)
+	}
+	if q.MinRating != nil && q.MaxRating != nil && *q.MinRating > *q.MaxRating {
+		return fmt.Errorf("%w: min_rating is greater than max_rating", ErrInvalidQuery)
+	}
+	return nil
+}
+
+func (q BookQuery) matches(book Books) bool {
+	if q.Genre != "" && book.Genre != q.Genre {
+		return false
+	}
+	if q.Author != "" && book.Author != q.Author {
+		return false
+	}
+	if q.MinRating != nil && book.Rating < *q.MinRating {
+		return false
+	}
+	if q.MaxRating != nil && book.Rating > *q.MaxRating {
+		return false
+	}
+	return true
+}
+
+// sortKey returns the value a book is ordered by, in the same form the
+// SQLite store compares it: strings for text and timestamps, float64 for
+// numbers.
+func sortKey(book Books, sortBy string) any {
+	switch sortBy {
+	case SortByTitle:
+		return book.Title
+	case SortByAuthor:
+		return book.Author
+	case SortByRating:
+		return book.Rating
+	case SortByCreatedAt:
+		return formatTime(book.CreatedAt)
+	default:
+		return float64(book.ID)
+	}
+}
+
+func compareKeys(a, b any) int {
+	switch a := a.(type) {
+	case string:
+		b, _ := b.(string)
+		return cmp.Compare(a, b)
+	case float64:
+		b, _ := b.(float64)
+		return cmp.Compare(a, b)
+	}
+	return 0
+}
+
+func sameKeyType(a, b any) bool {
+	switch a.(type) {
+	case string:
+		_, ok := b.(string)
+		return ok
+	case float64:
+		_, ok := b.(float64)
+		return ok
+	}
+	return false
+}
+
+// compareBooks orders by the sort key with ID as the tie-breaker, both in
+// the requested direction, which is what makes keyset cursors stable.
+func (q BookQuery) compareBooks(a, b Books) int {
+	order := compareKeys(sortKey(a, q.SortBy), sortKey(b, q.SortBy))
+	if order == 0 {
+		order = cmp.Compare(a.ID, b.ID)
+	}
+	if q.Desc {
+		return -order
+	}
+	return order
+}
+
+func (q BookQuery) encodeCursor(book Books) string {
+	data, _ := json.Marshal(bookCursor{SortBy: q.SortBy, Desc: q.Desc, Key: sortKey(book, q.SortBy), ID: book.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (q BookQuery) decodeCursor() (bookCursor, error) {
+	var cursor bookCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return cursor, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return cursor, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	if cursor.SortBy != q.SortBy || cursor.Desc != q.Desc {
+		return cursor, fmt.Errorf("%w: cursor belongs to a different sort order", ErrInvalidQuery)
+	}
+	if !sameKeyType(cursor.Key, sortKey(Books{}, q.SortBy)) {
+		return cursor, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+
+	return cursor, nil
+}
+
+// queryBooks runs a query over an unordered set of books, it is the whole
+// query engine for the in-memory stores.
+func queryBooks(books []Books, query BookQuery) (BookPage, error) {
+	if err := query.normalize(); err != nil {
+		return BookPage{}, err
+	}
+
+	matched := make([]Books, 0, len(books))
+	for _, book := range books {
+		if query.matches(book) {
+			matched = append(matched, book)
+		}
+	}
+	slices.SortFunc(matched, query.compareBooks)
+
+	page := BookPage{Total: len(matched)}
+
+	start := min(query.Offset, len(matched))
+	if query.Cursor != "" {
+		cursor, err := query.decodeCursor()
+		if err != nil {
+			return BookPage{}, err
+		}
+
+		// first book that sorts after the cursor position
+		start, _ = slices.BinarySearchFunc(matched, cursor, func(book Books, cursor bookCursor) int {
+			order := compareKeys(sortKey(book, query.SortBy), cursor.Key)
+			if order == 0 {
+				order = cmp.Compare(book.ID, cursor.ID)
+			}
+			if query.Desc {
+				order = -order
+			}
+			if order == 0 {
+				return -1
+			}
+			return order
+		})
+	}
+
+	end := len(matched)
+	if query.Limit > 0 {
+		end = min(start+query.Limit, len(matched))
+	}
+
+	page.Books = matched[start:end]
+	if end < len(matched) && end > start {
+		page.NextCursor = query.encodeCursor(matched[end-1])
+	}
+
+	return page, nil
+}
@@ -0,0 +1,185 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func addQueryFixtures(t *testing.T, store BookRepository) {
+	fixtures := []Books{
+		{Title: "Brave New World", Author: "Aldous Huxley", Genre: "Dystopian", Rating: 4.0},
+		{Title: "Animal Farm", Author: "George Orwell", Genre: "Satire", Rating: 4.4},
+		{Title: "Emma", Author: "Jane Austen", Genre: "Fiction", Rating: 3.9},
+	}
+	for _, book := range fixtures {
+		if _, err := store.AddBook(context.Background(), book); err != nil {
+			t.Fatalf("AddBook() failed: %v", err)
+		}
+	}
+}
+
+func bookTitles(books []Books) []string {
+	titles := make([]string, len(books))
+	for i, book := range books {
+		titles[i] = book.Title
+	}
+	return titles
+}
+
+func ratingPtr(rating float64) *float64 {
+	return &rating
+}
+
+func TestQueryBooks(t *testing.T) {
+	testCases := []struct {
+		name   string
+		query  BookQuery
+		titles []string
+		total  int
+	}{
+		{
+			name:   "Default Order Is By ID",
+			query:  BookQuery{Limit: 2},
+			titles: []string{"The Great Gatsby", "To Kill a Mockingbird"},
+			total:  6,
+		},
+		{
+			name:   "Sort By Title",
+			query:  BookQuery{SortBy: SortByTitle},
+			titles: []string{"1984", "Animal Farm", "Brave New World", "Emma", "The Great Gatsby", "To Kill a Mockingbird"},
+			total:  6,
+		},
+		{
+			name:   "Sort By Rating Descending With ID Tie-Break",
+			query:  BookQuery{SortBy: SortByRating, Desc: true, Limit: 3},
+			titles: []string{"Animal Farm", "1984", "To Kill a Mockingbird"},
+			total:  6,
+		},
+		{
+			name:   "Offset",
+			query:  BookQuery{SortBy: SortByAuthor, Offset: 4},
+			titles: []string{"To Kill a Mockingbird", "Emma"},
+			total:  6,
+		},
+		{
+			name:   "Genre Filter",
+			query:  BookQuery{Genre: "Dystopian", SortBy: SortByTitle},
+			titles: []string{"1984", "Brave New World"},
+			total:  2,
+		},
+		{
+			name:   "Author Filter",
+			query:  BookQuery{Author: "George Orwell", SortBy: SortByTitle, Desc: true},
+			titles: []string{"Animal Farm", "1984"},
+			total:  2,
+		},
+		{
+			name:   "Rating Range",
+			query:  BookQuery{MinRating: ratingPtr(4.0), MaxRating: ratingPtr(4.3), SortBy: SortByRating},
+			titles: []string{"Brave New World", "The Great Gatsby", "To Kill a Mockingbird"},
+			total:  3,
+		},
+		{
+			name:   "No Matches",
+			query:  BookQuery{Genre: "Poetry"},
+			titles: []string{},
+			total:  0,
+		},
+	}
+
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		addQueryFixtures(t, store)
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				page, err := store.QueryBooks(context.Background(), tc.query)
+				if err != nil {
+					t.Fatalf("QueryBooks() failed: %v", err)
+				}
+
+				if got := bookTitles(page.Books); !slices.Equal(got, tc.titles) {
+					t.Errorf("expected %v, got %v", tc.titles, got)
+				}
+				if page.Total != tc.total {
+					t.Errorf("expected total %d, got %d", tc.total, page.Total)
+				}
+			})
+		}
+	})
+}
+
+func TestQueryBooksCursorPaging(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		addQueryFixtures(t, store)
+
+		for _, desc := range []bool{false, true} {
+			query := BookQuery{SortBy: SortByCreatedAt, Desc: desc, Limit: 4}
+
+			var seen []int
+			for {
+				page, err := store.QueryBooks(context.Background(), query)
+				if err != nil {
+					t.Fatalf("QueryBooks() failed: %v", err)
+				}
+				for _, book := range page.Books {
+					seen = append(seen, book.ID)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				query.Cursor = page.NextCursor
+			}
+
+			if len(seen) != 6 {
+				t.Fatalf("expected 6 books across pages, got %v", seen)
+			}
+
+			// the seeded books may share a creation instant, so only the books
+			// added afterwards have a known position
+			added, later := []int{4, 5, 6}, seen[3:]
+			if desc {
+				added, later = []int{6, 5, 4}, seen[:3]
+			}
+			if !slices.Equal(later, added) {
+				t.Errorf("expected %v in creation order, got %v", added, seen)
+			}
+
+			slices.Sort(seen)
+			if !slices.Equal(seen, []int{1, 2, 3, 4, 5, 6}) {
+				t.Errorf("expected every book exactly once, got %v", seen)
+			}
+		}
+	})
+}
+
+func TestQueryBooksRejectsInvalidQueries(t *testing.T) {
+	valid, err := seedDemo(t, NewBookStore()).QueryBooks(context.Background(), BookQuery{SortBy: SortByTitle, Limit: 1})
+	if err != nil {
+		t.Fatalf("QueryBooks() failed: %v", err)
+	}
+
+	testCases := []struct {
+		name  string
+		query BookQuery
+	}{
+		{name: "Unknown Sort Field", query: BookQuery{SortBy: "isbn"}},
+		{name: "Negative Limit", query: BookQuery{Limit: -1}},
+		{name: "Offset With Cursor", query: BookQuery{Offset: 1, Cursor: valid.NextCursor, SortBy: SortByTitle}},
+		{name: "Inverted Rating Range", query: BookQuery{MinRating: ratingPtr(4), MaxRating: ratingPtr(3)}},
+		{name: "Malformed Cursor", query: BookQuery{Cursor: "not-a-cursor"}},
+		{name: "Cursor From Another Sort", query: BookQuery{Cursor: valid.NextCursor, SortBy: SortByRating}},
+	}
+
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				_, err := store.QueryBooks(context.Background(), tc.query)
+				if !errors.Is(err, ErrInvalidQuery) {
+					t.Errorf("expected ErrInvalidQuery, got %v", err)
+				}
+			})
+		}
+	})
+}
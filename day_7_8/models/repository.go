@@ -0,0 +1,77 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrBookNotFound = errors.New("book not found")
+	ErrStoreClosed  = errors.New("store is closed")
+	// ErrVersionConflict means the book changed since the caller read it
+	ErrVersionConflict = errors.New("book version conflict")
+	// ErrDuplicateID means a client-chosen ID is already taken, trashed books included
+	ErrDuplicateID = errors.New("book ID already exists")
+)
+
+// BookRepository is what the controllers depend on, so the storage backend
+// can be swapped at startup without touching the HTTP layer.
+type BookRepository interface {
+	GetBook(ctx context.Context, bookID int) (Books, error)
+	GetAllBooks(ctx context.Context) ([]Books, error)
+	QueryBooks(ctx context.Context, query BookQuery) (BookPage, error)
+	SearchBooks(ctx context.Context, query string, limit int) ([]SearchResult, error)
+	AddBook(ctx context.Context, book Books) (Books, error)
+	AddBooks(ctx context.Context, books []Books) ([]Books, error)
+	UpdateBook(ctx context.Context, book Books) (Books, error)
+	DeleteBook(ctx context.Context, bookID, version int) error
+	GetDeletedBooks(ctx context.Context) ([]Books, error)
+	RestoreBook(ctx context.Context, bookID int) (Books, error)
+	PurgeDeletedBooks(ctx context.Context, before time.Time) (int, error)
+	GetBookHistory(ctx context.Context, bookID int) ([]HistoryEntry, error)
+	RevertBook(ctx context.Context, bookID, revision int) (Books, error)
+	OnChange(hook func(HistoryEntry))
+	// CountBooks counts without reading the books, for frequent callers
+	// such as the metrics scrape
+	CountBooks(ctx context.Context) (BookCounts, error)
+}
+
+// BookCounts sizes up the catalogue. ByGenre only has the genres that
+// have live books.
+type BookCounts struct {
+	Live    int
+	Trashed int
+	ByGenre map[string]int
+}
+
+const (
+	BackendMemory = "memory"
+	BackendFile   = "file"
+	BackendSQLite = "sqlite"
+)
+
+const (
+	defaultFileStoreDir = "data/books"
+	defaultSQLitePath   = "data/books.db"
+)
+
+func NewRepository(backend, path string) (BookRepository, error) {
+	switch backend {
+	case "", BackendMemory:
+		return NewBookStore(), nil
+	case BackendFile:
+		if path == "" {
+			path = defaultFileStoreDir
+		}
+		return NewFileStore(path)
+	case BackendSQLite:
+		if path == "" {
+			path = defaultSQLitePath
+		}
+		return NewSQLiteStore(path)
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", backend)
+	}
+}
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+type SearchResult struct {
+	Book  Books   `json:"book"`
+	Score float64 `json:"score"`
+}
+
+// a hit in the title counts for more than one in the author, which counts
+// for more than one in the genre
+const (
+	titleWeight  = 3.0
+	authorWeight = 2.0
+	genreWeight  = 1.0
+
+	// a query token that is only a prefix of the indexed term scores less
+	// than an exact match
+	prefixPenalty = 0.5
+)
+
+// tokenize splits text on anything that is not a letter or a digit and case
+// folds the pieces. Queries and documents go through the same function.
+func tokenize(text string) []string {
+	tokens := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, token := range tokens {
+		tokens[i] = strings.ToLower(token)
+	}
+	return tokens
+}
+
+// searchIndex is an inverted index from terms to the books containing them.
+// It has no lock of its own, LibraryStore guards it with its RWMutex.
+type searchIndex struct {
+	// term -> book ID -> weight of the term in that book
+	postings map[string]map[int]float64
+	// sorted keys of postings, so prefix lookups are a binary search
+	terms []string
+	// book ID -> terms, so a book can be unindexed without re-tokenizing it
+	bookTerms map[int][]string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings:  map[string]map[int]float64{},
+		bookTerms: map[int][]string{},
+	}
+}
+
+func (idx *searchIndex) add(book Books) {
+	idx.remove(book.ID)
+
+	weights := map[string]float64{}
+	for _, field := range []struct {
+		text   string
+		weight float64
+	}{
+		{book.Title, titleWeight},
+		{book.Author, authorWeight},
+		{book.Genre, genreWeight},
+	} {
+		for _, term := range tokenize(field.text) {
+			weights[term] += field.weight
+		}
+	}
+
+	terms := make([]string, 0, len(weights))
+	for term, weight := range weights {
+		books, exists := idx.postings[term]
+		if !exists {
+			books = map[int]float64{}
+			idx.postings[term] = books
+
+			pos, _ := slices.BinarySearch(idx.terms, term)
+			idx.terms = slices.Insert(idx.terms, pos, term)
+		}
+		books[book.ID] = weight
+		terms = append(terms, term)
+	}
+	idx.bookTerms[book.ID] = terms
+}
+
+func (idx *searchIndex) remove(bookID int) {
+	for _, term := range idx.bookTerms[bookID] {
+		books := idx.postings[term]
+		delete(books, bookID)
+
+		if len(books) == 0 {
+			delete(idx.postings, term)
+			if pos, found := slices.BinarySearch(idx.terms, term); found {
+				idx.terms = slices.Delete(idx.terms, pos, pos+1)
+			}
+		}
+	}
+	delete(idx.bookTerms, bookID)
+}
+
+// search returns the IDs of books matching every query token, exactly or as
+// a prefix, with their scores. Rarer terms are worth more (inverse document
+// frequency), and weights follow the field the term was found in.
+func (idx *searchIndex) search(query string) map[int]float64 {
+	tokens := tokenize(query)
+	slices.Sort(tokens)
+	tokens = slices.Compact(tokens)
+
+	var (
+		total   = float64(len(idx.bookTerms))
+		scores  = map[int]float64{}
+		matched = map[int]int{}
+	)
+
+	for _, token := range tokens {
+		best := map[int]float64{}
+
+		start, _ := slices.BinarySearch(idx.terms, token)
+		for _, term := range idx.terms[start:] {
+			if !strings.HasPrefix(term, token) {
+				break
+			}
+
+			books := idx.postings[term]
+			idf := math.Log(1 + total/float64(len(books)))
+			for bookID, weight := range books {
+				score := weight * idf
+				if term != token {
+					score *= prefixPenalty
+				}
+				best[bookID] = max(best[bookID], score)
+			}
+		}
+
+		for bookID, score := range best {
+			scores[bookID] += score
+			matched[bookID]++
+		}
+	}
+
+	for bookID := range scores {
+		if matched[bookID] != len(tokens) {
+			delete(scores, bookID)
+		}
+	}
+
+	return scores
+}
+
+func validateSearch(query string, limit int) error {
+	if len(tokenize(query)) == 0 {
+		return fmt.Errorf("%w: search query has no words", ErrInvalidQuery)
+	}
+	if limit < 0 {
+		return fmt.Errorf("%w: limit must not be negative", ErrInvalidQuery)
+	}
+	return nil
+}
+
+// SearchBooks returns the books matching every word of query, best match
+// first. A limit of 0 returns every match.
+func (ls *LibraryStore) SearchBooks(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	if err := validateSearch(query, limit); err != nil {
+		return nil, err
+	}
+
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	scores := ls.index.search(query)
+	results := make([]SearchResult, 0, len(scores))
+	for bookID, score := range scores {
+		results = append(results, SearchResult{Book: ls.Books[bookID], Score: score})
+	}
+
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		if order := cmp.Compare(b.Score, a.Score); order != 0 {
+			return order
+		}
+		return cmp.Compare(a.Book.ID, b.Book.ID)
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
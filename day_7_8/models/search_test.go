@@ -0,0 +1,135 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func searchTitles(t *testing.T, store BookRepository, query string) []string {
+	results, err := store.SearchBooks(context.Background(), query, 0)
+	if err != nil {
+		t.Fatalf("SearchBooks(%q) failed: %v", query, err)
+	}
+
+	titles := make([]string, len(results))
+	for i, result := range results {
+		titles[i] = result.Book.Title
+	}
+	return titles
+}
+
+func TestSearchBooks(t *testing.T) {
+	testCases := []struct {
+		name   string
+		query  string
+		titles []string
+	}{
+		{name: "Author", query: "orwell", titles: []string{"1984"}},
+		{name: "Case Folding", query: "GEORGE", titles: []string{"1984"}},
+		{name: "Prefix", query: "mock", titles: []string{"To Kill a Mockingbird"}},
+		{name: "Every Word Must Match", query: "great gats", titles: []string{"The Great Gatsby"}},
+		{name: "Punctuation Is Ignored", query: "f. scott", titles: []string{"The Great Gatsby"}},
+		{name: "Title Outranks Genre", query: "dystopian", titles: []string{"Dystopian Dreams", "1984"}},
+		{name: "No Match", query: "tolstoy", titles: []string{}},
+	}
+
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		_, err := store.AddBook(context.Background(), Books{Title: "Dystopian Dreams", Author: "A. Writer", Genre: "Poetry", Rating: 3})
+		if err != nil {
+			t.Fatalf("AddBook() failed: %v", err)
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				if got := searchTitles(t, store, tc.query); !slices.Equal(got, tc.titles) {
+					t.Errorf("expected %v, got %v", tc.titles, got)
+				}
+			})
+		}
+	})
+}
+
+func TestSearchBooksFollowsWrites(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		ctx := context.Background()
+
+		_, err := store.UpdateBook(ctx, Books{ID: 3, Title: "Nineteen Eighty-Four", Author: "George Orwell", Genre: "Dystopian", Rating: 4.4})
+		if err != nil {
+			t.Fatalf("UpdateBook() failed: %v", err)
+		}
+		if got := searchTitles(t, store, "1984"); len(got) != 0 {
+			t.Errorf("expected old title to be unindexed, got %v", got)
+		}
+		if got := searchTitles(t, store, "eighty"); !slices.Equal(got, []string{"Nineteen Eighty-Four"}) {
+			t.Errorf("expected new title to be indexed, got %v", got)
+		}
+
+		if err := store.DeleteBook(ctx, 3, 0); err != nil {
+			t.Fatalf("DeleteBook() failed: %v", err)
+		}
+		if got := searchTitles(t, store, "orwell"); len(got) != 0 {
+			t.Errorf("expected deleted book to be unindexed, got %v", got)
+		}
+	})
+}
+
+func TestSearchBooksRejectsInvalidQueries(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		for _, query := range []string{"", "  ", "?!"} {
+			if _, err := store.SearchBooks(context.Background(), query, 0); !errors.Is(err, ErrInvalidQuery) {
+				t.Errorf("SearchBooks(%q): expected ErrInvalidQuery, got %v", query, err)
+			}
+		}
+
+		if _, err := store.SearchBooks(context.Background(), "orwell", -1); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("expected ErrInvalidQuery for a negative limit, got %v", err)
+		}
+	})
+}
+
+func TestSearchIndexConsistentUnderConcurrentWrites(t *testing.T) {
+	store := NewBookStore()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for worker := range 8 {
+		wg.Go(func() {
+			for i := range 50 {
+				id := 100 + worker*50 + i
+				book := Books{ID: id, Title: fmt.Sprintf("Volume %d", i), Author: fmt.Sprintf("Writer %d", worker), Genre: "Serial", Rating: 3}
+				store.AddBook(ctx, book)
+
+				book.Title = fmt.Sprintf("Revised %d", i)
+				store.UpdateBook(ctx, book)
+
+				if i%2 == 0 {
+					store.DeleteBook(ctx, id, 0)
+				}
+			}
+		})
+		wg.Go(func() {
+			for range 50 {
+				store.SearchBooks(ctx, "volume", 10)
+				store.SearchBooks(ctx, "writer rev", 10)
+			}
+		})
+	}
+	wg.Wait()
+
+	// an index rebuilt from scratch must match the incrementally maintained one
+	rebuilt := newLibraryStore(store.Books)
+	if !maps.EqualFunc(store.index.postings, rebuilt.index.postings, maps.Equal) {
+		t.Error("search index drifted from the books map")
+	}
+	if !slices.Equal(store.index.terms, rebuilt.index.terms) {
+		t.Error("sorted term list drifted from the postings")
+	}
+	if got := searchTitles(t, store, "serial"); len(got) != 8*25 {
+		t.Errorf("expected %d surviving serial books, got %d", 8*25, len(got))
+	}
+}
@@ -0,0 +1,202 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// SeedActor is the actor the history gives books loaded from a seed file
+const SeedActor = "seed"
+
+// SeedError lists every row of a seed file that could not be loaded. Rows
+// of a JSON file are counted from 1; rows of a CSV file are its line
+// numbers, so the first book after the header is row 2.
+type SeedError struct {
+	Path string
+	Rows []SeedRowError
+}
+
+type SeedRowError struct {
+	Row    int          `json:"row"`
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *SeedError) Error() string {
+	rows := make([]string, len(e.Rows))
+	for i, row := range e.Rows {
+		messages := make([]string, len(row.Errors))
+		for j, field := range row.Errors {
+			messages[j] = field.Message
+		}
+		rows[i] = fmt.Sprintf("row %d: %s", row.Row, strings.Join(messages, ", "))
+	}
+	return fmt.Sprintf("seed file %s: %s", e.Path, strings.Join(rows, "; "))
+}
+
+// LoadSeedFile reads books from a JSON array of objects or a CSV file with
+// a header row, using the json field names of Books either way. Each book
+// is validated like one sent to the API; the store sets versions and
+// timestamps when it adds them. An empty file or array is an empty seed.
+func LoadSeedFile(path string) ([]Books, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading seed file: %w", err)
+	}
+
+	var rows []seedRow
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		rows, err = jsonSeedRows(data)
+	case ".csv":
+		rows, err = csvSeedRows(data)
+	default:
+		return nil, fmt.Errorf("seed file %s must be .json or .csv", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("seed file %s: %w", path, err)
+	}
+
+	var (
+		books   = make([]Books, 0, len(rows))
+		invalid = &SeedError{Path: path}
+		seenIDs = map[int]int{}
+	)
+	for _, row := range rows {
+		book, provided, err := DecodeBook(row.data, true)
+		if err == nil {
+			err = ValidateBook(book, provided)
+		}
+
+		var fields []FieldError
+		var validation *ValidationError
+		if errors.As(err, &validation) {
+			fields = validation.Errors
+		} else if err != nil {
+			return nil, fmt.Errorf("seed file %s: row %d: %w", path, row.number, err)
+		}
+		if first, taken := seenIDs[book.ID]; taken && book.ID != 0 {
+			fields = append(fields, FieldError{Field: "id", Code: CodeDuplicate, Message: fmt.Sprintf("id %d is already used by row %d", book.ID, first)})
+		} else if book.ID != 0 {
+			seenIDs[book.ID] = row.number
+		}
+
+		if len(fields) > 0 {
+			invalid.Rows = append(invalid.Rows, SeedRowError{Row: row.number, Errors: fields})
+			continue
+		}
+		books = append(books, book)
+	}
+
+	if len(invalid.Rows) > 0 {
+		return nil, invalid
+	}
+	return books, nil
+}
+
+type seedRow struct {
+	number int
+	data   []byte
+}
+
+func jsonSeedRows(data []byte) ([]seedRow, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("must hold a JSON array of books: %w", err)
+	}
+
+	rows := make([]seedRow, len(items))
+	for i, item := range items {
+		rows[i] = seedRow{number: i + 1, data: item}
+	}
+	return rows, nil
+}
+
+// csvSeedRows turns each line into a JSON object so that CSV and JSON rows
+// are decoded and validated the same way. Empty cells are left out, and
+// the cells of number fields that are JSON numbers are passed through as
+// they are. Any other cell is a string, which lets DecodeBook report the
+// ones in number fields.
+func csvSeedRows(data []byte) ([]seedRow, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	numeric := map[string]bool{}
+	bookType := reflect.TypeFor[Books]()
+	for _, field := range bookFields() {
+		switch bookType.Field(field.index).Type.Kind() {
+		case reflect.Int, reflect.Float64:
+			numeric[field.name] = true
+		}
+	}
+
+	var rows []seedRow
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		object := make(map[string]json.RawMessage, len(header))
+		for i, name := range header {
+			cell := strings.TrimSpace(record[i])
+			if cell == "" {
+				continue
+			}
+			// ParseFloat also takes NaN, Inf and hex, which are not JSON numbers
+			if _, err := strconv.ParseFloat(cell, 64); numeric[name] && err == nil && json.Valid([]byte(cell)) {
+				object[name] = json.RawMessage(cell)
+			} else {
+				object[name], _ = json.Marshal(cell)
+			}
+		}
+		encoded, err := json.Marshal(object)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rows = append(rows, seedRow{number: line, data: encoded})
+	}
+}
+
+// SeedStore adds books to a store that holds none, live or in the
+// trash, and returns how many it added. A store with books in it is left
+// alone, so a persistent store is only seeded the first time it starts.
+func SeedStore(ctx context.Context, store BookRepository, books []Books) (int, error) {
+	counts, err := store.CountBooks(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if counts.Live+counts.Trashed > 0 || len(books) == 0 {
+		return 0, nil
+	}
+
+	added, err := store.AddBooks(WithActor(ctx, SeedActor), books)
+	if err != nil {
+		return 0, fmt.Errorf("seeding store: %w", err)
+	}
+	return len(added), nil
+}
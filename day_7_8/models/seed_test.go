@@ -0,0 +1,217 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var demoFixture = filepath.Join("..", "fixtures", "demo_books.json")
+
+// seedDemo fills a fresh store with the three demo books the tests are
+// written against.
+func seedDemo[S BookRepository](t *testing.T, store S) S {
+	t.Helper()
+	books, err := LoadSeedFile(demoFixture)
+	if err != nil {
+		t.Fatalf("LoadSeedFile() failed: %v", err)
+	}
+	if _, err := SeedStore(context.Background(), store, books); err != nil {
+		t.Fatalf("SeedStore() failed: %v", err)
+	}
+	return store
+}
+
+func writeSeed(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("writing %s failed: %v", name, err)
+	}
+	return path
+}
+
+func TestStoresStartEmpty(t *testing.T) {
+	file, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore() failed: %v", err)
+	}
+	defer file.Close()
+	sqlite, err := NewSQLiteStore(filepath.Join(t.TempDir(), "books.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore() failed: %v", err)
+	}
+	defer sqlite.Close()
+
+	for name, store := range map[string]BookRepository{"memory": NewBookStore(), "file": file, "sqlite": sqlite} {
+		if n := countBooks(t, store); n != 0 {
+			t.Errorf("%s: expected an empty store, got %d books", name, n)
+		}
+	}
+}
+
+func TestLoadSeedFile(t *testing.T) {
+	demo := []Books{
+		{ID: 1, Title: "The Great Gatsby", Author: "F. Scott Fitzgerald", Genre: "Fiction", Rating: 4.2},
+		{ID: 2, Title: "To Kill a Mockingbird", Author: "Harper Lee", Genre: "Fiction", Rating: 4.3},
+		{ID: 3, Title: "1984", Author: "George Orwell", Genre: "Dystopian", Rating: 4.4},
+	}
+
+	testCases := []struct {
+		name     string
+		path     string
+		expected []Books
+	}{
+		{name: "Demo JSON", path: demoFixture, expected: demo},
+		{name: "Demo CSV", path: filepath.Join("..", "fixtures", "demo_books.csv"), expected: demo},
+		{
+			name:     "CSV Without IDs",
+			path:     writeSeed(t, "books.csv", "id,title,author,genre,rating\n,Emma,Jane Austen,Fiction,3.9\n\"\",\"Dune, Part One\",Frank Herbert,Science Fiction,4.6\n"),
+			expected: []Books{{Title: "Emma", Author: "Jane Austen", Genre: "Fiction", Rating: 3.9}, {Title: "Dune, Part One", Author: "Frank Herbert", Genre: "Science Fiction", Rating: 4.6}},
+		},
+		{name: "Empty JSON File", path: writeSeed(t, "empty.json", ""), expected: []Books{}},
+		{name: "Empty JSON Array", path: writeSeed(t, "empty.json", "[]"), expected: []Books{}},
+		{name: "Empty CSV File", path: writeSeed(t, "empty.csv", ""), expected: []Books{}},
+		{name: "CSV Header Only", path: writeSeed(t, "header.csv", "title,author,genre\n"), expected: []Books{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			books, err := LoadSeedFile(tc.path)
+			if err != nil {
+				t.Fatalf("LoadSeedFile() failed: %v", err)
+			}
+			if !reflect.DeepEqual(books, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, books)
+			}
+		})
+	}
+}
+
+func TestLoadSeedFileRowErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		path     string
+		expected []SeedRowError
+	}{
+		{
+			name: "JSON",
+			path: writeSeed(t, "books.json", `[
+				{"id": 1, "title": "Emma", "author": "Jane Austen", "genre": "Fiction", "rating": 3.9},
+				{"id": 1, "title": "", "author": "Jane Austen", "genre": "Fiction", "rating": 3.9},
+				{"title": "Dune", "author": "Frank Herbert", "genre": "Science Fiction", "rating": 4.6, "isbn": "x"},
+				{"title": "Ulysses", "author": "James Joyce", "genre": "Fiction", "rating": 9}
+			]`),
+			expected: []SeedRowError{
+				{Row: 2, Errors: []FieldError{{Field: "title", Code: CodeRequired}, {Field: "id", Code: CodeDuplicate}}},
+				{Row: 3, Errors: []FieldError{{Field: "isbn", Code: CodeUnknownField}}},
+				{Row: 4, Errors: []FieldError{{Field: "rating", Code: CodeOutOfRange}}},
+			},
+		},
+		{
+			name: "CSV",
+			path: writeSeed(t, "books.csv", "id,title,author,genre,rating\n1,Emma,Jane Austen,Fiction,3.9\n2,Dune,Frank Herbert,Cookbook,good\n"),
+			expected: []SeedRowError{
+				{Row: 3, Errors: []FieldError{{Field: "rating", Code: CodeInvalidType}}},
+			},
+		},
+		{
+			// strconv.ParseFloat takes all of these, JSON none of them
+			name: "CSV Numbers That Are Not JSON",
+			path: writeSeed(t, "books.csv", "title,author,genre,rating\nEmma,Jane Austen,Fiction,NaN\nDune,Frank Herbert,Fiction,+Inf\nUlysses,James Joyce,Fiction,0x1p2\n"),
+			expected: []SeedRowError{
+				{Row: 2, Errors: []FieldError{{Field: "rating", Code: CodeInvalidType}}},
+				{Row: 3, Errors: []FieldError{{Field: "rating", Code: CodeInvalidType}}},
+				{Row: 4, Errors: []FieldError{{Field: "rating", Code: CodeInvalidType}}},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadSeedFile(tc.path)
+			var seedErr *SeedError
+			if !errors.As(err, &seedErr) {
+				t.Fatalf("expected a *SeedError, got %v", err)
+			}
+
+			// messages are for people, so only the rows, fields and codes are compared
+			var got []SeedRowError
+			for _, row := range seedErr.Rows {
+				fields := make([]FieldError, len(row.Errors))
+				for i, field := range row.Errors {
+					fields[i] = FieldError{Field: field.Field, Code: field.Code}
+				}
+				got = append(got, SeedRowError{Row: row.Row, Errors: fields})
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestLoadSeedFileRejectsBadFiles(t *testing.T) {
+	for _, path := range []string{
+		filepath.Join(t.TempDir(), "missing.json"),
+		writeSeed(t, "books.yaml", "- title: Emma\n"),
+		writeSeed(t, "object.json", `{"title": "Emma"}`),
+		writeSeed(t, "ragged.csv", "title,author\nEmma\n"),
+	} {
+		if _, err := LoadSeedFile(path); err == nil {
+			t.Errorf("expected LoadSeedFile(%s) to fail", filepath.Base(path))
+		}
+	}
+}
+
+func TestSeedStore(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		ctx := context.Background()
+
+		// the demo books went in as the seed actor, so they have history
+		history, err := store.GetBookHistory(ctx, 1)
+		if err != nil {
+			t.Fatalf("GetBookHistory() failed: %v", err)
+		}
+		if len(history) != 1 || history[0].Actor != SeedActor {
+			t.Errorf("expected one entry by %q, got %+v", SeedActor, history)
+		}
+
+		// a store that already has books, even only in the trash, is left alone
+		extra := []Books{{Title: "Emma", Author: "Jane Austen", Genre: "Fiction", Rating: 3.9}}
+		if n, err := SeedStore(ctx, store, extra); err != nil || n != 0 {
+			t.Errorf("expected a non-empty store to be skipped, got %d, %v", n, err)
+		}
+		for _, id := range []int{1, 2, 3} {
+			if err := store.DeleteBook(ctx, id, 0); err != nil {
+				t.Fatalf("DeleteBook() failed: %v", err)
+			}
+		}
+		if n, err := SeedStore(ctx, store, extra); err != nil || n != 0 {
+			t.Errorf("expected a store with trashed books to be skipped, got %d, %v", n, err)
+		}
+
+		if _, err := store.PurgeDeletedBooks(ctx, time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("PurgeDeletedBooks() failed: %v", err)
+		}
+		// only the counts are read, never the books themselves
+		if n, err := SeedStore(ctx, countOnlyStore{store}, extra); err != nil || n != 1 {
+			t.Errorf("expected an emptied store to be seeded, got %d, %v", n, err)
+		}
+	})
+}
+
+// countOnlyStore fails the calls that load every book
+type countOnlyStore struct {
+	BookRepository
+}
+
+func (countOnlyStore) GetAllBooks(ctx context.Context) ([]Books, error) {
+	return nil, errors.New("GetAllBooks called")
+}
+
+func (countOnlyStore) GetDeletedBooks(ctx context.Context) ([]Books, error) {
+	return nil, errors.New("GetDeletedBooks called")
+}
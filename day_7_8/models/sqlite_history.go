@@ -0,0 +1,136 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// GetBookHistory lists every change to a book, oldest first. It works for
+// purged books too, as long as they ever had a history.
+func (s *SQLiteStore) GetBookHistory(ctx context.Context, bookID int) ([]HistoryEntry, error) {
+	entries, err := historyOf(ctx, s.db, bookID)
+	if err != nil || len(entries) > 0 {
+		return entries, err
+	}
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM books WHERE id = ?)`, bookID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrBookNotFound
+	}
+
+	return entries, nil
+}
+
+// RevertBook puts a book's title, author, genre and rating back to how a
+// revision left them. The revert is itself recorded as a new revision.
+func (s *SQLiteStore) RevertBook(ctx context.Context, bookID, revision int) (Books, error) {
+	var book Books
+	err := s.inTx(ctx, func(tx *sqliteTx) error {
+		current, err := liveBook(ctx, tx, bookID)
+		if err != nil {
+			return err
+		}
+		entries, err := historyOf(ctx, tx, bookID)
+		if err != nil {
+			return err
+		}
+		target, err := revertTarget(entries, revision)
+		if err != nil {
+			return err
+		}
+
+		book = reverted(current, target)
+		book.Version++
+		book.UpdatedAt = time.Now()
+		return saveChange(ctx, tx, HistoryRevert, current, book)
+	})
+	if err != nil {
+		return Books{}, err
+	}
+
+	return book, nil
+}
+
+// insertHistory numbers the entry as the book's next revision.
+func insertHistory(ctx context.Context, tx *sqliteTx, entry HistoryEntry) error {
+	before, err := snapshotJSON(entry.Before)
+	if err != nil {
+		return err
+	}
+	after, err := snapshotJSON(entry.After)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, `INSERT INTO book_history (book_id, revision, operation, actor, at, before_json, after_json)
+		SELECT ?, COALESCE(MAX(revision), 0) + 1, ?, ?, ?, ?, ? FROM book_history WHERE book_id = ?
+		RETURNING revision`,
+		entry.BookID, entry.Operation, entry.Actor, formatTime(entry.At), before, after, entry.BookID).Scan(&entry.Revision)
+	if err != nil {
+		return err
+	}
+
+	tx.changes = append(tx.changes, entry)
+	return nil
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func historyOf(ctx context.Context, db querier, bookID int) ([]HistoryEntry, error) {
+	rows, err := db.QueryContext(ctx, `SELECT book_id, revision, operation, actor, at, before_json, after_json
+		FROM book_history WHERE book_id = ? ORDER BY revision`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []HistoryEntry{}
+	for rows.Next() {
+		var (
+			entry         HistoryEntry
+			at            string
+			before, after sql.NullString
+		)
+		if err := rows.Scan(&entry.BookID, &entry.Revision, &entry.Operation, &entry.Actor, &at, &before, &after); err != nil {
+			return nil, err
+		}
+		if entry.At, err = parseTime(at); err != nil {
+			return nil, err
+		}
+		if entry.Before, err = parseSnapshot(before); err != nil {
+			return nil, err
+		}
+		if entry.After, err = parseSnapshot(after); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func snapshotJSON(book *Books) (any, error) {
+	if book == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(book)
+	return string(data), err
+}
+
+func parseSnapshot(value sql.NullString) (*Books, error) {
+	if !value.Valid {
+		return nil, nil
+	}
+	var book Books
+	if err := json.Unmarshal([]byte(value.String), &book); err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type migration struct {
+	version    int
+	statements []string
+}
+
+// migrations are applied in order and never edited once released, a schema
+// change always goes in as a new version at the end of the list
+var migrations = []migration{
+	{
+		version: 1,
+		statements: []string{
+			`CREATE TABLE books (
+				id         INTEGER PRIMARY KEY AUTOINCREMENT,
+				title      TEXT    NOT NULL,
+				author     TEXT    NOT NULL,
+				genre      TEXT    NOT NULL,
+				rating     REAL    NOT NULL,
+				created_at TEXT    NOT NULL,
+				updated_at TEXT    NOT NULL
+			)`,
+			`CREATE INDEX idx_books_author ON books (author)`,
+			`CREATE INDEX idx_books_genre ON books (genre)`,
+		},
+	},
+	{
+		// full-text index kept in step with books by triggers
+		version: 2,
+		statements: []string{
+			`CREATE VIRTUAL TABLE books_fts USING fts5 (
+				title, author, genre,
+				content = 'books', content_rowid = 'id',
+				tokenize = 'unicode61 remove_diacritics 0'
+			)`,
+			`CREATE TRIGGER books_fts_insert AFTER INSERT ON books BEGIN
+				INSERT INTO books_fts (rowid, title, author, genre) VALUES (new.id, new.title, new.author, new.genre);
+			END`,
+			`CREATE TRIGGER books_fts_delete AFTER DELETE ON books BEGIN
+				INSERT INTO books_fts (books_fts, rowid, title, author, genre) VALUES ('delete', old.id, old.title, old.author, old.genre);
+			END`,
+			`CREATE TRIGGER books_fts_update AFTER UPDATE OF title, author, genre ON books BEGIN
+				INSERT INTO books_fts (books_fts, rowid, title, author, genre) VALUES ('delete', old.id, old.title, old.author, old.genre);
+				INSERT INTO books_fts (rowid, title, author, genre) VALUES (new.id, new.title, new.author, new.genre);
+			END`,
+			`INSERT INTO books_fts (books_fts) VALUES ('rebuild')`,
+		},
+	},
+	{
+		// optimistic concurrency, every write bumps the version
+		version: 3,
+		statements: []string{
+			`ALTER TABLE books ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+		},
+	},
+	{
+		// soft delete, a trashed book keeps its row until it is purged
+		version: 4,
+		statements: []string{
+			`ALTER TABLE books ADD COLUMN deleted_at TEXT`,
+			`CREATE INDEX idx_books_deleted_at ON books (deleted_at) WHERE deleted_at IS NOT NULL`,
+		},
+	},
+	{
+		// audit trail, deliberately without a foreign key so it outlives a purge
+		version: 5,
+		statements: []string{
+			`CREATE TABLE book_history (
+				book_id     INTEGER NOT NULL,
+				revision    INTEGER NOT NULL,
+				operation   TEXT    NOT NULL,
+				actor       TEXT    NOT NULL DEFAULT '',
+				at          TEXT    NOT NULL,
+				before_json TEXT,
+				after_json  TEXT,
+				PRIMARY KEY (book_id, revision)
+			)`,
+		},
+	},
+}
+
+// migrate brings the schema up to the latest version and returns the version
+// the database was at before it ran.
+func migrate(ctx context.Context, db *sql.DB) (int, error) {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return 0, fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	var current int
+	err = db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(ctx, db, m); err != nil {
+			return current, err
+		}
+	}
+
+	return current, nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migration %d: %w", m.version, err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`,
+		m.version, formatTime(time.Now()))
+	if err != nil {
+		return fmt.Errorf("migration %d: %w", m.version, err)
+	}
+
+	return tx.Commit()
+}
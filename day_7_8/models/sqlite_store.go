@@ -0,0 +1,597 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const bookColumns = `id, title, author, genre, rating, version, created_at, updated_at, deleted_at`
+
+// notDeleted keeps trashed books out of everything but the trash itself
+const notDeleted = `deleted_at IS NULL`
+
+// SQLiteStore keeps the catalogue in a SQLite database using the cgo-free
+// modernc.org/sqlite driver.
+type SQLiteStore struct {
+	db *sql.DB
+
+	hooksMu sync.RWMutex
+	hooks   []func(HistoryEntry)
+	// commitMu is held from Commit until the hooks have run, so they hear
+	// about transactions in the order those committed
+	commitMu sync.Mutex
+}
+
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create database directory: %w", err)
+	}
+
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+
+	ctx := context.Background()
+	if _, err := migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) GetBook(ctx context.Context, bookID int) (Books, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+bookColumns+` FROM books WHERE id = ? AND `+notDeleted, bookID)
+
+	book, err := scanBook(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Books{}, ErrBookNotFound
+	}
+
+	return book, err
+}
+
+func (s *SQLiteStore) GetAllBooks(ctx context.Context) ([]Books, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+bookColumns+` FROM books WHERE `+notDeleted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := []Books{}
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+
+	return books, rows.Err()
+}
+
+func (s *SQLiteStore) QueryBooks(ctx context.Context, query BookQuery) (BookPage, error) {
+	if err := query.normalize(); err != nil {
+		return BookPage{}, err
+	}
+
+	var (
+		conditions = []string{notDeleted}
+		args       []any
+	)
+	if query.Genre != "" {
+		conditions = append(conditions, "genre = ?")
+		args = append(args, query.Genre)
+	}
+	if query.Author != "" {
+		conditions = append(conditions, "author = ?")
+		args = append(args, query.Author)
+	}
+	if query.MinRating != nil {
+		conditions = append(conditions, "rating >= ?")
+		args = append(args, *query.MinRating)
+	}
+	if query.MaxRating != nil {
+		conditions = append(conditions, "rating <= ?")
+		args = append(args, *query.MaxRating)
+	}
+
+	var page BookPage
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM books`+whereClause(conditions), args...).Scan(&page.Total)
+	if err != nil {
+		return BookPage{}, err
+	}
+
+	// SortBy has been checked against sortFields, so it is safe to splice in
+	column, direction, after := query.SortBy, "ASC", ">"
+	if query.Desc {
+		direction, after = "DESC", "<"
+	}
+
+	if query.Cursor != "" {
+		cursor, err := query.decodeCursor()
+		if err != nil {
+			return BookPage{}, err
+		}
+		conditions = append(conditions, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, after))
+		args = append(args, cursor.Key, cursor.Key, cursor.ID)
+	}
+
+	// fetch one extra row to find out whether there is a next page
+	limit := -1
+	if query.Limit > 0 {
+		limit = query.Limit + 1
+	}
+	args = append(args, limit, query.Offset)
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+bookColumns+` FROM books`+whereClause(conditions)+
+		fmt.Sprintf(` ORDER BY %[1]s %[2]s, id %[2]s LIMIT ? OFFSET ?`, column, direction), args...)
+	if err != nil {
+		return BookPage{}, err
+	}
+	defer rows.Close()
+
+	page.Books = []Books{}
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return BookPage{}, err
+		}
+		page.Books = append(page.Books, book)
+	}
+	if err := rows.Err(); err != nil {
+		return BookPage{}, err
+	}
+
+	if query.Limit > 0 && len(page.Books) > query.Limit {
+		page.Books = page.Books[:query.Limit]
+		page.NextCursor = query.encodeCursor(page.Books[query.Limit-1])
+	}
+
+	return page, nil
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+func (s *SQLiteStore) AddBook(ctx context.Context, book Books) (Books, error) {
+	added, err := s.AddBooks(ctx, []Books{book})
+	if err != nil {
+		return Books{}, err
+	}
+	return added[0], nil
+}
+
+// AddBooks adds every book or none of them.
+func (s *SQLiteStore) AddBooks(ctx context.Context, books []Books) ([]Books, error) {
+	now := time.Now()
+	added := make([]Books, len(books))
+
+	err := s.inTx(ctx, func(tx *sqliteTx) error {
+		for i, book := range books {
+			// the history outlives a purge, so it remembers every ID handed out
+			if book.ID != 0 {
+				var used bool
+				err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM book_history WHERE book_id = ?)`, book.ID).Scan(&used)
+				if err != nil {
+					return err
+				}
+				if used {
+					return fmt.Errorf("%w: %d", ErrDuplicateID, book.ID)
+				}
+			}
+			var err error
+			if added[i], err = insertBook(ctx, tx, book, now); err != nil {
+				return err
+			}
+			if err := insertHistory(ctx, tx, newHistoryEntry(ctx, HistoryAdd, now, nil, &added[i])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return added, nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func insertBook(ctx context.Context, db execer, book Books, now time.Time) (Books, error) {
+	book.Version = 1
+	book.CreatedAt = now
+	book.UpdatedAt = now
+	book.DeletedAt = time.Time{}
+
+	// a zero ID goes in as NULL so SQLite allocates the next one
+	var id any
+	if book.ID != 0 {
+		id = book.ID
+	}
+
+	result, err := db.ExecContext(ctx, `INSERT INTO books (`+bookColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, NULL)`,
+		id, book.Title, book.Author, book.Genre, book.Rating, book.Version, formatTime(book.CreatedAt), formatTime(book.UpdatedAt))
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY {
+		return Books{}, fmt.Errorf("%w: %d", ErrDuplicateID, book.ID)
+	}
+	if err != nil {
+		return Books{}, err
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return Books{}, err
+	}
+	book.ID = int(newID)
+
+	return book, nil
+}
+
+// UpdateBook replaces a book. A non-zero book.Version makes the update
+// conditional on the stored book still being at that version.
+func (s *SQLiteStore) UpdateBook(ctx context.Context, book Books) (Books, error) {
+	err := s.inTx(ctx, func(tx *sqliteTx) error {
+		existing, err := liveBook(ctx, tx, book.ID)
+		if err != nil {
+			return err
+		}
+		if book.Version != 0 && book.Version != existing.Version {
+			return ErrVersionConflict
+		}
+
+		book.Version = existing.Version + 1
+		book.CreatedAt = existing.CreatedAt
+		book.UpdatedAt = time.Now()
+		book.DeletedAt = time.Time{}
+		return saveChange(ctx, tx, HistoryUpdate, existing, book)
+	})
+	if err != nil {
+		return Books{}, err
+	}
+
+	return book, nil
+}
+
+// DeleteBook moves a book to the trash, from where RestoreBook can bring it
+// back until it is purged. A non-zero version makes the delete conditional on
+// the book still being at that version.
+func (s *SQLiteStore) DeleteBook(ctx context.Context, bookID, version int) error {
+	return s.inTx(ctx, func(tx *sqliteTx) error {
+		existing, err := liveBook(ctx, tx, bookID)
+		if err != nil {
+			return err
+		}
+		if version != 0 && version != existing.Version {
+			return ErrVersionConflict
+		}
+
+		book := existing
+		book.Version++
+		book.UpdatedAt = time.Now()
+		book.DeletedAt = book.UpdatedAt
+		return saveChange(ctx, tx, HistoryDelete, existing, book)
+	})
+}
+
+func (s *SQLiteStore) CountBooks(ctx context.Context) (BookCounts, error) {
+	counts := BookCounts{ByGenre: map[string]int{}}
+	rows, err := s.db.QueryContext(ctx, `SELECT genre, COUNT(*) FROM books WHERE `+notDeleted+` GROUP BY genre`)
+	if err != nil {
+		return BookCounts{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			genre string
+			n     int
+		)
+		if err := rows.Scan(&genre, &n); err != nil {
+			return BookCounts{}, err
+		}
+		counts.ByGenre[genre] = n
+		counts.Live += n
+	}
+	if err := rows.Err(); err != nil {
+		return BookCounts{}, err
+	}
+
+	err = s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM books WHERE deleted_at IS NOT NULL`).Scan(&counts.Trashed)
+	if err != nil {
+		return BookCounts{}, err
+	}
+	return counts, nil
+}
+
+// GetDeletedBooks lists the trash, most recently deleted first.
+func (s *SQLiteStore) GetDeletedBooks(ctx context.Context) ([]Books, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+bookColumns+` FROM books
+		WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := []Books{}
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+
+	return books, rows.Err()
+}
+
+// RestoreBook takes a book back out of the trash.
+func (s *SQLiteStore) RestoreBook(ctx context.Context, bookID int) (Books, error) {
+	var book Books
+	err := s.inTx(ctx, func(tx *sqliteTx) error {
+		trashed, err := storedBook(ctx, tx, bookID)
+		if err != nil {
+			return err
+		}
+		if trashed.DeletedAt.IsZero() {
+			return ErrBookNotFound
+		}
+
+		book = trashed
+		book.Version++
+		book.UpdatedAt = time.Now()
+		book.DeletedAt = time.Time{}
+		return saveChange(ctx, tx, HistoryRestore, trashed, book)
+	})
+	if err != nil {
+		return Books{}, err
+	}
+
+	return book, nil
+}
+
+// PurgeDeletedBooks permanently removes the books trashed before the cutoff
+// and reports how many went.
+func (s *SQLiteStore) PurgeDeletedBooks(ctx context.Context, before time.Time) (int, error) {
+	purged := 0
+	err := s.inTx(ctx, func(tx *sqliteTx) error {
+		rows, err := tx.QueryContext(ctx, `SELECT `+bookColumns+` FROM books WHERE deleted_at < ?`, formatTime(before))
+		if err != nil {
+			return err
+		}
+		var books []Books
+		for rows.Next() {
+			book, err := scanBook(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			books = append(books, book)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, book := range books {
+			if err := insertHistory(ctx, tx, newHistoryEntry(ctx, HistoryPurge, now, &book, nil)); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `DELETE FROM books WHERE id = ?`, book.ID); err != nil {
+				return err
+			}
+		}
+		purged = len(books)
+		return nil
+	})
+
+	return purged, err
+}
+
+// sqliteTx collects the history written in a transaction, so the change
+// hooks can be told once it commits.
+type sqliteTx struct {
+	*sql.Tx
+	changes []HistoryEntry
+}
+
+// inTx runs fn in a transaction that is committed only if fn succeeds. The
+// DSN makes transactions take the write lock up front, so a read followed by
+// a write inside fn cannot be interleaved with another writer.
+func (s *SQLiteStore) inTx(ctx context.Context, fn func(tx *sqliteTx) error) error {
+	sqlTx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer sqlTx.Rollback()
+
+	tx := &sqliteTx{Tx: sqlTx}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+	if err := sqlTx.Commit(); err != nil {
+		return err
+	}
+
+	s.hooksMu.RLock()
+	defer s.hooksMu.RUnlock()
+	for _, change := range tx.changes {
+		for _, hook := range s.hooks {
+			hook(change)
+		}
+	}
+	return nil
+}
+
+// OnChange registers a hook that is called after every committed change.
+// Hooks must be quick, they run on the writer's goroutine.
+func (s *SQLiteStore) OnChange(hook func(HistoryEntry)) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+
+	s.hooks = append(s.hooks, hook)
+}
+
+// storedBook reads a book whether or not it is in the trash.
+func storedBook(ctx context.Context, tx *sqliteTx, bookID int) (Books, error) {
+	book, err := scanBook(tx.QueryRowContext(ctx, `SELECT `+bookColumns+` FROM books WHERE id = ?`, bookID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Books{}, ErrBookNotFound
+	}
+	return book, err
+}
+
+func liveBook(ctx context.Context, tx *sqliteTx, bookID int) (Books, error) {
+	book, err := storedBook(ctx, tx, bookID)
+	if err == nil && !book.DeletedAt.IsZero() {
+		return Books{}, ErrBookNotFound
+	}
+	return book, err
+}
+
+// saveChange writes the new state of an existing book together with the
+// history entry describing the change.
+func saveChange(ctx context.Context, tx *sqliteTx, operation string, before, after Books) error {
+	var deletedAt any
+	if !after.DeletedAt.IsZero() {
+		deletedAt = formatTime(after.DeletedAt)
+	}
+
+	_, err := tx.ExecContext(ctx, `UPDATE books SET title = ?, author = ?, genre = ?, rating = ?, version = ?,
+			updated_at = ?, deleted_at = ?
+		WHERE id = ?`,
+		after.Title, after.Author, after.Genre, after.Rating, after.Version,
+		formatTime(after.UpdatedAt), deletedAt, after.ID)
+	if err != nil {
+		return err
+	}
+
+	return insertHistory(ctx, tx, newHistoryEntry(ctx, operation, after.UpdatedAt, &before, &after))
+}
+
+func (s *SQLiteStore) SearchBooks(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	if err := validateSearch(query, limit); err != nil {
+		return nil, err
+	}
+
+	// every token is matched as a prefix, and FTS5 ANDs them together
+	terms := tokenize(query)
+	for i, term := range terms {
+		terms[i] = `"` + term + `"*`
+	}
+
+	if limit == 0 {
+		limit = -1
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+bookColumns+`, -hits.rank FROM books
+		JOIN (SELECT rowid, bm25(books_fts, ?, ?, ?) AS rank FROM books_fts WHERE books_fts MATCH ?) AS hits
+		ON hits.rowid = books.id
+		WHERE books.`+notDeleted+`
+		ORDER BY hits.rank, books.id
+		LIMIT ?`,
+		titleWeight, authorWeight, genreWeight, strings.Join(terms, " "), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+		if result.Book, err = scanBook(withExtra(rows, &result.Score)); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// extraScanner scans the book columns plus trailing computed columns
+type extraScanner struct {
+	row   rowScanner
+	extra []any
+}
+
+func withExtra(row rowScanner, extra ...any) rowScanner {
+	return extraScanner{row: row, extra: extra}
+}
+
+func (s extraScanner) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
+
+func scanBook(row rowScanner) (Books, error) {
+	var (
+		book                 Books
+		createdAt, updatedAt string
+		deletedAt            sql.NullString
+		err                  error
+	)
+
+	if err = row.Scan(&book.ID, &book.Title, &book.Author, &book.Genre, &book.Rating, &book.Version, &createdAt, &updatedAt, &deletedAt); err != nil {
+		return Books{}, err
+	}
+
+	if book.CreatedAt, err = parseTime(createdAt); err != nil {
+		return Books{}, err
+	}
+	if book.UpdatedAt, err = parseTime(updatedAt); err != nil {
+		return Books{}, err
+	}
+	if deletedAt.Valid {
+		if book.DeletedAt, err = parseTime(deletedAt.String); err != nil {
+			return Books{}, err
+		}
+	}
+
+	return book, nil
+}
+
+// timestamps are stored as fixed-width UTC text so they sort correctly in SQL
+const sqliteTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeLayout)
+}
+
+func parseTime(value string) (time.Time, error) {
+	t, err := time.Parse(sqliteTimeLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse timestamp %q: %w", value, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func openSQLiteStore(t *testing.T, path string) *SQLiteStore {
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore() failed: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	seedDemo(t, store)
+
+	return store
+}
+
+func TestSQLiteStoreMigrations(t *testing.T) {
+	store := openSQLiteStore(t, filepath.Join(t.TempDir(), "books.db"))
+	ctx := context.Background()
+
+	var version int
+	if err := store.db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		t.Fatalf("reading schema version failed: %v", err)
+	}
+	if want := migrations[len(migrations)-1].version; version != want {
+		t.Errorf("expected schema version %d, got %d", want, version)
+	}
+
+	for _, index := range []string{"idx_books_author", "idx_books_genre"} {
+		var name string
+		err := store.db.QueryRowContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'index' AND name = ?`, index).Scan(&name)
+		if err != nil {
+			t.Errorf("expected index %s to exist: %v", index, err)
+		}
+	}
+
+	// running the migrations again must be a no-op
+	previous, err := migrate(ctx, store.db)
+	if err != nil {
+		t.Fatalf("re-running migrations failed: %v", err)
+	}
+	if previous != version {
+		t.Errorf("expected migrate to report version %d, got %d", version, previous)
+	}
+}
+
+func TestSQLiteStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "books.db")
+	store := openSQLiteStore(t, path)
+	ctx := context.Background()
+
+	added, err := store.AddBook(ctx, Books{Title: "Dune", Author: "Frank Herbert", Genre: "Science Fiction", Rating: 4.6})
+	if err != nil {
+		t.Fatalf("AddBook() failed: %v", err)
+	}
+	store.Close()
+
+	// reopening must neither re-run the seed nor lose the new book
+	store = openSQLiteStore(t, path)
+	if n := countBooks(t, store); n != 4 {
+		t.Fatalf("expected 4 books after restart, got %d", n)
+	}
+
+	book, err := store.GetBook(ctx, added.ID)
+	if err != nil {
+		t.Fatalf("GetBook() failed: %v", err)
+	}
+	if !book.CreatedAt.Equal(added.CreatedAt) || !book.UpdatedAt.Equal(added.UpdatedAt) {
+		t.Errorf("timestamps changed across restart: got %v/%v, want %v/%v",
+			book.CreatedAt, book.UpdatedAt, added.CreatedAt, added.UpdatedAt)
+	}
+}
+
+func TestSQLiteStoreAddBooksIsAtomic(t *testing.T) {
+	store := openSQLiteStore(t, filepath.Join(t.TempDir(), "books.db"))
+
+	// the second book collides with a seeded ID, so neither may be stored
+	_, err := store.AddBooks(context.Background(), []Books{
+		{Title: "Dune", Author: "Frank Herbert", Genre: "Science Fiction", Rating: 4.6},
+		{ID: 1, Title: "Emma", Author: "Jane Austen", Genre: "Fiction", Rating: 3.9},
+	})
+	if err == nil {
+		t.Fatal("expected AddBooks to fail on a duplicate ID")
+	}
+
+	if n := countBooks(t, store); n != 3 {
+		t.Errorf("expected the failed batch to be rolled back, got %d books", n)
+	}
+}
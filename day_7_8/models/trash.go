@@ -0,0 +1,41 @@
+package models
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"slices"
+	"time"
+)
+
+// sortTrash orders the trash most recently deleted first, ties by ID
+func sortTrash(books []Books) {
+	slices.SortFunc(books, func(a, b Books) int {
+		if c := b.DeletedAt.Compare(a.DeletedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
+
+// PurgeTrash hard-deletes the books that have been in the trash longer than
+// retention, once straight away and then every interval until ctx is done.
+func PurgeTrash(ctx context.Context, store BookRepository, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := store.PurgeDeletedBooks(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("purging the trash failed: %v", err)
+		} else if purged > 0 {
+			log.Printf("purged %d books from the trash", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
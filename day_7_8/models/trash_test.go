@@ -0,0 +1,105 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSoftDelete(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		ctx := context.Background()
+
+		if err := store.DeleteBook(ctx, 1, 0); err != nil {
+			t.Fatalf("DeleteBook() failed: %v", err)
+		}
+		if _, err := store.GetBook(ctx, 1); !errors.Is(err, ErrBookNotFound) {
+			t.Errorf("expected a trashed book to be hidden, got %v", err)
+		}
+		if got := searchTitles(t, store, "gatsby"); len(got) != 0 {
+			t.Errorf("expected a trashed book to be unsearchable, got %v", got)
+		}
+		if _, err := store.UpdateBook(ctx, Books{ID: 1, Title: "Revived", Author: "Nobody", Genre: "Fiction"}); !errors.Is(err, ErrBookNotFound) {
+			t.Errorf("expected updating a trashed book to fail with ErrBookNotFound, got %v", err)
+		}
+		if err := store.DeleteBook(ctx, 1, 0); !errors.Is(err, ErrBookNotFound) {
+			t.Errorf("expected deleting a trashed book again to fail with ErrBookNotFound, got %v", err)
+		}
+
+		trash, err := store.GetDeletedBooks(ctx)
+		if err != nil {
+			t.Fatalf("GetDeletedBooks() failed: %v", err)
+		}
+		if len(trash) != 1 || trash[0].ID != 1 || trash[0].DeletedAt.IsZero() || trash[0].Version != 2 {
+			t.Fatalf("expected book 1 in the trash at version 2, got %+v", trash)
+		}
+
+		restored, err := store.RestoreBook(ctx, 1)
+		if err != nil {
+			t.Fatalf("RestoreBook() failed: %v", err)
+		}
+		if !restored.DeletedAt.IsZero() || restored.Version != 3 || restored.Title != "The Great Gatsby" {
+			t.Errorf("unexpected restored book %+v", restored)
+		}
+		if got := searchTitles(t, store, "gatsby"); len(got) != 1 {
+			t.Errorf("expected a restored book to be searchable again, got %v", got)
+		}
+		if _, err := store.RestoreBook(ctx, 1); !errors.Is(err, ErrBookNotFound) {
+			t.Errorf("expected restoring a live book to fail with ErrBookNotFound, got %v", err)
+		}
+	})
+}
+
+func TestPurgeDeletedBooks(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, store BookRepository) {
+		ctx := context.Background()
+
+		for _, id := range []int{1, 2} {
+			if err := store.DeleteBook(ctx, id, 0); err != nil {
+				t.Fatalf("DeleteBook(%d) failed: %v", id, err)
+			}
+		}
+
+		purged, err := store.PurgeDeletedBooks(ctx, time.Now().Add(-time.Hour))
+		if err != nil || purged != 0 {
+			t.Fatalf("expected nothing older than the retention to be purged, got %d, %v", purged, err)
+		}
+
+		purged, err = store.PurgeDeletedBooks(ctx, time.Now().Add(time.Second))
+		if err != nil || purged != 2 {
+			t.Fatalf("expected 2 books purged, got %d, %v", purged, err)
+		}
+		if trash, _ := store.GetDeletedBooks(ctx); len(trash) != 0 {
+			t.Errorf("expected an empty trash, got %+v", trash)
+		}
+		if _, err := store.RestoreBook(ctx, 1); !errors.Is(err, ErrBookNotFound) {
+			t.Errorf("expected a purged book to be gone for good, got %v", err)
+		}
+		if n := countBooks(t, store); n != 1 {
+			t.Errorf("expected 1 live book, got %d", n)
+		}
+	})
+}
+
+func TestFileStoreTrashSurvivesRestart(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "books")
+	store := reopenFileStore(t, nil, dir)
+	ctx := context.Background()
+
+	if err := store.DeleteBook(ctx, 2, 0); err != nil {
+		t.Fatalf("DeleteBook() failed: %v", err)
+	}
+	store = reopenFileStore(t, store, dir)
+	trash, err := store.GetDeletedBooks(ctx)
+	if err != nil {
+		t.Fatalf("GetDeletedBooks() failed: %v", err)
+	}
+	if len(trash) != 1 || trash[0].ID != 2 {
+		t.Fatalf("expected book 2 still in the trash after restart, got %+v", trash)
+	}
+	if got := searchTitles(t, store, "mockingbird"); len(got) != 0 {
+		t.Errorf("expected the reloaded trash to stay out of search, got %v", got)
+	}
+}
@@ -0,0 +1,224 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// codes a client can switch on, one per kind of broken rule
+const (
+	CodeRequired     = "required"
+	CodeTooLong      = "too_long"
+	CodeOutOfRange   = "out_of_range"
+	CodeNotAllowed   = "not_allowed"
+	CodeInvalidType  = "invalid_type"
+	CodeUnknownField = "unknown_field"
+	CodeDuplicate    = "duplicate"
+)
+
+var AllowedGenres = []string{
+	"Biography", "Children", "Drama", "Dystopian", "Fantasy", "Fiction", "History", "Horror",
+	"Mystery", "Non-Fiction", "Poetry", "Romance", "Satire", "Science Fiction", "Tech", "Thriller",
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every broken rule, not just the first one.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		messages[i] = fieldErr.Message
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) add(field, code, format string, args ...any) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+func (e *ValidationError) orNil() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// bookField is one field of Books with the rules from its validate tag
+type bookField struct {
+	index int
+	name  string
+	rules []rule
+}
+
+type rule struct {
+	name  string
+	param string
+}
+
+// bookFields parses the json and validate tags of Books once
+var bookFields = sync.OnceValue(func() []bookField {
+	bookType := reflect.TypeFor[Books]()
+	fields := make([]bookField, 0, bookType.NumField())
+
+	for i := range bookType.NumField() {
+		structField := bookType.Field(i)
+		field := bookField{index: i, name: strings.Split(structField.Tag.Get("json"), ",")[0]}
+
+		if tag := structField.Tag.Get("validate"); tag != "" {
+			for part := range strings.SplitSeq(tag, ",") {
+				name, param, _ := strings.Cut(part, "=")
+				field.rules = append(field.rules, rule{name: name, param: param})
+			}
+		}
+		fields = append(fields, field)
+	}
+
+	return fields
+})
+
+// DecodeBook decodes a JSON object into a book and reports which fields the
+// client actually sent, null counting as not sent. Fields of the wrong type
+// all come back together as a ValidationError, and so do unknown fields
+// when strict is set. Broken JSON syntax is returned as the decoder's error.
+func DecodeBook(data []byte, strict bool) (Books, map[string]bool, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return Books{}, nil, &ValidationError{Errors: []FieldError{
+				{Code: CodeInvalidType, Message: "a book must be a JSON object"},
+			}}
+		}
+		return Books{}, nil, err
+	}
+
+	var (
+		book     Books
+		provided = map[string]bool{}
+		invalid  = &ValidationError{}
+		value    = reflect.ValueOf(&book).Elem()
+	)
+
+	for _, field := range bookFields() {
+		data, exists := raw[field.name]
+		delete(raw, field.name)
+		if !exists || string(data) == "null" {
+			continue
+		}
+
+		target := value.Field(field.index)
+		if err := json.Unmarshal(data, target.Addr().Interface()); err != nil {
+			invalid.add(field.name, CodeInvalidType, "%s must be %s", field.name, typeName(target.Kind()))
+			continue
+		}
+		provided[field.name] = true
+	}
+
+	if strict {
+		unknown := make([]string, 0, len(raw))
+		for name := range raw {
+			unknown = append(unknown, name)
+		}
+		slices.Sort(unknown)
+		for _, name := range unknown {
+			invalid.add(name, CodeUnknownField, "%s is not a field of a book", name)
+		}
+	}
+
+	return book, provided, invalid.orNil()
+}
+
+func typeName(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Float64:
+		return "a number"
+	default:
+		return "a timestamp"
+	}
+}
+
+// ValidateBook checks a book against the rules in the validate tags of
+// Books. provided holds the JSON fields the client sent, which is how a
+// rating of 0 is told apart from a missing one; nil treats every field as
+// sent.
+func ValidateBook(book Books, provided map[string]bool) error {
+	var (
+		invalid = &ValidationError{}
+		value   = reflect.ValueOf(book)
+	)
+
+	for _, field := range bookFields() {
+		fieldValue := value.Field(field.index)
+
+		for _, rule := range field.rules {
+			if !checkRule(invalid, field.name, rule, fieldValue, provided) {
+				// later rules on a missing or broken field only add noise
+				break
+			}
+		}
+	}
+
+	return invalid.orNil()
+}
+
+func checkRule(invalid *ValidationError, name string, r rule, value reflect.Value, provided map[string]bool) bool {
+	switch r.name {
+	case "required":
+		missing := provided != nil && !provided[name]
+		if value.Kind() == reflect.String {
+			missing = missing || strings.TrimSpace(value.String()) == ""
+		}
+		if missing {
+			invalid.add(name, CodeRequired, "%s is required", name)
+			return false
+		}
+
+	case "max", "min":
+		limit, _ := strconv.ParseFloat(r.param, 64)
+		if value.Kind() == reflect.String {
+			if r.name == "max" && float64(utf8.RuneCountInString(value.String())) > limit {
+				invalid.add(name, CodeTooLong, "%s must be at most %s characters", name, r.param)
+				return false
+			}
+			break
+		}
+
+		number := value.Float()
+		if r.name == "min" && number < limit {
+			invalid.add(name, CodeOutOfRange, "%s must be at least %s", name, r.param)
+			return false
+		}
+		if r.name == "max" && number > limit {
+			invalid.add(name, CodeOutOfRange, "%s must be at most %s", name, r.param)
+			return false
+		}
+
+	case "genre":
+		if !slices.Contains(AllowedGenres, value.String()) {
+			invalid.add(name, CodeNotAllowed, "%s must be one of: %s", name, strings.Join(AllowedGenres, ", "))
+			return false
+		}
+
+	default:
+		panic(fmt.Sprintf("models: unknown validation rule %q on %s", r.name, name))
+	}
+
+	return true
+}
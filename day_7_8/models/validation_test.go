@@ -0,0 +1,63 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateBook(t *testing.T) {
+	testCases := []struct {
+		name  string
+		body  string
+		codes []string
+	}{
+		{name: "Valid", body: `{"title":"Emma","author":"Jane Austen","genre":"Fiction","rating":3.9}`},
+		{name: "Zero Rating Is Valid", body: `{"title":"Emma","author":"Jane Austen","genre":"Fiction","rating":0}`},
+		{name: "Missing Rating", body: `{"title":"Emma","author":"Jane Austen","genre":"Fiction"}`, codes: []string{CodeRequired}},
+		{name: "Null Rating", body: `{"title":"Emma","author":"Jane Austen","genre":"Fiction","rating":null}`, codes: []string{CodeRequired}},
+		{name: "Blank Title", body: `{"title":"  ","author":"Jane Austen","genre":"Fiction","rating":3}`, codes: []string{CodeRequired}},
+		{name: "Title Too Long", body: `{"title":"` + strings.Repeat("é", 201) + `","author":"Jane Austen","genre":"Fiction","rating":3}`, codes: []string{CodeTooLong}},
+		{name: "Rating Out Of Range", body: `{"title":"Emma","author":"Jane Austen","genre":"Fiction","rating":-1}`, codes: []string{CodeOutOfRange}},
+		{name: "Unknown Genre", body: `{"title":"Emma","author":"Jane Austen","genre":"Cooking","rating":3}`, codes: []string{CodeNotAllowed}},
+		{name: "Wrong Type", body: `{"title":1,"author":"Jane Austen","genre":"Fiction","rating":"high"}`, codes: []string{CodeInvalidType, CodeInvalidType}},
+		{name: "Every Error Reported", body: `{}`, codes: []string{CodeRequired, CodeRequired, CodeRequired, CodeRequired}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			book, provided, err := DecodeBook([]byte(tc.body), false)
+			if err == nil {
+				err = ValidateBook(book, provided)
+			}
+
+			var codes []string
+			var invalid *ValidationError
+			if errors.As(err, &invalid) {
+				for _, fieldErr := range invalid.Errors {
+					codes = append(codes, fieldErr.Code)
+				}
+			} else if err != nil {
+				t.Fatalf("expected a ValidationError, got %v", err)
+			}
+
+			if !slices.Equal(codes, tc.codes) {
+				t.Errorf("expected codes %v, got %v (%v)", tc.codes, codes, err)
+			}
+		})
+	}
+}
+
+func TestDecodeBookStrict(t *testing.T) {
+	_, _, err := DecodeBook([]byte(`{"title":"Emma","isbn":"x"}`), true)
+
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || len(invalid.Errors) != 1 || invalid.Errors[0].Field != "isbn" || invalid.Errors[0].Code != CodeUnknownField {
+		t.Errorf("expected one unknown_field error on isbn, got %v", err)
+	}
+
+	if _, _, err := DecodeBook([]byte(`{"title":"Emma","isbn":"x"}`), false); err != nil {
+		t.Errorf("expected unknown fields to be ignored when not strict, got %v", err)
+	}
+}
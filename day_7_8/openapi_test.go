@@ -0,0 +1,98 @@
+package day7
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang-training/day_7_8/config"
+	"golang-training/day_7_8/docs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestOpenAPICoversRoutes fails when a route is registered without being
+// described, or described without being registered.
+func TestOpenAPICoversRoutes(t *testing.T) {
+	cfg := config.Default()
+	cfg.Mode = "test"
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer() failed: %v", err)
+	}
+	t.Cleanup(func() { server.close() })
+
+	spec := docs.Spec()
+	registered := map[string]bool{}
+	for _, route := range server.Handler.(*gin.Engine).Routes() {
+		path, method := docs.PathFromRoute(route.Path), strings.ToLower(route.Method)
+		registered[method+" "+path] = true
+		if spec.Paths[path][method] == nil {
+			t.Errorf("%s %s is not in the OpenAPI document", route.Method, route.Path)
+		}
+	}
+	for path, item := range spec.Paths {
+		for method := range item {
+			if !registered[method+" "+path] {
+				t.Errorf("the OpenAPI document describes %s %s, which is not registered", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	cfg := config.Default()
+	cfg.Mode = "test"
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer() failed: %v", err)
+	}
+	t.Cleanup(func() { server.close() })
+
+	res := httptest.NewRecorder()
+	server.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.Code)
+	}
+	var document map[string]any
+	if err := json.Unmarshal(res.Body.Bytes(), &document); err != nil {
+		t.Fatalf("expected a JSON document: %v", err)
+	}
+	if document["openapi"] != "3.0.3" {
+		t.Errorf("expected an OpenAPI 3 document, got version %v", document["openapi"])
+	}
+
+	// every $ref has to point at something in the document
+	var walk func(node any)
+	walk = func(node any) {
+		switch node := node.(type) {
+		case map[string]any:
+			if ref, ok := node["$ref"].(string); ok {
+				var target any = document
+				for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+					object, _ := target.(map[string]any)
+					target = object[key]
+				}
+				if target == nil {
+					t.Errorf("%s points at nothing", ref)
+				}
+			}
+			for _, child := range node {
+				walk(child)
+			}
+		case []any:
+			for _, child := range node {
+				walk(child)
+			}
+		}
+	}
+	walk(document)
+
+	res = httptest.NewRecorder()
+	server.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if res.Code != http.StatusOK || !strings.Contains(res.Header().Get("Content-Type"), "text/html") || !strings.Contains(res.Body.String(), "openapi.json") {
+		t.Errorf("expected the docs page, got %d %s", res.Code, res.Header().Get("Content-Type"))
+	}
+}
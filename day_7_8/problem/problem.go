@@ -0,0 +1,107 @@
+// Package problem writes errors as RFC 7807 problem details, so a client
+// sees the same shape whichever controller or middleware turned it away.
+package problem
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType is what every problem is served as.
+const ContentType = "application/problem+json"
+
+// requestIDHeader is where Logger echoes the request ID on the response
+const requestIDHeader = "X-Request-ID"
+
+// Kind is one sort of problem. Every problem of a kind has the same type,
+// title and status; only the detail says what went wrong this time.
+type Kind struct {
+	Type   string
+	Title  string
+	Status int
+}
+
+var (
+	BadRequest           = Kind{"/problems/bad-request", "Bad request", http.StatusBadRequest}
+	InvalidBook          = Kind{"/problems/invalid-book", "Book failed validation", http.StatusBadRequest}
+	Unauthorized         = Kind{"/problems/unauthorized", "Authentication required", http.StatusUnauthorized}
+	Forbidden            = Kind{"/problems/forbidden", "Permission denied", http.StatusForbidden}
+	NotFound             = Kind{"/problems/not-found", "Not found", http.StatusNotFound}
+	MethodNotAllowed     = Kind{"/problems/method-not-allowed", "Method not allowed", http.StatusMethodNotAllowed}
+	DuplicateID          = Kind{"/problems/duplicate-id", "Book ID already taken", http.StatusConflict}
+	VersionConflict      = Kind{"/problems/version-conflict", "Book was modified", http.StatusConflict}
+	PreconditionFailed   = Kind{"/problems/precondition-failed", "Precondition failed", http.StatusPreconditionFailed}
+	TooLarge             = Kind{"/problems/too-large", "Request too large", http.StatusRequestEntityTooLarge}
+	UnsupportedMediaType = Kind{"/problems/unsupported-media-type", "Unsupported media type", http.StatusUnsupportedMediaType}
+	BulkRejected         = Kind{"/problems/bulk-rejected", "Bulk import rolled back", http.StatusUnprocessableEntity}
+	IdempotencyKeyReused = Kind{"/problems/idempotency-key-reused", "Idempotency key reused", http.StatusUnprocessableEntity}
+	IdempotencyInFlight  = Kind{"/problems/idempotency-in-flight", "Request still in progress", http.StatusConflict}
+	RateLimited          = Kind{"/problems/rate-limited", "Too many requests", http.StatusTooManyRequests}
+	Internal             = Kind{"/problems/internal", "Internal server error", http.StatusInternalServerError}
+)
+
+// Kinds lists every kind, for the API description.
+var Kinds = []Kind{
+	BadRequest, InvalidBook, Unauthorized, Forbidden, NotFound, MethodNotAllowed, DuplicateID, VersionConflict,
+	PreconditionFailed, TooLarge, UnsupportedMediaType, BulkRejected, IdempotencyKeyReused, IdempotencyInFlight,
+	RateLimited, Internal,
+}
+
+// Details is the body of a problem response.
+type Details struct {
+	Type     string
+	Title    string
+	Status   int
+	Detail   string
+	Instance string
+	// RequestID lets a client quote the request the server logged
+	RequestID string
+	// Extensions are members of their own, such as the broken fields of
+	// an invalid book. They cannot replace the members above.
+	Extensions map[string]any
+}
+
+func (d Details) MarshalJSON() ([]byte, error) {
+	members := maps.Clone(d.Extensions)
+	if members == nil {
+		members = map[string]any{}
+	}
+	members["type"] = d.Type
+	members["title"] = d.Title
+	members["status"] = d.Status
+	members["detail"] = d.Detail
+	members["instance"] = d.Instance
+	if d.RequestID != "" {
+		members["request_id"] = d.RequestID
+	}
+	return json.Marshal(members)
+}
+
+// Abort answers with a problem of the given kind and stops the handlers
+// after this one from running.
+func Abort(c *gin.Context, kind Kind, detail string) {
+	AbortWith(c, kind, detail, nil)
+}
+
+// AbortWith is Abort with extension members.
+func AbortWith(c *gin.Context, kind Kind, detail string, extensions map[string]any) {
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(kind.Status, New(c, kind, detail, extensions))
+}
+
+// New builds the problem Abort would send, for callers that answer some
+// other way.
+func New(c *gin.Context, kind Kind, detail string, extensions map[string]any) Details {
+	return Details{
+		Type:       kind.Type,
+		Title:      kind.Title,
+		Status:     kind.Status,
+		Detail:     detail,
+		Instance:   c.Request.URL.Path,
+		RequestID:  c.Writer.Header().Get(requestIDHeader),
+		Extensions: extensions,
+	}
+}
@@ -0,0 +1,70 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAbort(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	testCases := []struct {
+		name       string
+		requestID  string
+		extensions map[string]any
+		expected   map[string]any
+	}{
+		{
+			name: "Plain",
+			expected: map[string]any{
+				"type": NotFound.Type, "title": NotFound.Title, "status": float64(404), "detail": "Book not found", "instance": "/books/7",
+			},
+		},
+		{
+			name:      "With Request ID",
+			requestID: "abc123",
+			expected: map[string]any{
+				"type": NotFound.Type, "title": NotFound.Title, "status": float64(404), "detail": "Book not found", "instance": "/books/7",
+				"request_id": "abc123",
+			},
+		},
+		{
+			name:       "Extensions Cannot Replace Members",
+			extensions: map[string]any{"roles": []string{"admin"}, "status": 200, "type": "about:blank"},
+			expected: map[string]any{
+				"type": NotFound.Type, "title": NotFound.Title, "status": float64(404), "detail": "Book not found", "instance": "/books/7",
+				"roles": []any{"admin"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/books/:id", func(c *gin.Context) {
+				if tc.requestID != "" {
+					c.Header(requestIDHeader, tc.requestID)
+				}
+				AbortWith(c, NotFound, "Book not found", tc.extensions)
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/books/7?fields=title", nil))
+
+			if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != ContentType {
+				t.Fatalf("expected 404 as %s, got %d as %s", ContentType, w.Code, w.Header().Get("Content-Type"))
+			}
+			var body map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding the problem failed: %v", err)
+			}
+			if !reflect.DeepEqual(body, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, body)
+			}
+		})
+	}
+}
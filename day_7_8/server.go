@@ -0,0 +1,109 @@
+package day7
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Server serves the books API until its context is done, then drains the
+// requests in flight and closes what it was given to close.
+type Server struct {
+	Addr    string
+	Handler http.Handler
+
+	// ReadHeaderTimeout and ReadTimeout bound reading a request,
+	// WriteTimeout writing the response and IdleTimeout a kept-alive
+	// connection waiting for the next request. Event streams lift the write
+	// timeout for themselves.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout is how long requests in flight get to finish once the
+	// context is done; any still running after that are cut off.
+	ShutdownTimeout time.Duration
+
+	// OnShutdown runs in order when shutdown starts, before draining, to end
+	// anything that would otherwise keep a request open, such as streams.
+	OnShutdown []func()
+	// BeforeClose runs in order ahead of the Closers, however the server
+	// ends, to stop background work that uses them, such as the trash purge.
+	BeforeClose []func()
+	// Closers are closed in order once the last request is done, e.g. the
+	// store, so nothing is written to it after it is flushed.
+	Closers []io.Closer
+}
+
+// Run listens on Addr and serves until ctx is done.
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		s.close()
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves on listener until ctx is done, and is what tests use to run
+// the server on a port of their choosing. It returns nil after a clean
+// shutdown.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{
+		Handler:           s.Handler,
+		ReadHeaderTimeout: s.ReadHeaderTimeout,
+		ReadTimeout:       s.ReadTimeout,
+		WriteTimeout:      s.WriteTimeout,
+		IdleTimeout:       s.IdleTimeout,
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+	slog.Info("listening", "addr", listener.Addr().String())
+
+	select {
+	case err := <-served:
+		// the listener failed before anyone asked us to stop
+		return errors.Join(err, s.close())
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down", "timeout", s.ShutdownTimeout.String())
+	for _, hook := range s.OnShutdown {
+		hook()
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+	defer cancel()
+	var drainErr error
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		server.Close()
+		drainErr = fmt.Errorf("requests still running after %s: %w", s.ShutdownTimeout, err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		drainErr = errors.Join(drainErr, err)
+	}
+
+	return errors.Join(drainErr, s.close())
+}
+
+func (s *Server) close() error {
+	for _, stop := range s.BeforeClose {
+		stop()
+	}
+
+	var errs []error
+	for _, closer := range s.Closers {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,300 @@
+package day7
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang-training/day_7_8/config"
+	"golang-training/day_7_8/models"
+)
+
+// serve runs server on a free port and returns its URL, a way to stop it
+// and the channel Serve's result arrives on.
+func serve(t *testing.T, server *Server) (string, context.CancelFunc, <-chan error) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening failed: %v", err)
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	t.Cleanup(stop)
+	result := make(chan error, 1)
+	go func() {
+		result <- server.Serve(ctx, listener)
+	}()
+
+	return "http://" + listener.Addr().String(), stop, result
+}
+
+func waitFor(t *testing.T, result <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Serve did not return")
+		return nil
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.Mode = "test"
+	cfg.Store.Backend = "file"
+	cfg.Store.Path = dir
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer() failed: %v", err)
+	}
+	url, stop, result := serve(t, server)
+
+	// a pooled client may dial a spare connection that sits unused, which
+	// Shutdown waits 5 seconds for before calling it idle
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	res, err := client.Post(url+"/books", "application/json", strings.NewReader(`{"title":"Kept","author":"Clerk","genre":"Drama","rating":4}`))
+	if err != nil || res.StatusCode != http.StatusCreated {
+		t.Fatalf("adding a book failed: %v %v", err, res)
+	}
+	res.Body.Close()
+
+	// an open event stream must not hold the shutdown up
+	stream, err := client.Get(url + "/books/events")
+	if err != nil {
+		t.Fatalf("opening the event stream failed: %v", err)
+	}
+	defer stream.Body.Close()
+
+	stop()
+	if err := waitFor(t, result); err != nil {
+		t.Fatalf("expected a clean shutdown, got %v", err)
+	}
+	if _, err := client.Get(url + "/books"); err == nil {
+		t.Errorf("expected the server to stop accepting requests")
+	}
+
+	// the file store was closed, so reopening it finds the book
+	reopened, err := models.NewRepository("file", dir)
+	if err != nil {
+		t.Fatalf("reopening the store failed: %v", err)
+	}
+	defer reopened.(*models.FileStore).Close()
+	books, _ := reopened.GetAllBooks(context.Background())
+	if !slices.ContainsFunc(books, func(book models.Books) bool { return book.Title == "Kept" }) {
+		t.Errorf("expected the added book to survive, got %v", books)
+	}
+}
+
+type closeRecorder struct{ closed bool }
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestServerDrainsRequests(t *testing.T) {
+	testCases := []struct {
+		name            string
+		shutdownTimeout time.Duration
+		expectClean     bool
+	}{
+		{name: "Finishes In Time", shutdownTimeout: 2 * time.Second, expectClean: true},
+		{name: "Cut Off", shutdownTimeout: 50 * time.Millisecond, expectClean: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			started := make(chan struct{})
+			store := &closeRecorder{}
+			hookRan := false
+			server := &Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					close(started)
+					time.Sleep(300 * time.Millisecond)
+					w.Write([]byte("done"))
+				}),
+				ShutdownTimeout: tc.shutdownTimeout,
+				OnShutdown:      []func(){func() { hookRan = true }},
+				Closers:         []io.Closer{store},
+			}
+			url, stop, result := serve(t, server)
+
+			response := make(chan error, 1)
+			go func() {
+				res, err := (&http.Client{Transport: &http.Transport{DisableKeepAlives: true}}).Get(url)
+				if err == nil {
+					res.Body.Close()
+				}
+				response <- err
+			}()
+
+			<-started
+			stop()
+			err := waitFor(t, result)
+			requestErr := <-response
+
+			if tc.expectClean && (err != nil || requestErr != nil) {
+				t.Errorf("expected the request to finish and a clean shutdown, got %v and %v", requestErr, err)
+			}
+			if !tc.expectClean && (err == nil || requestErr == nil) {
+				t.Errorf("expected the request to be cut off and an error, got %v and %v", requestErr, err)
+			}
+			if !hookRan || !store.closed {
+				t.Errorf("expected the shutdown hook to run and the store to be closed")
+			}
+		})
+	}
+}
+
+func TestNewServerSeeds(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.Mode = "test"
+	cfg.Store.Backend = "sqlite"
+	cfg.Store.Path = filepath.Join(dir, "books.db")
+	cfg.SeedPath = filepath.Join("fixtures", "demo_books.csv")
+
+	// the second start finds books already there and leaves them be
+	for range 2 {
+		server, err := NewServer(cfg)
+		if err != nil {
+			t.Fatalf("NewServer() failed: %v", err)
+		}
+		res := httptest.NewRecorder()
+		server.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/books", nil))
+		if res.Code != http.StatusOK || strings.Count(res.Body.String(), `"title"`) != 3 {
+			t.Errorf("expected the 3 demo books, got %d %s", res.Code, res.Body)
+		}
+		if err := server.close(); err != nil {
+			t.Fatalf("closing the store failed: %v", err)
+		}
+	}
+
+	cfg.SeedPath = filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(cfg.SeedPath, []byte(`[{"title": "Emma"}]`), 0o600); err != nil {
+		t.Fatalf("writing the seed file failed: %v", err)
+	}
+	var seedErr *models.SeedError
+	if _, err := NewServer(cfg); !errors.As(err, &seedErr) || seedErr.Rows[0].Row != 1 {
+		t.Errorf("expected the bad row to be reported, got %v", err)
+	}
+}
+
+func TestAddBookRetriedWithIdempotencyKey(t *testing.T) {
+	cfg := config.Default()
+	cfg.Mode = "test"
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer() failed: %v", err)
+	}
+	t.Cleanup(func() { server.close() })
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/books", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", "3f2c1a9e-retry")
+		res := httptest.NewRecorder()
+		server.Handler.ServeHTTP(res, req)
+		return res
+	}
+	book := `{"title": "Emma", "author": "Jane Austen", "genre": "Fiction", "rating": 3.9}`
+
+	first, retry := post(book), post(book)
+	if first.Code != http.StatusCreated || retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Fatalf("expected the retry to replay the 201, got %d %s then %d %s", first.Code, first.Body, retry.Code, retry.Body)
+	}
+	if res := post(strings.Replace(book, "Emma", "Persuasion", 1)); res.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for the key with another book, got %d", res.Code)
+	}
+
+	res := httptest.NewRecorder()
+	server.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/books", nil))
+	if n := strings.Count(res.Body.String(), `"title"`); n != 1 {
+		t.Errorf("expected one book to be added, got %d: %s", n, res.Body)
+	}
+}
+
+func TestRateLimitIgnoresForwardedFor(t *testing.T) {
+	cfg := config.Default()
+	cfg.Mode = "test"
+	cfg.RateLimit.IP = config.Quota{Burst: 1, Refill: 1.0 / 3600}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer() failed: %v", err)
+	}
+	t.Cleanup(func() { server.close() })
+
+	var codes []int
+	for i := range 3 {
+		req := httptest.NewRequest(http.MethodGet, "/books", nil)
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i+1))
+		res := httptest.NewRecorder()
+		server.Handler.ServeHTTP(res, req)
+		codes = append(codes, res.Code)
+	}
+	if !slices.Equal(codes, []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}) {
+		t.Errorf("expected one request through from the one address, got %v", codes)
+	}
+}
+
+type orderCloser struct{ order *[]string }
+
+func (c orderCloser) Close() error {
+	*c.order = append(*c.order, "close")
+	return nil
+}
+
+// TestServerStopsBeforeClosing checks every way out stops the background
+// work before the store is closed under it.
+func TestServerStopsBeforeClosing(t *testing.T) {
+	testCases := []struct {
+		name string
+		run  func(server *Server) error
+	}{
+		{name: "Listen Fails", run: func(server *Server) error {
+			server.Addr = "not an address"
+			return server.Run(context.Background())
+		}},
+		{name: "Listener Fails", run: func(server *Server) error {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("listening failed: %v", err)
+			}
+			listener.Close()
+			return server.Serve(context.Background(), listener)
+		}},
+		{name: "Closed Directly", run: func(server *Server) error {
+			server.close()
+			return errors.New("closed")
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var order []string
+			server := &Server{
+				Handler:     http.NotFoundHandler(),
+				BeforeClose: []func(){func() { order = append(order, "stop") }},
+				Closers:     []io.Closer{orderCloser{&order}},
+			}
+			if err := tc.run(server); err == nil {
+				t.Fatalf("expected the server to fail")
+			}
+			if !slices.Equal(order, []string{"stop", "close"}) {
+				t.Errorf("expected the work stopped and then the store closed, got %v", order)
+			}
+		})
+	}
+}